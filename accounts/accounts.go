@@ -0,0 +1,232 @@
+// Package accounts manages the MEDS staff accounts: the first-run setup of
+// a new installation and the optional demo accounts.
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	pbmigrations "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/migrate"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Staff roles stored in users.role.
+const (
+	RoleProvider = "provider"
+	RolePharmacy = "pharmacy"
+	RoleAdmin    = "admin"
+)
+
+// Roles lists the staff roles.
+var Roles = []string{RoleProvider, RolePharmacy, RoleAdmin}
+
+// MinPasswordLength is the minimum length of the passwords chosen during
+// the setup. It matches the PocketBase admin password rules.
+const MinPasswordLength = 10
+
+// ErrSetupDone is returned by Setup.Run when the installation already has a
+// superuser and a clinic admin.
+var ErrSetupDone = errors.New("the first-run setup was already completed")
+
+// Setup holds the first accounts of a new installation.
+type Setup struct {
+	// SuperuserEmail and SuperuserPassword are the PocketBase admin (/_/)
+	// credentials.
+	SuperuserEmail    string `json:"superuserEmail"`
+	SuperuserPassword string `json:"superuserPassword"`
+
+	// AdminName, AdminEmail and AdminPassword are the first MEDS user with
+	// the admin role.
+	AdminName     string `json:"adminName"`
+	AdminEmail    string `json:"adminEmail"`
+	AdminPassword string `json:"adminPassword"`
+}
+
+// Pending reports which first-run accounts are still missing.
+type Pending struct {
+	Superuser bool
+	Admin     bool
+}
+
+// Any reports whether the first-run setup still has to run.
+func (p Pending) Any() bool {
+	return p.Superuser || p.Admin
+}
+
+// Migrate applies the pending app migrations, so that the setup can run
+// before the server is started.
+func Migrate(app core.App) error {
+	runner, err := migrate.NewRunner(app.DB(), pbmigrations.AppMigrations)
+	if err != nil {
+		return err
+	}
+
+	_, err = runner.Up()
+
+	return err
+}
+
+// Check returns the first-run accounts that don't exist yet.
+func Check(dao *daos.Dao) (Pending, error) {
+	pending := Pending{}
+
+	superusers, err := dao.TotalAdmins()
+	if err != nil {
+		return pending, err
+	}
+	pending.Superuser = superusers == 0
+
+	var admins int
+	err = dao.RecordQuery("users").
+		Select("count(*)").
+		AndWhere(dbx.HashExp{"role": RoleAdmin, "deactivated": false}).
+		Row(&admins)
+	if err != nil {
+		return pending, err
+	}
+	pending.Admin = admins == 0
+
+	return pending, nil
+}
+
+// Validate checks the accounts required by pending.
+func (s Setup) Validate(pending Pending) error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.SuperuserEmail, validation.When(pending.Superuser, validation.Required, is.EmailFormat)),
+		validation.Field(&s.SuperuserPassword, validation.When(pending.Superuser, validation.Required, validation.Length(MinPasswordLength, 72))),
+		validation.Field(&s.AdminName, validation.When(pending.Admin, validation.Required, validation.Length(1, 100))),
+		validation.Field(&s.AdminEmail, validation.When(pending.Admin, validation.Required, is.EmailFormat)),
+		validation.Field(&s.AdminPassword, validation.When(pending.Admin, validation.Required, validation.Length(MinPasswordLength, 72))),
+	)
+}
+
+// Run creates the missing first-run accounts in a single transaction and
+// makes the clinic admin the editor of the default settings.
+func (s Setup) Run(dao *daos.Dao) error {
+	return dao.RunInTransaction(func(txDao *daos.Dao) error {
+		pending, err := Check(txDao)
+		if err != nil {
+			return err
+		}
+		if !pending.Any() {
+			return ErrSetupDone
+		}
+
+		if err := s.Validate(pending); err != nil {
+			return err
+		}
+
+		if pending.Superuser {
+			superuser := &models.Admin{}
+			superuser.Email = strings.TrimSpace(s.SuperuserEmail)
+			if err := superuser.SetPassword(s.SuperuserPassword); err != nil {
+				return err
+			}
+			if err := txDao.SaveAdmin(superuser); err != nil {
+				return err
+			}
+		}
+
+		if !pending.Admin {
+			return nil
+		}
+
+		admin, err := NewUser(txDao, s.AdminName, s.AdminEmail, RoleAdmin, s.AdminPassword)
+		if err != nil {
+			return err
+		}
+
+		settings, err := txDao.FindFirstRecordByFilter("settings", "updated_by = ''")
+		if err == nil {
+			settings.Set("updated_by", admin.Id)
+			return txDao.SaveRecord(settings)
+		}
+
+		return nil
+	})
+}
+
+// NewUser creates a verified MEDS user. The username is derived from the
+// email address.
+func NewUser(dao *daos.Dao, name, email, role, password string) (*models.Record, error) {
+	name = strings.TrimSpace(name)
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	err := validation.Errors{
+		"name":     validation.Validate(name, validation.Length(0, 100)),
+		"email":    validation.Validate(email, validation.Required, is.EmailFormat),
+		"role":     validation.Validate(role, validation.Required, validation.In(toAny(Roles)...)),
+		"password": validation.Validate(password, validation.Required, validation.Length(MinPasswordLength, 72)),
+	}.Filter()
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := dao.FindCollectionByNameOrId("users")
+	if err != nil {
+		return nil, err
+	}
+
+	if !dao.IsRecordValueUnique(users.Id, "email", email) {
+		return nil, fmt.Errorf("a user with the email %s already exists", email)
+	}
+
+	user := models.NewRecord(users)
+	user.Set("name", name)
+	user.Set("role", role)
+	user.Set("verified", true)
+	user.Set("emailVisibility", true)
+	if err := user.SetEmail(email); err != nil {
+		return nil, err
+	}
+	if err := user.SetUsername(uniqueUsername(dao, users, email)); err != nil {
+		return nil, err
+	}
+	if err := user.SetPassword(password); err != nil {
+		return nil, err
+	}
+
+	if err := dao.SaveRecord(user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^\w\.]`)
+
+// uniqueUsername derives a free username from the local part of email.
+func uniqueUsername(dao *daos.Dao, users *models.Collection, email string) string {
+	base := usernameInvalidChars.ReplaceAllString(strings.Split(email, "@")[0], "")
+	if len(base) < 3 {
+		base = "user" + base
+	}
+
+	username := base
+	for i := 2; !dao.IsRecordValueUnique(users.Id, "username", username); i++ {
+		username = fmt.Sprintf("%s%d", base, i)
+		if i > 100 {
+			username = base + security.RandomStringWithAlphabet(5, "123456789")
+		}
+	}
+
+	return username
+}
+
+// toAny converts values for validation.In.
+func toAny(values []string) []any {
+	result := make([]any, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+	return result
+}
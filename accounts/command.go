@@ -0,0 +1,152 @@
+package accounts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// NewSetupCommand returns the "setup" command that runs the first-run
+// setup of a headless server. Values missing from the flags are prompted
+// for on the terminal.
+func NewSetupCommand(app core.App) *cobra.Command {
+	setup := Setup{}
+
+	command := &cobra.Command{
+		Use:          "setup",
+		Short:        "Creates the superuser and the first clinic admin of a new installation",
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			if err := Migrate(app); err != nil {
+				return err
+			}
+
+			pending, err := Check(app.Dao())
+			if err != nil {
+				return err
+			}
+			if !pending.Any() {
+				fmt.Println("The setup was already completed, manage the accounts in the Admin UI.")
+				return nil
+			}
+
+			prompt := newPrompter()
+			if pending.Superuser {
+				prompt.section("Superuser (PocketBase Admin UI)")
+				prompt.text(&setup.SuperuserEmail, "  Email: ")
+				prompt.password(&setup.SuperuserPassword, "  Password: ")
+			}
+			if pending.Admin {
+				prompt.section("Clinic admin (MEDS application)")
+				prompt.text(&setup.AdminName, "  Name: ")
+				prompt.text(&setup.AdminEmail, "  Email: ")
+				prompt.password(&setup.AdminPassword, "  Password: ")
+			}
+			if prompt.err != nil {
+				return prompt.err
+			}
+
+			if err := setup.Run(app.Dao()); err != nil {
+				return err
+			}
+
+			fmt.Println("Setup completed.")
+
+			return nil
+		},
+	}
+
+	command.Flags().StringVar(&setup.SuperuserEmail, "superuser-email", "", "email of the PocketBase superuser")
+	command.Flags().StringVar(&setup.SuperuserPassword, "superuser-password", "", "password of the PocketBase superuser")
+	command.Flags().StringVar(&setup.AdminName, "admin-name", "", "name of the first clinic admin")
+	command.Flags().StringVar(&setup.AdminEmail, "admin-email", "", "email of the first clinic admin")
+	command.Flags().StringVar(&setup.AdminPassword, "admin-password", "", "password of the first clinic admin")
+
+	return command
+}
+
+// prompter asks for the values that were not given as flags.
+// The first error stops all further prompts.
+type prompter struct {
+	reader *bufio.Reader
+	header string
+	err    error
+}
+
+func newPrompter() *prompter {
+	return &prompter{reader: bufio.NewReader(os.Stdin)}
+}
+
+// section sets the header printed before the next prompt.
+func (p *prompter) section(header string) {
+	p.header = header
+}
+
+func (p *prompter) printLabel(label string) {
+	if p.header != "" {
+		fmt.Println(p.header)
+		p.header = ""
+	}
+
+	fmt.Print(label)
+}
+
+func (p *prompter) text(value *string, label string) {
+	if p.err != nil || *value != "" {
+		return
+	}
+
+	p.printLabel(label)
+
+	line, err := p.reader.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Println()
+		p.err = errors.New("missing input, pass the values as flags when running without a terminal")
+		return
+	}
+
+	*value = strings.TrimSpace(line)
+}
+
+func (p *prompter) password(value *string, label string) {
+	if p.err != nil || *value != "" {
+		return
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		p.text(value, label)
+		return
+	}
+
+	for {
+		p.printLabel(label)
+		first, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			p.err = err
+			return
+		}
+
+		fmt.Print("  Repeat password: ")
+		second, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			p.err = err
+			return
+		}
+
+		if string(first) == string(second) {
+			*value = string(first)
+			return
+		}
+
+		fmt.Println("  The passwords don't match, try again.")
+	}
+}
@@ -0,0 +1,111 @@
+package accounts
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// demoPassword is the password of every demo account.
+const demoPassword = "password123"
+
+// demoSuperuser is the PocketBase admin of the demo accounts.
+const demoSuperuser = "user@example.com"
+
+// demoUser is a MEDS user of the demo accounts.
+type demoUser struct {
+	Username string
+	Role     string
+}
+
+// demoUsers returns the MEDS users of the demo accounts.
+func demoUsers() []demoUser {
+	users := []demoUser{{"provider", RoleProvider}}
+	for i := 2; i <= 6; i++ {
+		users = append(users, demoUser{fmt.Sprintf("provider%d", i), RoleProvider})
+	}
+
+	users = append(users, demoUser{"pharmacyuser", RolePharmacy})
+	for i := 2; i <= 4; i++ {
+		users = append(users, demoUser{fmt.Sprintf("pharmacyuser%d", i), RolePharmacy})
+	}
+
+	return append(users, demoUser{"admin", RoleAdmin})
+}
+
+// SeedDemo creates the missing demo accounts, all with a well-known
+// password. They are meant for trainings and testing only and must never
+// exist on an installation holding real patient data.
+func SeedDemo(dao *daos.Dao) error {
+	return dao.RunInTransaction(func(txDao *daos.Dao) error {
+		if _, err := txDao.FindAdminByEmail(demoSuperuser); err != nil {
+			superuser := &models.Admin{}
+			superuser.Email = demoSuperuser
+			if err := superuser.SetPassword(demoPassword); err != nil {
+				return err
+			}
+			if err := txDao.SaveAdmin(superuser); err != nil {
+				return err
+			}
+		}
+
+		users, err := txDao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		for _, demo := range demoUsers() {
+			email := demo.Username + "@example.com"
+			if _, err := txDao.FindAuthRecordByEmail(users.Id, email); err == nil {
+				continue
+			}
+
+			record := models.NewRecord(users)
+			record.Set("username", demo.Username)
+			record.Set("email", email)
+			record.Set("emailVisibility", true)
+			record.Set("role", demo.Role)
+			record.Set("verified", true)
+			if err := record.SetPassword(demoPassword); err != nil {
+				return err
+			}
+			if err := txDao.SaveRecord(record); err != nil {
+				return err
+			}
+		}
+
+		settings, err := txDao.FindFirstRecordByFilter("settings", "updated_by = ''")
+		if err != nil {
+			return nil
+		}
+
+		admin, err := txDao.FindAuthRecordByEmail(users.Id, "admin@example.com")
+		if err != nil {
+			return err
+		}
+		settings.Set("updated_by", admin.Id)
+
+		return txDao.SaveRecord(settings)
+	})
+}
+
+// DemoAccountsInUse returns the emails of the demo accounts that still
+// accept the demo password.
+func DemoAccountsInUse(dao *daos.Dao) []string {
+	var found []string
+
+	if superuser, err := dao.FindAdminByEmail(demoSuperuser); err == nil && superuser.ValidatePassword(demoPassword) {
+		found = append(found, demoSuperuser)
+	}
+
+	for _, demo := range demoUsers() {
+		email := demo.Username + "@example.com"
+		user, err := dao.FindAuthRecordByEmail("users", email)
+		if err == nil && user.ValidatePassword(demoPassword) {
+			found = append(found, email)
+		}
+	}
+
+	return found
+}
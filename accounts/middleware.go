@@ -0,0 +1,31 @@
+package accounts
+
+import (
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/list"
+)
+
+// RequireRole only allows requests from PocketBase admins and from users
+// whose role is one of roles.
+func RequireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if admin, _ := c.Get(apis.ContextAdminKey).(*models.Admin); admin != nil {
+				return next(c)
+			}
+
+			user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if user == nil {
+				return apis.NewUnauthorizedError("The request requires valid record authorization token to be set.", nil)
+			}
+
+			if !list.ExistInSlice(user.GetString("role"), roles) {
+				return apis.NewForbiddenError("You are not allowed to perform this request.", nil)
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package accounts
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// generatedPasswordLength is the length of the passwords generated for
+// imported users without one.
+const generatedPasswordLength = 12
+
+// List returns all MEDS users ordered by role and name.
+func List(dao *daos.Dao) ([]*models.Record, error) {
+	users, err := dao.FindRecordsByExpr("users")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if a.GetString("role") != b.GetString("role") {
+			return a.GetString("role") < b.GetString("role")
+		}
+		return strings.ToLower(DisplayName(a)) < strings.ToLower(DisplayName(b))
+	})
+
+	return users, nil
+}
+
+// DisplayName returns the name of user, falling back to the username.
+func DisplayName(user *models.Record) string {
+	if name := user.GetString("name"); name != "" {
+		return name
+	}
+
+	return user.Username()
+}
+
+// ResetPassword sets a new password for a user. All sessions of the user
+// are signed out.
+func ResetPassword(dao *daos.Dao, userId, password string) error {
+	if err := validation.Validate(password, validation.Required, validation.Length(MinPasswordLength, 72)); err != nil {
+		return fmt.Errorf("password: %w", err)
+	}
+
+	user, err := dao.FindRecordById("users", userId)
+	if err != nil {
+		return err
+	}
+
+	if err := user.SetPassword(password); err != nil {
+		return err
+	}
+
+	return dao.SaveRecord(user)
+}
+
+// SetDeactivated deactivates or reactivates a user. Deactivated users can
+// no longer sign in and are signed out right away. The last active admin
+// can't be deactivated.
+func SetDeactivated(dao *daos.Dao, userId string, deactivated bool) error {
+	return dao.RunInTransaction(func(txDao *daos.Dao) error {
+		user, err := txDao.FindRecordById("users", userId)
+		if err != nil {
+			return err
+		}
+
+		if deactivated && user.GetString("role") == RoleAdmin && !user.GetBool("deactivated") {
+			var admins int
+			err := txDao.RecordQuery("users").
+				Select("count(*)").
+				AndWhere(dbx.HashExp{"role": RoleAdmin, "deactivated": false}).
+				Row(&admins)
+			if err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return errors.New("the last active admin can't be deactivated")
+			}
+		}
+
+		user.Set("deactivated", deactivated)
+		if deactivated {
+			if err := user.RefreshTokenKey(); err != nil {
+				return err
+			}
+		}
+
+		return txDao.SaveRecord(user)
+	})
+}
+
+// ImportedUser is a user created by ImportCSV.
+type ImportedUser struct {
+	Name  string
+	Email string
+	Role  string
+
+	// Password is only set when it was generated by the import.
+	Password string
+}
+
+// ImportIssue is a roster row that ImportCSV skipped.
+type ImportIssue struct {
+	Line   int
+	Email  string
+	Reason string
+}
+
+// ImportResult reports the outcome of ImportCSV.
+type ImportResult struct {
+	Created []ImportedUser
+	Skipped []ImportIssue
+}
+
+// GeneratedPasswords reports whether passwords were generated for any of
+// the created users.
+func (r *ImportResult) GeneratedPasswords() bool {
+	for _, user := range r.Created {
+		if user.Password != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WriteCredentials writes the created users together with their generated
+// passwords as CSV, so that they can be handed out to the volunteers.
+func (r *ImportResult) WriteCredentials(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write([]string{"name", "email", "role", "password"}); err != nil {
+		return err
+	}
+
+	for _, user := range r.Created {
+		if err := writer.Write([]string{user.Name, user.Email, user.Role, user.Password}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// ImportCSV creates users from a volunteer roster. The first row must name
+// the columns: email and role are required, name and password are
+// optional. A password is generated for the rows without one.
+// Invalid rows and existing emails are skipped and reported.
+func ImportCSV(dao *daos.Dao, r io.Reader) (*ImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CSV header: %w", err)
+	}
+
+	// spreadsheet exports often start with a byte order mark
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))] = i
+	}
+	for _, required := range []string{"email", "role"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("the CSV has no %q column", required)
+		}
+	}
+
+	value := func(row []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	result := &ImportResult{}
+
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				result.Skipped = append(result.Skipped, ImportIssue{Line: parseErr.Line, Reason: parseErr.Err.Error()})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			line, _ := reader.FieldPos(0)
+
+			imported := ImportedUser{
+				Name:  value(row, "name"),
+				Email: strings.ToLower(value(row, "email")),
+				Role:  strings.ToLower(value(row, "role")),
+			}
+			if imported.Email == "" && imported.Name == "" && imported.Role == "" {
+				continue // blank line
+			}
+
+			password := value(row, "password")
+			if password == "" {
+				password = security.RandomString(generatedPasswordLength)
+				imported.Password = password
+			}
+
+			if _, err := NewUser(txDao, imported.Name, imported.Email, imported.Role, password); err != nil {
+				result.Skipped = append(result.Skipped, ImportIssue{Line: line, Email: imported.Email, Reason: err.Error()})
+				continue
+			}
+
+			result.Created = append(result.Created, imported)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,314 @@
+// Package backup creates and restores consistent MEDS database backups.
+//
+// A backup is a single zip archive with a SQLite snapshot of the data
+// database (taken with VACUUM INTO), a copy of the uploaded files and a
+// manifest.json describing the snapshot.
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/archive"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// FormatVersion is the version of the archive layout written by Create.
+const FormatVersion = 1
+
+const (
+	manifestName = "manifest.json"
+	databaseName = "data.db"
+)
+
+// keptDirs are the data directory entries that belong to the installation
+// rather than to its data. They are not part of the backups and a restore
+// keeps the current ones.
+var keptDirs = []string{
+	core.LocalBackupsDirName,
+	"tls",      // the local CA installed on the devices
+	"transfer", // the bundle signing key and the trusted keys
+}
+
+// Manifest describes the content of a backup archive.
+type Manifest struct {
+	Format        int              `json:"format"`
+	CreatedAt     time.Time        `json:"created_at"`
+	SchemaVersion string           `json:"schema_version"`
+	Migrations    int              `json:"migrations"`
+	RecordCounts  map[string]int64 `json:"record_counts"`
+}
+
+// Create writes a consistent backup of the app data to a zip archive at dest.
+//
+// The snapshot is taken while holding the write connection, so concurrent
+// writes wait until the database has been copied.
+func Create(app core.App, dest string) (*Manifest, error) {
+	tempDir := filepath.Join(app.DataDir(), core.LocalTempDirName)
+	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create a temp dir: %w", err)
+	}
+
+	stageDir := filepath.Join(tempDir, "meds_backup_"+security.PseudorandomString(6))
+	if err := os.MkdirAll(stageDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create a temp dir: %w", err)
+	}
+	defer os.RemoveAll(stageDir)
+
+	manifest := &Manifest{
+		Format:       FormatVersion,
+		CreatedAt:    time.Now().UTC(),
+		RecordCounts: map[string]int64{},
+	}
+
+	// all writes go through the nonconcurrent connection, so holding it
+	// keeps the counts and the snapshot in agreement
+	err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		if err := describe(txDao, manifest); err != nil {
+			return err
+		}
+
+		_, err := app.Dao().ConcurrentDB().NewQuery("VACUUM INTO {:path}").
+			Bind(dbx.Params{"path": filepath.Join(stageDir, databaseName)}).
+			Execute()
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot the database: %w", err)
+	}
+
+	// uploaded files are never modified in place, so a plain copy is enough
+	storageDir := filepath.Join(app.DataDir(), core.LocalStorageDirName)
+	if _, err := os.Stat(storageDir); err == nil {
+		if err := copyDir(storageDir, filepath.Join(stageDir, core.LocalStorageDirName)); err != nil {
+			return nil, fmt.Errorf("failed to copy the uploaded files: %w", err)
+		}
+	}
+
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(stageDir, manifestName), raw, 0644); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Create(stageDir, dest); err != nil {
+		return nil, fmt.Errorf("failed to create the backup archive: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// Write creates a backup (see Create) and copies the archive to w.
+func Write(app core.App, w io.Writer) (*Manifest, error) {
+	tempDir := filepath.Join(app.DataDir(), core.LocalTempDirName)
+	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create a temp dir: %w", err)
+	}
+
+	tempPath := filepath.Join(tempDir, "meds_backup_"+security.PseudorandomString(6)+".zip")
+	defer os.Remove(tempPath)
+
+	manifest, err := Create(app, tempPath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(tempPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// describe fills the schema version and the record counts of manifest.
+func describe(dao *daos.Dao, manifest *Manifest) error {
+	err := dao.DB().
+		Select("COALESCE(MAX([[file]]), '')").
+		From("_migrations").
+		Row(&manifest.SchemaVersion)
+	if err != nil {
+		return err
+	}
+
+	err = dao.DB().
+		Select("COUNT(*)").
+		From("_migrations").
+		Row(&manifest.Migrations)
+	if err != nil {
+		return err
+	}
+
+	collections := []*models.Collection{}
+	if err := dao.CollectionQuery().All(&collections); err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		if collection.IsView() {
+			continue
+		}
+
+		var total int64
+		err := dao.DB().
+			Select("COUNT(*)").
+			From(collection.Name).
+			Row(&total)
+		if err != nil {
+			return err
+		}
+
+		manifest.RecordCounts[collection.Name] = total
+	}
+
+	return nil
+}
+
+// ReadManifest returns the manifest of the backup archive at src.
+func ReadManifest(src string) (*Manifest, error) {
+	tempDir, err := os.MkdirTemp("", "meds_manifest_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := archive.Extract(src, tempDir); err != nil {
+		return nil, fmt.Errorf("failed to read the backup archive: %w", err)
+	}
+
+	return readManifest(tempDir)
+}
+
+// readManifest loads and validates the manifest of an extracted backup.
+func readManifest(dir string) (*Manifest, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, errors.New("the archive is not a MEDS backup (missing manifest)")
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(raw, manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+
+	if manifest.Format < 1 || manifest.Format > FormatVersion {
+		return nil, fmt.Errorf("unsupported backup format %d", manifest.Format)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, databaseName)); err != nil {
+		return nil, errors.New("the backup archive has no database")
+	}
+
+	return manifest, nil
+}
+
+// Restore replaces the data directory with the content of the backup
+// archive at src. The server using dataDir must be stopped.
+//
+// The replaced data directory is kept next to it (with a ".pre-restore-"
+// suffix) and the keptDirs are carried over.
+func Restore(src string, dataDir string) (*Manifest, error) {
+	dataDir = filepath.Clean(dataDir)
+	suffix := time.Now().Format("20060102_150405")
+
+	restoreDir := dataDir + ".restore-" + suffix
+	if err := archive.Extract(src, restoreDir); err != nil {
+		os.RemoveAll(restoreDir)
+		return nil, fmt.Errorf("failed to extract the backup archive: %w", err)
+	}
+
+	manifest, err := readManifest(restoreDir)
+	if err != nil {
+		os.RemoveAll(restoreDir)
+		return nil, err
+	}
+
+	// the manifest is not part of the data directory
+	os.Remove(filepath.Join(restoreDir, manifestName))
+
+	oldDir := dataDir + ".pre-restore-" + suffix
+	if _, err := os.Stat(dataDir); err == nil {
+		if err := os.Rename(dataDir, oldDir); err != nil {
+			os.RemoveAll(restoreDir)
+			return nil, fmt.Errorf("failed to move the current data directory: %w", err)
+		}
+	} else {
+		oldDir = ""
+	}
+
+	if err := os.Rename(restoreDir, dataDir); err != nil {
+		if oldDir != "" {
+			os.Rename(oldDir, dataDir)
+		}
+		os.RemoveAll(restoreDir)
+		return nil, fmt.Errorf("failed to move the restored data directory: %w", err)
+	}
+
+	if oldDir != "" {
+		for _, name := range keptDirs {
+			kept := filepath.Join(oldDir, name)
+			if _, err := os.Stat(kept); err == nil {
+				os.Rename(kept, filepath.Join(dataDir, name))
+			}
+		}
+	}
+
+	return manifest, nil
+}
+
+// copyDir recursively copies the src directory to dst.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+
+		return copyFile(path, target)
+	})
+}
+
+// copyFile copies the src file to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -0,0 +1,374 @@
+package backup
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	fileNamePrefix = "meds_backup_"
+	fileNameLayout = "20060102_150405"
+)
+
+// Schedule configures the automatic backups.
+type Schedule struct {
+	// Dir is the directory the archives are written to
+	// (defaults to pb_data/backups).
+	Dir string `json:"dir"`
+
+	// Interval between two backups. Zero disables the periodic backups.
+	Interval time.Duration `json:"interval"`
+
+	// OnShutdown takes an extra backup when the server stops.
+	OnShutdown bool `json:"on_shutdown"`
+
+	// KeepDaily is the number of most recent days to keep a backup for.
+	KeepDaily int `json:"keep_daily"`
+
+	// KeepWeekly is the number of most recent weeks to keep a backup for.
+	KeepWeekly int `json:"keep_weekly"`
+}
+
+// DefaultSchedule returns the schedule used when nothing is configured.
+func DefaultSchedule() Schedule {
+	return Schedule{
+		Interval:   time.Hour,
+		OnShutdown: true,
+		KeepDaily:  7,
+		KeepWeekly: 4,
+	}
+}
+
+// String describes the schedule for humans.
+func (s Schedule) String() string {
+	if s.Interval <= 0 && !s.OnShutdown {
+		return "Disabled"
+	}
+
+	parts := []string{}
+	if s.Interval > 0 {
+		parts = append(parts, "every "+formatInterval(s.Interval))
+	}
+	if s.OnShutdown {
+		parts = append(parts, "at shutdown")
+	}
+
+	return fmt.Sprintf("%s, keeping %d daily and %d weekly", strings.Join(parts, " and "), s.KeepDaily, s.KeepWeekly)
+}
+
+// formatInterval formats d without the zero minute and second units
+// (eg. "6h" instead of "6h0m0s").
+func formatInterval(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+// Status reports the outcome of the latest automatic backup.
+type Status struct {
+	Schedule   Schedule
+	Dir        string
+	LastBackup time.Time
+	LastFile   string
+	LastError  error
+	NextBackup time.Time
+}
+
+// Scheduler takes backups of an app on a fixed interval (and optionally when
+// the app terminates) and prunes the old archives.
+type Scheduler struct {
+	app core.App
+
+	// OnChange, if set, is called after every status change.
+	OnChange func(Status)
+
+	mu       sync.Mutex
+	schedule Schedule
+	status   Status
+	reset    chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewScheduler creates a new (not yet started) scheduler for app.
+func NewScheduler(app core.App, schedule Schedule) *Scheduler {
+	return &Scheduler{
+		app:      app,
+		schedule: schedule,
+		reset:    make(chan struct{}, 1),
+	}
+}
+
+// Register creates a scheduler that starts together with the app web
+// server and stops (after the optional shutdown backup) on app termination.
+// Other commands (eg. migrate or setup) don't take a shutdown backup.
+func Register(app core.App, schedule Schedule) *Scheduler {
+	s := NewScheduler(app, schedule)
+
+	var served atomic.Bool
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		served.Store(true)
+		s.Start()
+		return nil
+	})
+
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		s.Stop()
+
+		if served.Load() && s.Schedule().OnShutdown && s.app.Dao() != nil {
+			s.Run()
+		}
+
+		return nil
+	})
+
+	return s
+}
+
+// Schedule returns the current schedule.
+func (s *Scheduler) Schedule() Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.schedule
+}
+
+// SetSchedule replaces the schedule, restarting the interval timer.
+func (s *Scheduler) SetSchedule(schedule Schedule) {
+	s.mu.Lock()
+	s.schedule = schedule
+	s.mu.Unlock()
+
+	select {
+	case s.reset <- struct{}{}:
+	default:
+	}
+
+	s.notify()
+}
+
+// Status returns the status of the latest backup.
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	status.Schedule = s.schedule
+	status.Dir = s.dir()
+
+	return status
+}
+
+// Start starts the interval loop. Calling Start on a running scheduler
+// does nothing.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+
+	go s.loop(s.stop)
+}
+
+// Stop stops the interval loop and waits for a running backup to finish.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(stop <-chan struct{}) {
+	defer s.wg.Done()
+
+	for {
+		interval := s.Schedule().Interval
+
+		var timer *time.Timer
+		var tick <-chan time.Time
+		next := time.Time{}
+		if interval > 0 {
+			timer = time.NewTimer(interval)
+			tick = timer.C
+			next = time.Now().Add(interval)
+		}
+
+		s.mu.Lock()
+		s.status.NextBackup = next
+		s.mu.Unlock()
+		s.notify()
+
+		select {
+		case <-stop:
+		case <-s.reset:
+		case <-tick:
+			s.Run()
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		select {
+		case <-stop:
+			s.mu.Lock()
+			s.status.NextBackup = time.Time{}
+			s.mu.Unlock()
+			s.notify()
+			return
+		default:
+		}
+	}
+}
+
+// Run takes a backup right away and prunes the old archives.
+// It returns the path of the new archive.
+func (s *Scheduler) Run() (string, error) {
+	s.mu.Lock()
+	schedule := s.schedule
+	dir := s.dir()
+	s.mu.Unlock()
+
+	path, err := s.run(dir, schedule)
+
+	s.mu.Lock()
+	s.status.LastBackup = time.Now()
+	s.status.LastFile = path
+	s.status.LastError = err
+	s.mu.Unlock()
+	s.notify()
+
+	if err != nil {
+		log.Printf("Automatic backup failed: %v", err)
+	} else {
+		log.Printf("Automatic backup written to %s", path)
+	}
+
+	return path, err
+}
+
+func (s *Scheduler) run(dir string, schedule Schedule) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create the backups directory: %w", err)
+	}
+
+	path := filepath.Join(dir, fileNamePrefix+time.Now().Format(fileNameLayout)+".zip")
+	if _, err := Create(s.app, path); err != nil {
+		return "", err
+	}
+
+	if err := Prune(dir, schedule.KeepDaily, schedule.KeepWeekly); err != nil {
+		return path, fmt.Errorf("backup created but pruning failed: %w", err)
+	}
+
+	return path, nil
+}
+
+// dir returns the configured backups directory. The caller must hold s.mu.
+func (s *Scheduler) dir() string {
+	if s.schedule.Dir != "" {
+		return s.schedule.Dir
+	}
+
+	return filepath.Join(s.app.DataDir(), core.LocalBackupsDirName)
+}
+
+func (s *Scheduler) notify() {
+	if s.OnChange != nil {
+		s.OnChange(s.Status())
+	}
+}
+
+// Prune deletes the automatic backups in dir except for the newest backup
+// of each of the keepDaily most recent days and of each of the keepWeekly
+// most recent weeks. Nothing is deleted when both limits are zero.
+func Prune(dir string, keepDaily, keepWeekly int) error {
+	if keepDaily <= 0 && keepWeekly <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type backupFile struct {
+		name    string
+		created time.Time
+	}
+
+	archives := []backupFile{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, fileNamePrefix) || !strings.HasSuffix(name, ".zip") {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, fileNamePrefix), ".zip")
+		created, err := time.ParseInLocation(fileNameLayout, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+
+		archives = append(archives, backupFile{name: name, created: created})
+	}
+
+	// newest first
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].created.After(archives[j].created)
+	})
+
+	keep := map[string]bool{}
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	for _, a := range archives {
+		day := a.created.Format("2006-01-02")
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep[a.name] = true
+		}
+
+		year, week := a.created.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[weekKey] && len(weeks) < keepWeekly {
+			weeks[weekKey] = true
+			keep[a.name] = true
+		}
+	}
+
+	for _, a := range archives {
+		if keep[a.name] {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, a.name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
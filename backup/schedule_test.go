@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// writeArchives creates empty files with the given names in a new temp dir.
+func writeArchives(t *testing.T, names ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// remaining lists the sorted file names left in dir.
+func remaining(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestPrune(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		files      []string
+		keepDaily  int
+		keepWeekly int
+		expected   []string
+	}{
+		{
+			name: "newest backup of the most recent days",
+			files: []string{
+				"meds_backup_20240304_090000.zip",
+				"meds_backup_20240304_180000.zip",
+				"meds_backup_20240303_120000.zip",
+				"meds_backup_20240302_120000.zip",
+			},
+			keepDaily: 2,
+			expected: []string{
+				"meds_backup_20240303_120000.zip",
+				"meds_backup_20240304_180000.zip",
+			},
+		},
+		{
+			// ISO weeks start on Monday: March 3rd 2024 is a Sunday
+			name: "newest backup of the most recent weeks",
+			files: []string{
+				"meds_backup_20240304_090000.zip",
+				"meds_backup_20240303_200000.zip",
+				"meds_backup_20240303_080000.zip",
+				"meds_backup_20240226_100000.zip",
+				"meds_backup_20240219_100000.zip",
+			},
+			keepWeekly: 2,
+			expected: []string{
+				"meds_backup_20240303_200000.zip",
+				"meds_backup_20240304_090000.zip",
+			},
+		},
+		{
+			name: "daily and weekly backups are combined",
+			files: []string{
+				"meds_backup_20240304_090000.zip",
+				"meds_backup_20240303_200000.zip",
+				"meds_backup_20240226_100000.zip",
+				"meds_backup_20240219_100000.zip",
+			},
+			keepDaily:  1,
+			keepWeekly: 2,
+			expected: []string{
+				"meds_backup_20240303_200000.zip",
+				"meds_backup_20240304_090000.zip",
+			},
+		},
+		{
+			name: "the year boundary is a week boundary",
+			files: []string{
+				"meds_backup_20250101_100000.zip",
+				"meds_backup_20241230_100000.zip",
+				"meds_backup_20241229_100000.zip",
+			},
+			keepWeekly: 1,
+			expected: []string{
+				"meds_backup_20250101_100000.zip",
+			},
+		},
+		{
+			name: "nothing is deleted without limits",
+			files: []string{
+				"meds_backup_20240304_090000.zip",
+				"meds_backup_20240303_200000.zip",
+			},
+			expected: []string{
+				"meds_backup_20240303_200000.zip",
+				"meds_backup_20240304_090000.zip",
+			},
+		},
+		{
+			name: "other files are left alone",
+			files: []string{
+				"meds_backup_20240304_090000.zip",
+				"meds_backup_20240303_200000.zip",
+				"meds_backup_manual.zip",
+				"pb_backup_20240301.zip",
+				"notes.txt",
+			},
+			keepDaily: 1,
+			expected: []string{
+				"meds_backup_20240304_090000.zip",
+				"meds_backup_manual.zip",
+				"notes.txt",
+				"pb_backup_20240301.zip",
+			},
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			dir := writeArchives(t, s.files...)
+
+			if err := Prune(dir, s.keepDaily, s.keepWeekly); err != nil {
+				t.Fatal(err)
+			}
+
+			got := remaining(t, dir)
+			if len(got) != len(s.expected) {
+				t.Fatalf("Expected %v, got %v", s.expected, got)
+			}
+			for i := range got {
+				if got[i] != s.expected[i] {
+					t.Fatalf("Expected %v, got %v", s.expected, got)
+				}
+			}
+		})
+	}
+}
@@ -21,7 +21,6 @@ import AuthGuard from './components/AuthGuard';
 import { authModelAtom, isLoadingAtom, useAuthChangeEffect } from './atoms/auth';
 import Settings from './pages/Settings';
 import Reports from './pages/Reports';
-import { ActiveEditorCleanup } from './components/ActiveEditorCleanup';
 import settingsService from './services/settingsService';
 
 const App: React.FC = () => {
@@ -61,7 +60,6 @@ const App: React.FC = () => {
     <ThemeProvider theme={theme}>
       <CssBaseline />
       <LocalizationProvider dateAdapter={AdapterDateFns}>
-        <ActiveEditorCleanup />
         <Routes>
           <Route 
             path="/" 
@@ -0,0 +1,143 @@
+import React, { useState } from 'react';
+import {
+  Box,
+  Typography,
+  Button,
+  Switch,
+  TextField,
+  Table,
+  TableBody,
+  TableCell,
+  TableHead,
+  TableRow,
+  Alert
+} from '@mui/material';
+import { pb } from '../atoms/auth';
+import { useRealtimeCollection } from '../hooks/useRealtimeCollection';
+import type { CareTeam } from '../types/queue';
+
+// Care teams are saved as soon as they are changed, unlike the other settings
+export const CareTeamSettings: React.FC = () => {
+  const { records: teams } = useRealtimeCollection<CareTeam>('care_teams', {
+    sort: 'name'
+  });
+  const [name, setName] = useState('');
+  const [specialty, setSpecialty] = useState('');
+  const [error, setError] = useState<string | null>(null);
+
+  const errorMessage = (err: any): string => {
+    const fieldErrors = err?.data?.data;
+    return (
+      fieldErrors?.name?.message ||
+      fieldErrors?.capacity?.message ||
+      err?.data?.message ||
+      'Failed to save the care team. Please try again.'
+    );
+  };
+
+  const updateTeam = async (team: CareTeam, data: Partial<CareTeam>) => {
+    try {
+      await pb.collection('care_teams').update(team.id, data);
+      setError(null);
+    } catch (err: any) {
+      console.error('Error updating care team:', err);
+      setError(errorMessage(err));
+    }
+  };
+
+  const handleAdd = async () => {
+    if (!name.trim()) return;
+
+    try {
+      await pb.collection('care_teams').create({
+        name: name.trim(),
+        specialty: specialty.trim(),
+        active: true,
+        capacity: 0
+      });
+      setName('');
+      setSpecialty('');
+      setError(null);
+    } catch (err: any) {
+      console.error('Error creating care team:', err);
+      setError(errorMessage(err));
+    }
+  };
+
+  return (
+    <Box>
+      {error && (
+        <Alert severity="error" sx={{ mb: 2 }}>
+          {error}
+        </Alert>
+      )}
+
+      <Table size="small">
+        <TableHead>
+          <TableRow>
+            <TableCell>Name</TableCell>
+            <TableCell>Specialty</TableCell>
+            <TableCell>Capacity</TableCell>
+            <TableCell>Active</TableCell>
+          </TableRow>
+        </TableHead>
+        <TableBody>
+          {teams.map(team => (
+            <TableRow key={team.id}>
+              <TableCell>{team.name}</TableCell>
+              <TableCell>{team.specialty}</TableCell>
+              <TableCell>
+                <TextField
+                  type="number"
+                  size="small"
+                  defaultValue={team.capacity || ''}
+                  placeholder="No limit"
+                  onBlur={(e) => {
+                    const value = parseInt(e.target.value);
+                    const capacity = isNaN(value) || value < 0 ? 0 : value;
+                    if (capacity !== team.capacity) {
+                      updateTeam(team, { capacity });
+                    }
+                  }}
+                  inputProps={{ min: 0 }}
+                  sx={{ width: 120 }}
+                />
+              </TableCell>
+              <TableCell>
+                <Switch
+                  checked={team.active}
+                  onChange={(e) => updateTeam(team, { active: e.target.checked })}
+                />
+              </TableCell>
+            </TableRow>
+          ))}
+        </TableBody>
+      </Table>
+
+      <Box sx={{ display: 'flex', gap: 2, mt: 2 }}>
+        <TextField
+          label="Team Name"
+          size="small"
+          value={name}
+          onChange={(e) => setName(e.target.value)}
+        />
+        <TextField
+          label="Specialty"
+          size="small"
+          placeholder="dental"
+          value={specialty}
+          onChange={(e) => setSpecialty(e.target.value)}
+        />
+        <Button variant="outlined" onClick={handleAdd} disabled={!name.trim()}>
+          Add Team
+        </Button>
+      </Box>
+
+      <Typography variant="body2" color="text.secondary" sx={{ mt: 1 }}>
+        Only active teams can be picked in the queue. The capacity limits the patients of the day waiting for or seen by a team, leave it empty for no limit. Changes are saved immediately.
+      </Typography>
+    </Box>
+  );
+};
+
+export default CareTeamSettings;
@@ -10,9 +10,10 @@ import {
   ListItem,
   ListItemText,
 } from '@mui/material';
-import type { DisbursementItem, MedicationRecord } from './DisbursementForm';
+import type { DisbursementItem } from './DisbursementForm';
 import { pb } from '../atoms/auth';
 import { RoleBasedAccess } from './RoleBasedAccess';
+import { getStockErrorMessage } from '../utils/disbursementUtils';
 import { Record } from 'pocketbase';
 
 interface ExistingDisbursement extends Record {
@@ -61,91 +62,46 @@ export const DisbursementConfirmation: React.FC<DisbursementConfirmationProps> =
       });
       const existingMap = new Map<string, ExistingDisbursement>(existingDisbursements.items.map(d => [d.id, d]));
 
-      // First, verify all stock levels
+      // Stock levels are checked and adjusted by the server when
+      // disbursements are created, updated or deleted.
       for (const disbursement of disbursements) {
         if (!disbursement.medication || !disbursement.quantity) continue;
 
-        const medication = await pb.collection('inventory').getOne(disbursement.medication) as MedicationRecord;
         const multiplier = getNumericMultiplier(disbursement.multiplier);
-        const quantity = disbursement.quantity * multiplier;
-
-        if (disbursement.id) {
-          const existing = existingMap.get(disbursement.id);
-          if (existing) {
-            // For existing disbursements, check if new quantity is more than original
-            const stockChange = quantity - existing.quantity;
-            if (stockChange > 0 && medication.stock < stockChange) {
-              throw new Error(`Not enough stock for ${medication.drug_name}. Need ${stockChange} more but only ${medication.stock} available.`);
-            }
-          }
-        } else {
-          // For new disbursements, check full quantity
-          if (medication.stock < quantity) {
-            throw new Error(`Not enough stock for ${medication.drug_name}. Need ${quantity} but only ${medication.stock} available.`);
-          }
-        }
-      }
-
-      // Process all disbursements
-      for (const disbursement of disbursements) {
-        if (!disbursement.medication || !disbursement.quantity) continue;
-
-        const medication = await pb.collection('inventory').getOne(disbursement.medication) as MedicationRecord;
-        const multiplier = getNumericMultiplier(disbursement.multiplier);
-        const quantity = disbursement.quantity * multiplier;
 
         if (disbursement.id) {
           // Update existing disbursement
           const existing = existingMap.get(disbursement.id);
           if (existing) {
             existingMap.delete(disbursement.id);
-            
+
             // Only update if quantity or notes changed
-            if (existing.quantity !== quantity || existing.notes !== disbursement.notes) {
-              // Calculate stock change based on difference from original quantity
-              const stockChange = quantity - existing.quantity;
-              const newStock = medication.stock - stockChange;
-              
-              // Update inventory stock only if quantity changed
-              if (stockChange !== 0) {
-                await pb.collection('inventory').update(disbursement.medication, {
-                  stock: newStock
-                });
-              }
-              
-              // Update disbursement
+            if (
+              existing.quantity !== disbursement.quantity ||
+              existing.multiplier !== multiplier ||
+              existing.notes !== disbursement.notes
+            ) {
               await pb.collection('disbursements').update(disbursement.id, {
-                quantity: quantity,
+                quantity: disbursement.quantity,
+                multiplier: multiplier,
                 notes: disbursement.notes || ''
               });
             }
           }
         } else {
-          // Create new disbursement
-          // Update inventory stock
-          await pb.collection('inventory').update(disbursement.medication, {
-            stock: medication.stock - quantity
-          });
-          
           // Create disbursement record
           await pb.collection('disbursements').create({
             encounter: encounterId,
             medication: disbursement.medication,
-            quantity: quantity,
+            quantity: disbursement.quantity,
+            multiplier: multiplier,
             notes: disbursement.notes || '',
           });
         }
       }
 
-      // Handle deleted disbursements - restore their stock
-      for (const [id, disbursement] of Array.from(existingMap.entries())) {
-        const medication = await pb.collection('inventory').getOne(disbursement.medication) as MedicationRecord;
-        // Restore stock for deleted disbursement
-        await pb.collection('inventory').update(disbursement.medication, {
-          stock: medication.stock + disbursement.quantity
-        });
-        
-        // Delete the disbursement record
+      // Handle deleted disbursements - the server restores their stock
+      for (const id of Array.from(existingMap.keys())) {
         await pb.collection('disbursements').delete(id);
       }
 
@@ -158,7 +114,7 @@ export const DisbursementConfirmation: React.FC<DisbursementConfirmationProps> =
       onConfirm();
     } catch (error) {
       console.error('Error processing disbursements:', error);
-      alert('Failed to process disbursements: ' + (error as Error).message);
+      alert('Failed to process disbursements: ' + getStockErrorMessage(error));
     } finally {
       setProcessing(false);
     }
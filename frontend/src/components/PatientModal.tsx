@@ -106,18 +106,15 @@ export const PatientModal: React.FC<PatientModalProps> = ({
   });
 
   const [addToQueue, setAddToQueue] = useState(true);
-  const [lineNumber, setLineNumber] = useState<number | ''>('');
   const [useManualAge, setUseManualAge] = useState(false);
   const [dateValue, setDateValue] = useState<Date | null>(null);
   
   // Add validation state
   const [validationErrors, setValidationErrors] = useState({
-    gender: false,
-    lineNumber: false
+    gender: false
   });
   const [touched, setTouched] = useState({
-    gender: false,
-    lineNumber: false
+    gender: false
   });
 
   const [chiefComplaints, setChiefComplaints] = useState<ChiefComplaint[]>([]);
@@ -423,21 +420,18 @@ export const PatientModal: React.FC<PatientModalProps> = ({
     try {
       // Validate required fields
       const newValidationErrors = {
-        gender: !formData.gender,
-        lineNumber: (mode === 'create' || mode === 'new_encounter') && addToQueue && !lineNumber
+        gender: !formData.gender
       };
       
       setValidationErrors(newValidationErrors);
       setTouched({
-        gender: true,
-        lineNumber: true
+        gender: true
       });
 
       // Check if there are any validation errors
       if (Object.values(newValidationErrors).some(error => error)) {
         const errorMessages = [];
         if (newValidationErrors.gender) errorMessages.push("Gender is required");
-        if (newValidationErrors.lineNumber) errorMessages.push("Line Number is required when adding to queue");
         
         alert(errorMessages.join("\n"));
         return;
@@ -480,13 +474,12 @@ export const PatientModal: React.FC<PatientModalProps> = ({
         // Create the encounter
         const newEncounter = await pb.collection('encounters').create(encounterData);
 
-        // Add to queue
+        // Add to queue, the server assigns the line number
         if (addToQueue) {
           const queueData = {
             patient: initialData.id,
             status: 'checked_in',
             check_in_time: new Date().toISOString(),
-            line_number: lineNumber ? parseInt(String(lineNumber)) : 1,
             priority: 3,
             assigned_to: null,
             start_time: null,
@@ -592,14 +585,13 @@ export const PatientModal: React.FC<PatientModalProps> = ({
             const newEncounter = await pb.collection('encounters').create(encounterData);
             console.log('SUBMIT DEBUG: Encounter created successfully:', newEncounter);
 
-            // Add to queue if requested and line number is provided
-            if (addToQueue && lineNumber !== '') {
-              console.log('SUBMIT DEBUG: Adding to queue with line number:', lineNumber);
+            // Add to queue if requested, the server assigns the line number
+            if (addToQueue) {
+              console.log('SUBMIT DEBUG: Adding to queue');
               const queueData = {
                 patient: patientId,
                 status: 'checked_in',
                 check_in_time: new Date().toISOString(),
-                line_number: parseInt(String(lineNumber)),
                 priority: 3,
                 assigned_to: null,
                 start_time: null,
@@ -633,7 +625,7 @@ export const PatientModal: React.FC<PatientModalProps> = ({
   };
 
   // Add field blur handlers
-  const handleFieldBlur = (field: 'gender' | 'lineNumber') => {
+  const handleFieldBlur = (field: 'gender') => {
     setTouched(prev => ({
       ...prev,
       [field]: true
@@ -642,8 +634,7 @@ export const PatientModal: React.FC<PatientModalProps> = ({
     // Update validation errors
     setValidationErrors(prev => ({
       ...prev,
-      gender: field === 'gender' ? !formData.gender : prev.gender,
-      lineNumber: field === 'lineNumber' && addToQueue ? !lineNumber : prev.lineNumber
+      gender: field === 'gender' ? !formData.gender : prev.gender
     }));
   };
 
@@ -1049,23 +1040,12 @@ export const PatientModal: React.FC<PatientModalProps> = ({
                   }
                   label="Add to queue after creation"
                 />
+                {addToQueue && (
+                  <Typography variant="body2" color="text.secondary">
+                    The next line number for today is assigned automatically.
+                  </Typography>
+                )}
               </Grid>
-              {addToQueue && (
-                <Grid item xs={12} sm={6}>
-                  <TextField
-                    fullWidth
-                    type="number"
-                    label="Line Number"
-                    value={lineNumber}
-                    onChange={(e) => setLineNumber(e.target.value ? parseInt(e.target.value) : '')}
-                    onBlur={() => handleFieldBlur('lineNumber')}
-                    required
-                    error={touched.lineNumber && validationErrors.lineNumber}
-                    helperText={touched.lineNumber && validationErrors.lineNumber ? 'Line Number is required' : ''}
-                    disabled={isFieldDisabled('lineNumber')}
-                  />
-                </Grid>
-              )}
             </>
           )}
         </Grid>
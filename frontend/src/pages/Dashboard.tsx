@@ -32,7 +32,7 @@ import { Record } from 'pocketbase';
 import RefreshIcon from '@mui/icons-material/Refresh';
 import { RoleBasedAccess } from '../components/RoleBasedAccess';
 import AccessTimeIcon from '@mui/icons-material/AccessTime';
-import type { QueueStatus, QueueItem } from '../types/queue';
+import type { QueueStatus, QueueItem, CareTeam } from '../types/queue';
 import { useSettings } from '../hooks/useSettings';
 
 // Base type for PocketBase list responses
@@ -96,9 +96,6 @@ interface Patient extends Record {
 interface DisplayPreferences {
   show_priority_dropdown: boolean;
   show_care_team_assignment: boolean;
-  care_team_count: number;
-  show_gyn_team: boolean;
-  show_optometry_team: boolean;
   show_move_to_checkout: boolean;
 }
 
@@ -136,6 +133,12 @@ const Dashboard: React.FC = () => {
     filter: 'status != "completed"'
   });
 
+  // Only active care teams can be assigned, the server rejects the others
+  const { records: careTeams } = useRealtimeCollection<CareTeam>('care_teams', {
+    sort: 'name',
+    filter: 'active = true'
+  });
+
   // Debug logging for display preferences
   useEffect(() => {
     console.log('Display Preferences:', displayPreferences);
@@ -184,10 +187,7 @@ const Dashboard: React.FC = () => {
   // Debug logging for display preferences changes
   useEffect(() => {
     console.log('Display preferences changed:', {
-      show_care_team_assignment: displayPreferences?.show_care_team_assignment,
-      care_team_count: displayPreferences?.care_team_count,
-      show_gyn_team: displayPreferences?.show_gyn_team,
-      show_optometry_team: displayPreferences?.show_optometry_team
+      show_care_team_assignment: displayPreferences?.show_care_team_assignment
     });
   }, [displayPreferences]);
 
@@ -449,7 +449,7 @@ const Dashboard: React.FC = () => {
   const handleQueueAction = async (
     queueId: string,
     action: 'status_change' | 'start_pharmacy' | 'complete_pharmacy' | 'start_checkout' | 'complete_checkout' | 'continue_encounter' | 'start_encounter',
-    options?: { newStatus?: QueueStatus; teamNumber?: string | null; scrollTo?: string }
+    options?: { newStatus?: QueueStatus; teamId?: string | null; scrollTo?: string }
   ) => {
     console.log('handleQueueAction called with:', { queueId, action, options });
     setProcessing(queueId);
@@ -485,57 +485,10 @@ const Dashboard: React.FC = () => {
       if (action === 'start_encounter') {
         console.log('Starting encounter process for patient');
         
-        // Get patient details
-        const patient = await pb.collection('patients').getOne<Patient>(currentQueueItem.patient, {
-          $autoCancel: false
-        });
-        console.log('Patient details:', patient);
-        
-        // Get today's date in YYYY-MM-DD format for filtering
-        const today = new Date().toISOString().split('T')[0];
-        console.log('Checking for encounters from:', today);
-        
-        // Check for existing encounters from today
-        const existingEncounters = await pb.collection('encounters').getList(1, 1, {
-          filter: `patient = "${currentQueueItem.patient}" && created ~ "${today}"`,
-          sort: '-created',
-          $autoCancel: false
-        });
-        
-        let encounterId: string;
-        
-        if (existingEncounters.items.length > 0) {
-          // Use the most recent encounter from today
-          const latestEncounter = existingEncounters.items[0];
-          console.log('Using existing encounter from today:', latestEncounter);
-          encounterId = latestEncounter.id;
-        } else {
-          // Create new encounter if none exists from today
-          console.log('No encounters found today, creating new encounter...');
-          const encounter = await pb.collection('encounters').create({
-            patient: currentQueueItem.patient,
-            created: new Date().toISOString(),
-            // Include vitals from patient if they exist
-            height: patient.height ?? null,
-            weight: patient.weight ?? null,
-            temperature: patient.temperature ?? null,
-            heart_rate: patient.heart_rate ?? null,
-            systolic_pressure: patient.systolic_pressure ?? null,
-            diastolic_pressure: patient.diastolic_pressure ?? null,
-          }, {
-            $autoCancel: false
-          });
-          console.log('Created new encounter:', encounter);
-          encounterId = encounter.id;
-        }
-        
-        // Update queue item status
-        await pb.collection('queue').update(queueId, {
-          status: 'with_care_team',
-          encounter: encounterId,
-          assigned_to: pb.authStore.model?.id,
-          start_time: new Date().toISOString()
-        }, {
+        // The server reuses today's encounter or creates one and moves the
+        // patient to the care team in a single step
+        const { encounter: encounterId } = await pb.send(`/api/meds/queue/${queueId}/start-encounter`, {
+          method: 'POST',
           $autoCancel: false
         });
         
@@ -603,45 +556,13 @@ const Dashboard: React.FC = () => {
       let encounterId = currentQueueItem.expand?.encounter?.id;
       
       if (newStatus === 'with_care_team') {
-        console.log('Starting new encounter for patient');
-        
-        // Get patient details
-        const patient = await pb.collection('patients').getOne<Patient>(currentQueueItem.patient, {
-          $autoCancel: false
-        });
-        console.log('Patient details:', patient);
+        console.log('Starting encounter for patient');
         
-        // Check for existing encounters
-        const result = await pb.collection('encounters').getList(1, 1, {
-          filter: `patient = "${currentQueueItem.patient}"`,
-          sort: '-created',
+        const result = await pb.send(`/api/meds/queue/${queueId}/start-encounter`, {
+          method: 'POST',
           $autoCancel: false
         });
-        
-        if (result.items.length > 0) {
-          // Use existing encounter
-          const latestEncounter = result.items[0];
-          console.log('Using existing encounter:', latestEncounter);
-          encounterId = latestEncounter.id;
-        } else {
-          // Create new encounter
-          console.log('Creating new encounter...');
-          const encounter = await pb.collection('encounters').create({
-            patient: currentQueueItem.patient,
-            created: new Date().toISOString(),
-            // Include vitals from patient if they exist
-            height: patient.height ?? null,
-            weight: patient.weight ?? null,
-            temperature: patient.temperature ?? null,
-            heart_rate: patient.heart_rate ?? null,
-            systolic_pressure: patient.systolic_pressure ?? null,
-            diastolic_pressure: patient.diastolic_pressure ?? null,
-          }, {
-            $autoCancel: false
-          });
-          console.log('Created new encounter:', encounter);
-          encounterId = encounter.id;
-        }
+        encounterId = result.encounter;
         
         // Always set navigation for with_care_team
         shouldNavigate = true;
@@ -655,19 +576,14 @@ const Dashboard: React.FC = () => {
         encounter: encounterId,
       };
       
-      // Add assigned_to and start_time for certain statuses
-      if (newStatus === 'with_care_team' || newStatus === 'with_pharmacy' || newStatus === 'at_checkout') {
+      // Add assigned_to for certain statuses, start-encounter already did it for with_care_team
+      if (newStatus === 'with_pharmacy' || newStatus === 'at_checkout') {
         updateData.assigned_to = pb.authStore.model?.id;
-        
-        // Only set start_time for with_care_team
-        if (newStatus === 'with_care_team') {
-          updateData.start_time = new Date().toISOString();
-        }
       }
       
       // Handle team assignment if specified
-      if (options?.teamNumber !== undefined) {
-        updateData.intended_provider = options.teamNumber;
+      if (options?.teamId !== undefined) {
+        updateData.intended_provider = options.teamId;
         updateData.updated = new Date().toISOString(); // Force an update event
       }
       
@@ -692,7 +608,7 @@ const Dashboard: React.FC = () => {
       setError(null);
       
       // If this was a team assignment, refresh the queue items to ensure consistency
-      if (options?.teamNumber !== undefined) {
+      if (options?.teamId !== undefined) {
         console.log('Refreshing queue items after team assignment');
         await pb.collection('queue').getList<QueueItem>(1, 100, {
           sort: '-priority,check_in_time',
@@ -705,9 +621,11 @@ const Dashboard: React.FC = () => {
       // Handle errors
       if (!error.message?.includes('autocancelled')) {
         console.error('Error in handleQueueAction:', error);
-        const errorMessage = error instanceof Error ? error.message : 'Unknown error occurred';
+        // The server rejects illegal status moves with a descriptive message
+        const statusMessage = error?.data?.data?.status?.message;
+        const errorMessage = statusMessage || (error instanceof Error ? error.message : 'Unknown error occurred');
         setError(`Failed to perform action: ${errorMessage}`);
-        alert('Failed to update queue. Please try again.');
+        alert(statusMessage || 'Failed to update queue. Please try again.');
       }
     } finally {
       setProcessing(null);
@@ -732,14 +650,14 @@ const Dashboard: React.FC = () => {
     return 'error.main';
   };
 
-  const handleTeamAssignment = async (queueId: string, teamNumber: string | null) => {
-    console.log('Handling team assignment:', { queueId, teamNumber });
+  const handleTeamAssignment = async (queueId: string, teamId: string | null) => {
+    console.log('Handling team assignment:', { queueId, teamId });
     setProcessing(queueId);
     
     try {
       // Update the queue item with the new team assignment
       await pb.collection('queue').update(queueId, {
-        intended_provider: teamNumber,
+        intended_provider: teamId,
         updated: new Date().toISOString() // Force an update event
       }, {
         $autoCancel: false
@@ -749,9 +667,11 @@ const Dashboard: React.FC = () => {
     } catch (error: any) {
       if (!error.message?.includes('autocancelled')) {
         console.error('Error updating team assignment:', error);
-        const errorMessage = error instanceof Error ? error.message : 'Unknown error occurred';
+        // inactive or full teams are reported on the intended_provider field
+        const errorMessage = error?.data?.data?.intended_provider?.message ||
+          (error instanceof Error ? error.message : 'Unknown error occurred');
         setError(`Failed to update team assignment: ${errorMessage}`);
-        alert('Failed to update team assignment. Please try again.');
+        alert(`Failed to update team assignment. ${errorMessage}`);
       }
     } finally {
       setProcessing(null);
@@ -1080,16 +1000,16 @@ const Dashboard: React.FC = () => {
                 <MenuItem value="">
                   <em>Unassigned</em>
                 </MenuItem>
-                {Array.from({ length: displayPreferences.care_team_count }, (_, i) => i + 1).map(num => (
-                  <MenuItem key={num} value={`team${num}`}>
-                    Care Team {num}
+                {careTeams.map(team => (
+                  <MenuItem key={team.id} value={team.id}>
+                    {team.name}
                   </MenuItem>
                 ))}
-                {displayPreferences.show_gyn_team && (
-                  <MenuItem value="gyn_team">Gyn Team</MenuItem>
-                )}
-                {displayPreferences.show_optometry_team && (
-                  <MenuItem value="optometry_team">Optometry Team</MenuItem>
+                {/* keep showing a team that was deactivated after the assignment */}
+                {queueItem.expand?.intended_provider && !careTeams.some(team => team.id === queueItem.intended_provider) && (
+                  <MenuItem value={queueItem.expand.intended_provider.id} disabled>
+                    {queueItem.expand.intended_provider.name}
+                  </MenuItem>
                 )}
               </Select>
             )}
@@ -1224,17 +1144,11 @@ const Dashboard: React.FC = () => {
                   <MenuItem value="">
                     <em>All Teams</em>
                   </MenuItem>
-                  {Array.from({ length: displayPreferences.care_team_count }, (_, i) => i + 1).map(num => (
-                    <MenuItem key={num} value={`team${num}`}>
-                      Care Team {num}
+                  {careTeams.map(team => (
+                    <MenuItem key={team.id} value={team.id}>
+                      {team.name}
                     </MenuItem>
                   ))}
-                  {displayPreferences.show_gyn_team && (
-                    <MenuItem value="gyn_team">Gyn Team</MenuItem>
-                  )}
-                  {displayPreferences.show_optometry_team && (
-                    <MenuItem value="optometry_team">Optometry Team</MenuItem>
-                  )}
                 </Select>
               )}
             </Box>
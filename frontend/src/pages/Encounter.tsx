@@ -37,6 +37,7 @@ import KeyboardArrowDownIcon from '@mui/icons-material/KeyboardArrowDown';
 import KeyboardArrowUpIcon from '@mui/icons-material/KeyboardArrowUp';
 import { UnsubscribeFunc } from 'pocketbase';
 import { useRealtimeCollection } from '../hooks/useRealtimeCollection';
+import { getStockErrorMessage } from '../utils/disbursementUtils';
 
 type QueueStatus = 'checked_in' | 'with_care_team' | 'ready_pharmacy' | 'with_pharmacy' | 'at_checkout' | 'completed';
 
@@ -97,6 +98,7 @@ interface Disbursement extends BaseModel {
   encounter: string;
   medication: string;
   quantity: number;
+  multiplier?: number;
   notes: string;
   expand?: {
     medication: MedicationRecord;
@@ -173,6 +175,7 @@ interface ExistingDisbursement extends BaseModel {
   encounter: string;
   medication: string;
   quantity: number;
+  multiplier?: number;
   notes: string;
   processed?: boolean;
   expand?: {
@@ -498,12 +501,12 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
             // Convert disbursements to DisbursementItems
             const disbursementItems = (disbursements.items as Disbursement[]).map(d => {
               const medication = d.expand?.medication as MedicationRecord;
-              const multiplier = medication ? d.quantity / medication.fixed_quantity : '';
+              const multiplier = d.multiplier || 1;
               
               return {
                 id: d.id,
                 medication: d.medication,
-                quantity: medication?.fixed_quantity || d.quantity,
+                quantity: d.quantity,
                 multiplier: multiplier.toString(),
                 notes: d.notes || '',
                 medicationDetails: medication,
@@ -1186,7 +1189,7 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
       }
     } catch (error) {
       console.error('Error updating queue status:', error);
-      alert('Failed to update queue status');
+      alert('Failed to update queue status' + ((error as any)?.data?.data?.status?.message ? ': ' + (error as any).data.data.status.message : ''));
     }
   };
 
@@ -1221,31 +1224,20 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
         pb.collection('disbursements').getList<ExistingDisbursement>(1, 50, {
           filter: `encounter = "${encounterId}"`,
           expand: 'medication',
-          fields: 'id,medication,quantity,notes,processed,frequency,frequency_hours,associated_diagnosis'
+          fields: 'id,medication,quantity,multiplier,notes,processed,frequency,frequency_hours,associated_diagnosis'
         }),
         Promise.all(validDisbursements.map(d => 
           pb.collection('inventory').getOne<MedicationRecord>(d.medication)
         ))
       ]);
 
-      // Process deletions first
+      // Process deletions first; the server gives the stock back
       for (const disbursement of markedForDeletion) {
         if (!disbursement.id) continue;
 
         const existing = existingDisbursementsResult.items.find(d => d.id === disbursement.id);
         if (existing && !existing.processed) {
           try {
-            // Get fresh medication record
-            const medication = await pb.collection('inventory').getOne<MedicationRecord>(existing.medication);
-            const newStock = medication.stock + existing.quantity;
-            
-            // Update stock first
-            await pb.collection('inventory').update(existing.medication, {
-              stock: newStock,
-              multiplier: 1
-            });
-            
-            // Then delete the disbursement
             await pb.collection('disbursements').delete(disbursement.id);
           } catch (error) {
             console.error('DEBUG: Error in deletion process:', error);
@@ -1254,48 +1246,34 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
         }
       }
 
-      // Process valid disbursements
+      // Process valid disbursements. Stock is checked and adjusted by the
+      // server from quantity * multiplier, so only the disbursement is saved.
       const processedDisbursements: (ExistingDisbursement | Record<string, any>)[] = [];
       for (const disbursement of validDisbursements) {
         const medication = medicationsResult.find(m => m.id === disbursement.medication);
         if (!medication) continue;
 
-        // Convert multiplier to number for calculations, empty multiplier becomes 0
+        // Convert multiplier to number, empty multiplier becomes 0
         const multiplierNum = typeof disbursement.multiplier === 'string' 
           ? (disbursement.multiplier === '' ? 0 : parseFloat(disbursement.multiplier) || 0)
           : disbursement.multiplier || 0;
-        const quantity = disbursement.quantity * multiplierNum;
         
         if (disbursement.id) {
           const existing = existingDisbursementsResult.items.find(d => d.id === disbursement.id);
           if (existing) {
             // Update if any fields changed
             const fieldsChanged = 
-              existing.quantity !== quantity ||
+              existing.quantity !== disbursement.quantity ||
+              existing.multiplier !== multiplierNum ||
               existing.notes !== disbursement.notes ||
               existing.frequency !== disbursement.frequency ||
               existing.frequency_hours !== disbursement.frequency_hours ||
               existing.associated_diagnosis !== disbursement.associated_diagnosis;
 
             if (fieldsChanged) {
-              // Update stock if quantity changed
-              if (existing.quantity !== quantity) {
-                const stockChange = quantity - existing.quantity;
-                const newStock = medication.stock - stockChange;
-                
-                if (newStock < 0) {
-                  throw new Error(`Not enough stock for ${medication.drug_name}. Available: ${medication.stock}, Needed: ${quantity}`);
-                }
-
-                await pb.collection('inventory').update(medication.id, {
-                  stock: newStock
-                });
-              }
-
-              // Update disbursement
               const updated = await pb.collection('disbursements').update(disbursement.id, {
-                quantity,
-                multiplier: disbursement.multiplier,  // Save the original multiplier string
+                quantity: disbursement.quantity,
+                multiplier: multiplierNum,
                 notes: disbursement.notes || '',
                 frequency: disbursement.frequency || 'QD',
                 frequency_hours: disbursement.frequency === 'Q#H' ? disbursement.frequency_hours : null,
@@ -1307,25 +1285,11 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
             }
           }
         } else {
-          // Create new disbursement
-          const newStock = medication.stock - quantity;
-          
-          if (newStock < 0) {
-            throw new Error(`Not enough stock for ${medication.drug_name}. Available: ${medication.stock}, Needed: ${quantity}`);
-          }
-
-          // Update stock first
-          await pb.collection('inventory').update(medication.id, {
-            stock: newStock,
-            multiplier: 1
-          });
-
-          // Create disbursement
           const created = await pb.collection('disbursements').create({
             encounter: encounterId,
             medication: disbursement.medication,
-            quantity,
-            multiplier: disbursement.multiplier,  // Save the original multiplier string
+            quantity: disbursement.quantity,
+            multiplier: multiplierNum,
             notes: disbursement.notes || '',
             processed: false,
             frequency: disbursement.frequency || 'QD',
@@ -1340,11 +1304,11 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
       const updatedDisbursements = await Promise.all(
         processedDisbursements.map(async d => {
           const medication = await pb.collection('inventory').getOne<MedicationRecord>(d.medication);
-          const currentMultiplier = medication.fixed_quantity ? d.quantity / medication.fixed_quantity : 1;
+          const currentMultiplier = d.multiplier || 1;
           return {
             id: d.id,
             medication: d.medication,
-            quantity: medication.fixed_quantity || d.quantity,
+            quantity: d.quantity,
             multiplier: currentMultiplier,
             notes: d.notes || '',
             medicationDetails: medication,
@@ -1353,7 +1317,7 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
             frequency_hours: d.frequency === 'Q#H' ? d.frequency_hours : null,
             associated_diagnosis: d.associated_diagnosis || null,
             // Set original values to current values after save
-            originalQuantity: medication.fixed_quantity || d.quantity,
+            originalQuantity: d.quantity,
             originalMultiplier: currentMultiplier
           };
         })
@@ -1370,7 +1334,7 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
       });
     } catch (error) {
       console.error('Error saving disbursement changes:', error);
-      alert('Failed to save changes: ' + (error as Error).message);
+      alert('Failed to save changes: ' + getStockErrorMessage(error));
       throw error;
     }
   };
@@ -1415,12 +1379,12 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
     // Convert disbursements to DisbursementItems
     const disbursementItems = disbursementRecords.map(d => {
       const medication = d.expand?.medication as MedicationRecord;
-      const multiplier = medication ? d.quantity / medication.fixed_quantity : 1;
+      const multiplier = d.multiplier || 1;
       
       return {
         id: d.id,
         medication: d.medication,
-        quantity: medication?.fixed_quantity || d.quantity,
+        quantity: d.quantity,
         multiplier: multiplier.toString(),
         notes: d.notes || '',
         medicationDetails: medication,
@@ -1651,14 +1615,12 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
   // Add ref for DisbursementForm
   const disbursementFormRef = useRef<any>(null);
 
-  // Add cleanup function for active editor
+  // Release the server-side edit lock of this encounter
   const cleanupActiveEditor = useCallback(async () => {
     if (encounterId && (currentMode === 'edit' || currentMode === 'pharmacy')) {
       try {
-        await pb.collection('encounters').update(encounterId, {
-          active_editor: null,
-          last_edit_activity: null
-        }, {
+        await pb.send(`/api/meds/encounters/${encounterId}/lock`, {
+          method: 'DELETE',
           $autoCancel: false // Explicitly disable auto-cancellation for this request
         });
       } catch (error) {
@@ -1674,8 +1636,29 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
     };
   }, [cleanupActiveEditor]);
 
-  // Add active editor check and update
+  // Acquire the edit lock and keep it alive. The server rejects changes from
+  // anyone else while we hold it and releases it once we stop sending heartbeats.
   useEffect(() => {
+    const lockPath = `/api/meds/encounters/${encounterId}/lock`;
+
+    const acquireLock = async () => {
+      try {
+        await pb.send(lockPath, {
+          method: 'POST',
+          $autoCancel: false // Explicitly disable auto-cancellation for this request
+        });
+        setActiveEditorWarning(null);
+      } catch (error: any) {
+        if (error?.status === 409) {
+          setActiveEditorWarning(
+            `${error.message} Your changes will be rejected until they are done.`
+          );
+        } else {
+          console.error('Error acquiring the encounter lock:', error);
+        }
+      }
+    };
+
     const checkAndUpdateActiveEditor = async (encounterId: string, currentMode: EncounterMode) => {
       if (!encounterId || (currentMode !== 'edit' && currentMode !== 'pharmacy')) {
         return;
@@ -1696,15 +1679,18 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
                   const editor = await pb.collection('users').getOne<UserRecord>(updatedEncounter.active_editor);
                   setActiveEditorWarning(
                     `This encounter is currently being edited by ${editor.name || 'another user'}. ` +
-                    'Your changes may conflict with theirs.'
+                    'Your changes will be rejected until they are done.'
                   );
                 } catch (error) {
                   console.error('Error fetching editor details:', error);
                   setActiveEditorWarning(
                     'This encounter is currently being edited by another user. ' +
-                    'Your changes may conflict with theirs.'
+                    'Your changes will be rejected until they are done.'
                   );
                 }
+              } else if (!updatedEncounter.active_editor) {
+                // The lock was released, try to take it over
+                acquireLock();
               } else {
                 setActiveEditorWarning(null);
               }
@@ -1712,59 +1698,7 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
           }
         );
 
-        // Initial check of active editor
-        const encounter = await pb.collection('encounters').getOne<EncounterRecord>(encounterId, {
-          expand: 'active_editor',
-          $autoCancel: false // Explicitly disable auto-cancellation for this request
-        });
-
-        // Check if there's an active editor and their last activity
-        if (encounter.active_editor) {
-          const lastActivity = new Date(encounter.last_edit_activity || 0);
-          const now = new Date();
-          const timeDiff = now.getTime() - lastActivity.getTime();
-          const timeoutPeriod = 10 * 60 * 1000; // 10 minutes in milliseconds
-
-          // If the active editor is us, just update the timestamp
-          if (encounter.active_editor === pb.authStore.model?.id) {
-            await pb.collection('encounters').update<EncounterRecord>(encounterId, {
-              last_edit_activity: new Date().toISOString()
-            }, {
-              $autoCancel: false // Explicitly disable auto-cancellation for this request
-            });
-            setActiveEditorWarning(null);
-            return unsubscribe;
-          }
-
-          // If the last activity is older than the timeout period, take over as editor
-          if (timeDiff > timeoutPeriod) {
-            await pb.collection('encounters').update<EncounterRecord>(encounterId, {
-              active_editor: pb.authStore.model?.id,
-              last_edit_activity: new Date().toISOString()
-            }, {
-              $autoCancel: false // Explicitly disable auto-cancellation for this request
-            });
-            setActiveEditorWarning(null);
-            return unsubscribe;
-          }
-
-          // Someone else is actively editing, show warning
-          const editor = encounter.expand?.active_editor;
-          setActiveEditorWarning(
-            `This encounter is currently being edited by ${editor?.name || 'another user'}. ` +
-            'Your changes may conflict with theirs.'
-          );
-          return unsubscribe;
-        }
-
-        // No active editor, set ourselves as the active editor
-        await pb.collection('encounters').update<EncounterRecord>(encounterId, {
-          active_editor: pb.authStore.model?.id,
-          last_edit_activity: new Date().toISOString()
-        }, {
-          $autoCancel: false // Explicitly disable auto-cancellation for this request
-        });
-        setActiveEditorWarning(null);
+        await acquireLock();
 
         return unsubscribe;
       } catch (error) {
@@ -1784,20 +1718,18 @@ export const Encounter: React.FC<EncounterProps> = ({ mode: initialMode = 'creat
         });
     }
 
-    // Set up interval to update last_edit_activity
+    // Set up interval to send lock heartbeats
     const interval = setInterval(async () => {
       if (encounterId && (currentMode === 'edit' || currentMode === 'pharmacy')) {
         try {
-          const encounter = await pb.collection('encounters').getOne<EncounterRecord>(encounterId);
-          
-          // Only update timestamp if we are the active editor
-          if (encounter.active_editor === pb.authStore.model?.id) {
-            await pb.collection('encounters').update(encounterId, {
-              last_edit_activity: new Date().toISOString()
-            });
+          await pb.send(`${lockPath}/heartbeat`, { method: 'POST' });
+        } catch (error: any) {
+          if (error?.status === 409) {
+            // We lost the lock, take it back if nobody else holds it
+            acquireLock();
+          } else {
+            console.error('Error updating last edit activity:', error);
           }
-        } catch (error) {
-          console.error('Error updating last edit activity:', error);
         }
       }
     }, 30000); // Update every 30 seconds
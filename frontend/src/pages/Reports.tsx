@@ -75,6 +75,7 @@ interface DisbursementRecord extends BaseRecord {
   encounter: string;
   medication: string;
   quantity: number;
+  multiplier?: number;
   notes: string;
   associated_diagnosis?: string;
   expand?: {
@@ -366,9 +367,11 @@ const Reports: React.FC = () => {
             };
           }
           
-          acc[medicationId].totalQuantity += (disbursement.quantity || 0);
+          // Stock units disbursed are quantity * multiplier (an unset multiplier counts as 1)
+          const disbursedQuantity = (disbursement.quantity || 0) * (disbursement.multiplier || 1);
+          acc[medicationId].totalQuantity += disbursedQuantity;
           acc[medicationId].uniqueEncounters.add(disbursement.encounter);
-          acc[medicationId].quantities.push(disbursement.quantity || 0);
+          acc[medicationId].quantities.push(disbursedQuantity);
 
           // Track diagnosis associations
           if (disbursement.associated_diagnosis) {
@@ -25,6 +25,7 @@ import { pb } from '../atoms/auth';
 import { RoleBasedAccess } from '../components/RoleBasedAccess';
 import { Record, Admin } from 'pocketbase';
 import DeleteForeverIcon from '@mui/icons-material/DeleteForever';
+import { CareTeamSettings } from '../components/CareTeamSettings';
 
 interface UnitDisplay {
   height: string;
@@ -35,9 +36,6 @@ interface UnitDisplay {
 interface DisplayPreferences {
   show_priority_dropdown: boolean;
   show_care_team_assignment: boolean;
-  care_team_count: number;
-  show_gyn_team: boolean;
-  show_optometry_team: boolean;
   show_move_to_checkout: boolean;
   unified_roles: boolean;
   override_field_restrictions: boolean;
@@ -47,6 +45,8 @@ interface DisplayPreferences {
 interface Settings extends Record {
   unit_display: UnitDisplay;
   display_preferences: DisplayPreferences;
+  clinic_timezone: string;
+  encounter_lock_timeout: number | null;
   updated_by: string;
 }
 
@@ -59,11 +59,8 @@ const Settings: React.FC = () => {
   const [wipeDialogOpen, setWipeDialogOpen] = useState(false);
   const [success, setSuccess] = useState<string | null>(null);
   const [displayPreferences, setDisplayPreferences] = useState<DisplayPreferences>({
-    show_priority_dropdown: true,
-    show_care_team_assignment: true,
-    care_team_count: 3,
-    show_gyn_team: false,
-    show_optometry_team: false,
+    show_priority_dropdown: false,
+    show_care_team_assignment: false,
     show_move_to_checkout: true,
     unified_roles: false,
     override_field_restrictions: false,
@@ -110,6 +107,8 @@ const Settings: React.FC = () => {
       const updatedSettings = await pb.collection('settings').update<Settings>(settings.id, {
         unit_display: settings.unit_display,
         display_preferences: updatedDisplayPreferences,
+        clinic_timezone: settings.clinic_timezone,
+        encounter_lock_timeout: settings.encounter_lock_timeout,
         updated_by: (pb.authStore.model as Admin)?.id
       });
       setSettings(updatedSettings);
@@ -118,9 +117,16 @@ const Settings: React.FC = () => {
       setSaveSuccess(true);
       // Auto-hide success message after 3 seconds
       setTimeout(() => setSaveSuccess(false), 3000);
-    } catch (err) {
+    } catch (err: any) {
       console.error('Error saving settings:', err);
-      setError('Failed to save settings. Please try again.');
+      const fieldErrors = err?.data?.data;
+      setError(
+        fieldErrors?.clinic_timezone?.message ||
+        fieldErrors?.display_preferences?.message ||
+        fieldErrors?.unit_display?.message ||
+        err?.data?.message ||
+        'Failed to save settings. Please try again.'
+      );
     } finally {
       setSaving(false);
     }
@@ -266,6 +272,51 @@ const Settings: React.FC = () => {
 
           <Divider sx={{ my: 4 }} />
 
+          <Typography variant="h6" gutterBottom>Clinic</Typography>
+
+          <Box sx={{ mb: 3 }}>
+            <TextField
+              label="Clinic Timezone"
+              placeholder="America/Guatemala"
+              value={settings?.clinic_timezone || ''}
+              onChange={(e) => {
+                if (!settings) return;
+                setSettings({
+                  ...settings,
+                  clinic_timezone: e.target.value.trim()
+                });
+              }}
+              sx={{ width: 300 }}
+            />
+            <Typography variant="body2" color="text.secondary" sx={{ mt: 1 }}>
+              Defines when a clinic day starts, e.g. for the daily queue line numbers. Leave empty to use the server timezone.
+            </Typography>
+          </Box>
+
+          <Box sx={{ mb: 3 }}>
+            <TextField
+              label="Encounter Lock Timeout (minutes)"
+              type="number"
+              placeholder="10"
+              value={settings?.encounter_lock_timeout ?? ''}
+              onChange={(e) => {
+                if (!settings) return;
+                const value = parseInt(e.target.value);
+                setSettings({
+                  ...settings,
+                  encounter_lock_timeout: isNaN(value) || value < 1 ? null : value
+                });
+              }}
+              inputProps={{ min: 1 }}
+              sx={{ width: 300 }}
+            />
+            <Typography variant="body2" color="text.secondary" sx={{ mt: 1 }}>
+              An encounter stays locked for the user editing it until they have been idle this long. Leave empty for 10 minutes.
+            </Typography>
+          </Box>
+
+          <Divider sx={{ my: 4 }} />
+
           <Typography variant="h6" gutterBottom>Dashboard Display Preferences</Typography>
 
           <Box sx={{ mb: 3 }}>
@@ -345,73 +396,8 @@ const Settings: React.FC = () => {
           </Box>
 
           <Box sx={{ mb: 3 }}>
-            <TextField
-              label="Number of Care Teams"
-              type="number"
-              value={settings?.display_preferences.care_team_count ?? ''}
-              onChange={(e) => {
-                if (!settings) return;
-                const value = e.target.value === '' ? '' : parseInt(e.target.value);
-                setSettings({
-                  ...settings,
-                  display_preferences: {
-                    ...settings.display_preferences,
-                    care_team_count: value === '' ? 0 : value
-                  }
-                });
-              }}
-              onBlur={(e) => {
-                if (!settings) return;
-                const value = parseInt(e.target.value);
-                if (isNaN(value) || value < 1) {
-                  setSettings({
-                    ...settings,
-                    display_preferences: {
-                      ...settings.display_preferences,
-                      care_team_count: 1
-                    }
-                  });
-                }
-              }}
-              inputProps={{ min: 1 }}
-              disabled={!settings?.display_preferences.show_care_team_assignment}
-              sx={{ width: 200 }}
-            />
-            <Typography variant="body2" color="text.secondary" sx={{ mt: 1 }}>
-              Set the number of care teams to display in the assignment dropdown.
-            </Typography>
-          </Box>
-
-          <Box sx={{ mb: 3 }}>
-            <FormControlLabel
-              control={
-                <Switch
-                  checked={settings?.display_preferences.show_gyn_team || false}
-                  onChange={handleDisplayPreferenceChange('show_gyn_team')}
-                  disabled={!settings?.display_preferences.show_care_team_assignment}
-                />
-              }
-              label="Show Gyn Team Option"
-            />
-            <Typography variant="body2" color="text.secondary" sx={{ mt: 1 }}>
-              Enable to show Gyn Team in the care team assignment dropdown. Only available when care team assignment is enabled.
-            </Typography>
-          </Box>
-
-          <Box sx={{ mb: 3 }}>
-            <FormControlLabel
-              control={
-                <Switch
-                  checked={settings?.display_preferences.show_optometry_team || false}
-                  onChange={handleDisplayPreferenceChange('show_optometry_team')}
-                  disabled={!settings?.display_preferences.show_care_team_assignment}
-                />
-              }
-              label="Show Optometry Team Option"
-            />
-            <Typography variant="body2" color="text.secondary" sx={{ mt: 1 }}>
-              Enable to show Optometry Team in the care team assignment dropdown. Only available when care team assignment is enabled.
-            </Typography>
+            <Typography variant="subtitle1" gutterBottom>Care Teams</Typography>
+            <CareTeamSettings />
           </Box>
 
           <Box sx={{ mb: 3 }}>
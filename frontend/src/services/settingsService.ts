@@ -11,9 +11,6 @@ export interface UnitDisplay {
 export interface DisplayPreferences {
   show_priority_dropdown: boolean;
   show_care_team_assignment: boolean;
-  care_team_count: number;
-  show_gyn_team: boolean;
-  show_optometry_team: boolean;
   show_move_to_checkout: boolean;
   unified_roles: boolean;
   override_field_restrictions: boolean;
@@ -26,7 +23,7 @@ export interface Settings extends Record {
   updated_by: string;
 }
 
-// Default values
+// Default values, the same as the server defaults in settings/settings.go
 const defaultUnitDisplay: UnitDisplay = {
   height: 'cm',
   weight: 'kg',
@@ -34,11 +31,8 @@ const defaultUnitDisplay: UnitDisplay = {
 };
 
 const defaultDisplayPreferences: DisplayPreferences = {
-  show_priority_dropdown: true,
-  show_care_team_assignment: true,
-  care_team_count: 3,
-  show_gyn_team: false,
-  show_optometry_team: false,
+  show_priority_dropdown: false,
+  show_care_team_assignment: false,
   show_move_to_checkout: true,
   unified_roles: false,
   override_field_restrictions: false,
@@ -14,6 +14,14 @@ export interface Encounter extends Record {
   }>;
 }
 
+export interface CareTeam extends Record {
+  name: string;
+  specialty: string;
+  active: boolean;
+  members: string[];
+  capacity: number;
+}
+
 export type QueueStatus = 'checked_in' | 'with_care_team' | 'ready_pharmacy' | 'with_pharmacy' | 'at_checkout' | 'completed';
 
 export interface QueueItem extends Record {
@@ -43,6 +51,7 @@ export interface QueueItem extends Record {
       username: string;
       name: string;
     };
+    intended_provider?: CareTeam;
     encounter?: Encounter;
   };
 }
\ No newline at end of file
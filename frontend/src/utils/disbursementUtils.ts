@@ -187,4 +187,15 @@ export const fetchMedicationsForDisbursements = async (
   });
   
   return medicationMap;
-}; 
\ No newline at end of file
+}; 
+/**
+ * Extracts the most useful message from a failed disbursement save.
+ * The server rejects disbursements that would drive stock negative with
+ * a field-level validation error on `quantity`.
+ * @param error The error thrown by the PocketBase client
+ * @returns A human readable error message
+ */
+export const getStockErrorMessage = (error: unknown): string => {
+  const data = (error as any)?.data?.data;
+  return data?.quantity?.message || data?.medication?.message || (error as Error)?.message || 'Unknown error';
+};
@@ -0,0 +1,76 @@
+package hooks
+
+import (
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// actorKey is an in-memory only record key used to pass the authenticated
+// user or admin of an API request down to the model hooks. It is not part
+// of any collection schema, so it is never persisted nor exported.
+const actorKey = "@meds_actor"
+
+// requestActor identifies who triggered a record change.
+// All ids are empty for changes made outside of an API request.
+type requestActor struct {
+	UserId  string
+	AdminId string
+
+	// SiteId is the site a synced change was received from.
+	SiteId string
+}
+
+// registerActorHooks tags every record that is created, updated or deleted
+// through the records API with the request actor.
+func registerActorHooks(app core.App) {
+	app.OnRecordBeforeCreateRequest().Add(func(e *core.RecordCreateEvent) error {
+		setActor(e.HttpContext, e.Record)
+		return nil
+	})
+
+	app.OnRecordBeforeUpdateRequest().Add(func(e *core.RecordUpdateEvent) error {
+		setActor(e.HttpContext, e.Record)
+		return nil
+	})
+
+	app.OnRecordBeforeDeleteRequest().Add(func(e *core.RecordDeleteEvent) error {
+		setActor(e.HttpContext, e.Record)
+		return nil
+	})
+}
+
+// setActor stores the authenticated user or admin of the request in record.
+func setActor(c echo.Context, record *models.Record) {
+	if record == nil {
+		return
+	}
+
+	record.Set(actorKey, contextActor(c))
+}
+
+// contextActor returns the authenticated user or admin of the request.
+func contextActor(c echo.Context) requestActor {
+	actor := requestActor{}
+
+	if authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record); authRecord != nil {
+		actor.UserId = authRecord.Id
+	}
+	if admin, _ := c.Get(apis.ContextAdminKey).(*models.Admin); admin != nil {
+		actor.AdminId = admin.Id
+	}
+
+	return actor
+}
+
+// actorOf returns the actor previously stored in record (if any).
+func actorOf(record *models.Record) requestActor {
+	if record == nil {
+		return requestActor{}
+	}
+
+	actor, _ := record.Get(actorKey).(requestActor)
+
+	return actor
+}
@@ -0,0 +1,237 @@
+package hooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"medical-records/accounts"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/list"
+)
+
+// auditedCollections are the collections whose changes are written to the
+// audit_log collection.
+var auditedCollections = []string{"patients", "encounters", "disbursements", "queue", "settings"}
+
+// unauditedFields are bookkeeping fields of the audited collections that
+// change too often and carry no clinical information.
+var unauditedFields = map[string][]string{
+	"encounters": lockFields,
+}
+
+// Audit log actions stored in audit_log.action.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// fieldChange is a single field difference of an audit entry.
+type fieldChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// registerAuditHooks writes an audit_log entry with a field level diff for
+// every create, update and delete of the audited collections and exposes
+// the read-only audit query route.
+func registerAuditHooks(app core.App) {
+	audit := func(action string) func(e *core.ModelEvent) error {
+		return func(e *core.ModelEvent) error {
+			record, ok := e.Model.(*models.Record)
+			if !ok {
+				return nil
+			}
+
+			return writeAuditEntry(e.Dao, action, record, actorOf(record))
+		}
+	}
+
+	app.OnModelAfterCreate(auditedCollections...).Add(audit(AuditCreate))
+	app.OnModelAfterUpdate(auditedCollections...).Add(audit(AuditUpdate))
+	app.OnModelAfterDelete(auditedCollections...).Add(audit(AuditDelete))
+
+	app.OnRecordBeforeCreateRequest("audit_log").Add(func(e *core.RecordCreateEvent) error {
+		return apis.NewForbiddenError("The audit log is maintained by the server.", nil)
+	})
+
+	app.OnRecordBeforeUpdateRequest("audit_log").Add(func(e *core.RecordUpdateEvent) error {
+		return apis.NewForbiddenError("The audit log is read-only.", nil)
+	})
+
+	app.OnRecordBeforeDeleteRequest("audit_log").Add(func(e *core.RecordDeleteEvent) error {
+		return apis.NewForbiddenError("The audit log is read-only.", nil)
+	})
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		e.Router.GET("/api/meds/audit", func(c echo.Context) error {
+			return auditHandler(app, c)
+		}, apis.ActivityLogger(app), accounts.RequireRole(accounts.RoleAdmin))
+
+		return nil
+	})
+}
+
+// AuditSyncedChange writes the audit_log entry of a change received from
+// another site, which is written without the record hooks. site is the id
+// of the site the change was received from.
+func AuditSyncedChange(dao *daos.Dao, action string, record *models.Record, site string) error {
+	if !list.ExistInSlice(record.Collection().Name, auditedCollections) {
+		return nil
+	}
+
+	return writeAuditEntry(dao, action, record, requestActor{SiteId: site})
+}
+
+// writeAuditEntry appends an audit_log entry for a change of record made
+// by actor.
+func writeAuditEntry(dao *daos.Dao, action string, record *models.Record, actor requestActor) error {
+	var before, after *models.Record
+	switch action {
+	case AuditCreate:
+		after = record
+	case AuditUpdate:
+		before = record.OriginalCopy()
+		after = record
+	case AuditDelete:
+		before = record
+	}
+
+	changes := diffRecords(record.Collection(), before, after)
+	if action == AuditUpdate && len(changes) == 0 {
+		return nil
+	}
+
+	collection, err := dao.FindCollectionByNameOrId("audit_log")
+	if err != nil {
+		return err
+	}
+
+	entry := models.NewRecord(collection)
+	entry.Set("action", action)
+	entry.Set("collection", record.Collection().Name)
+	entry.Set("record", record.Id)
+	entry.Set("patient", auditPatient(dao, record))
+	entry.Set("user", actor.UserId)
+	entry.Set("admin", actor.AdminId)
+	entry.Set("site", actor.SiteId)
+	entry.Set("changes", changes)
+
+	return dao.SaveRecord(entry)
+}
+
+// diffRecords returns the schema fields whose values differ between before
+// and after. Either record may be nil.
+func diffRecords(collection *models.Collection, before, after *models.Record) map[string]fieldChange {
+	changes := map[string]fieldChange{}
+
+	for _, field := range collection.Schema.Fields() {
+		if list.ExistInSlice(field.Name, unauditedFields[collection.Name]) {
+			continue
+		}
+
+		var oldValue, newValue any
+		if before != nil {
+			oldValue = before.Get(field.Name)
+		}
+		if after != nil {
+			newValue = after.Get(field.Name)
+		}
+
+		if SameValue(oldValue, newValue) {
+			continue
+		}
+
+		changes[field.Name] = fieldChange{Before: oldValue, After: newValue}
+	}
+
+	return changes
+}
+
+// SameValue compares two field values by their JSON representation.
+func SameValue(a, b any) bool {
+	rawA, errA := json.Marshal(a)
+	rawB, errB := json.Marshal(b)
+
+	return errA == nil && errB == nil && string(rawA) == string(rawB)
+}
+
+// auditPatient returns the id of the patient a record belongs to (if any).
+func auditPatient(dao *daos.Dao, record *models.Record) string {
+	switch record.Collection().Name {
+	case "patients":
+		return record.Id
+	case "encounters", "queue":
+		return record.GetString("patient")
+	case "disbursements":
+		encounter, err := dao.FindRecordById("encounters", record.GetString("encounter"))
+		if err != nil {
+			return ""
+		}
+		return encounter.GetString("patient")
+	}
+
+	return ""
+}
+
+// auditHandler lists the audit_log entries, newest first, optionally
+// filtered by the patient, user, site, collection and record query
+// parameters.
+func auditHandler(app core.App, c echo.Context) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	perPage, _ := strconv.Atoi(c.QueryParam("perPage"))
+	if perPage < 1 || perPage > 500 {
+		perPage = 50
+	}
+
+	filters := dbx.HashExp{}
+	for _, param := range []string{"patient", "user", "site", "collection", "record"} {
+		if value := c.QueryParam(param); value != "" {
+			filters[param] = value
+		}
+	}
+
+	var total int
+	err := app.Dao().DB().
+		Select("COUNT(*)").
+		From("audit_log").
+		Where(filters).
+		Row(&total)
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the audit log.", err)
+	}
+
+	collection, err := app.Dao().FindCollectionByNameOrId("audit_log")
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the audit log.", err)
+	}
+
+	items := []*models.Record{}
+	err = app.Dao().RecordQuery(collection).
+		AndWhere(filters).
+		OrderBy("created DESC", "rowid DESC").
+		Offset(int64((page - 1) * perPage)).
+		Limit(int64(perPage)).
+		All(&items)
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the audit log.", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"page":       page,
+		"perPage":    perPage,
+		"totalItems": total,
+		"items":      items,
+	})
+}
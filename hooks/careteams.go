@@ -0,0 +1,94 @@
+package hooks
+
+import (
+	"fmt"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// registerCareTeamHooks only lets queue items be sent to active care teams
+// that have room left. Items keep their team when it is deactivated or
+// filled up afterwards.
+func registerCareTeamHooks(app core.App) {
+	app.OnModelBeforeCreate("queue").Add(func(e *core.ModelEvent) error {
+		item, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		return checkIntendedProvider(e.Dao, item, "")
+	})
+
+	app.OnModelBeforeUpdate("queue").Add(func(e *core.ModelEvent) error {
+		item, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		return checkIntendedProvider(e.Dao, item, item.OriginalCopy().GetString("intended_provider"))
+	})
+}
+
+// checkIntendedProvider validates the care team of item when it differs
+// from previous. A team with a capacity accepts that many queue items of
+// the current clinic day that are not completed yet, 0 means no limit.
+func checkIntendedProvider(dao *daos.Dao, item *models.Record, previous string) error {
+	teamId := item.GetString("intended_provider")
+	if teamId == "" || teamId == previous {
+		return nil
+	}
+
+	invalid := func(code string, message string) error {
+		return validation.Errors{
+			"intended_provider": validation.NewError(code, message),
+		}
+	}
+
+	team, err := dao.FindRecordById("care_teams", teamId)
+	if err != nil {
+		return invalid("validation_unknown_care_team", "The care team doesn't exist.")
+	}
+
+	name := team.GetString("name")
+
+	if !team.GetBool("active") {
+		return invalid(
+			"validation_inactive_care_team",
+			fmt.Sprintf("%s is not an active care team.", name),
+		)
+	}
+
+	capacity := team.GetInt("capacity")
+	if capacity <= 0 {
+		return nil
+	}
+
+	// items left open on earlier days don't take up the team
+	_, start, end := clinicDay(clinicLocation(dao), time.Now())
+
+	var assigned int
+	err = dao.DB().Select("count(*)").
+		From("queue").
+		Where(dbx.HashExp{"intended_provider": team.Id}).
+		AndWhere(dbx.Not(dbx.HashExp{"status": queueCompleted})).
+		AndWhere(dbx.NewExp("check_in_time >= {:start} AND check_in_time < {:end}", dbx.Params{"start": start, "end": end})).
+		AndWhere(dbx.Not(dbx.HashExp{"id": item.Id})).
+		Row(&assigned)
+	if err != nil {
+		return err
+	}
+
+	if assigned >= capacity {
+		return invalid(
+			"validation_care_team_full",
+			fmt.Sprintf("%s already has %d of %d patients.", name, assigned, capacity),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package hooks
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// registerDisbursementHooks keeps the inventory lots (and therefore
+// inventory.stock) in sync with the disbursements collection.
+//
+// The stock adjustment and the disbursement are written in the same
+// transaction, so a failed write never leaves the stock changed.
+func registerDisbursementHooks(app core.App) {
+	registerTransactionalWrites(app, "disbursements", txHooks{})
+
+	app.OnModelBeforeCreate("disbursements").Add(func(e *core.ModelEvent) error {
+		record, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		return adjustStockForDisbursement(e.Dao, nil, record)
+	})
+
+	app.OnModelBeforeUpdate("disbursements").Add(func(e *core.ModelEvent) error {
+		record, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		return adjustStockForDisbursement(e.Dao, record.OriginalCopy(), record)
+	})
+
+	app.OnModelBeforeDelete("disbursements").Add(func(e *core.ModelEvent) error {
+		record, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		return adjustStockForDisbursement(e.Dao, record, nil)
+	})
+}
+
+// disbursedAmount returns the number of stock units consumed by a
+// disbursement (quantity * multiplier). An unset multiplier counts as 1.
+func disbursedAmount(record *models.Record) float64 {
+	if record == nil {
+		return 0
+	}
+
+	multiplier := record.GetFloat("multiplier")
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+
+	return record.GetFloat("quantity") * multiplier
+}
+
+// adjustStockForDisbursement returns the stock held by the previous version
+// of a disbursement to its lots and draws the stock required by the next one
+// in FEFO order, storing the used lots in next's lot_allocations field.
+// Either side may be nil (create/delete). The adjustment joins the
+// transaction of dao, the one writing the disbursement.
+func adjustStockForDisbursement(dao *daos.Dao, prev, next *models.Record) error {
+	// nothing to move when neither the medication nor the amount changed
+	if prev != nil && next != nil &&
+		prev.GetString("medication") == next.GetString("medication") &&
+		disbursedAmount(prev) == disbursedAmount(next) {
+		next.Set("lot_allocations", prev.Get("lot_allocations"))
+		return nil
+	}
+
+	return dao.RunInTransaction(func(txDao *daos.Dao) error {
+		if prev != nil && prev.GetString("medication") != "" {
+			if err := returnDisbursementStock(txDao, prev); err != nil {
+				return err
+			}
+		}
+
+		if next != nil {
+			allocations := []lotAllocation{}
+
+			if next.GetString("medication") != "" {
+				var err error
+				allocations, err = drawDisbursementStock(txDao, next)
+				if err != nil {
+					return err
+				}
+			}
+
+			next.Set("lot_allocations", allocations)
+		}
+
+		return nil
+	})
+}
+
+// drawDisbursementStock takes the disbursed amount from the unexpired lots
+// of the linked inventory item. Requests that would drive the stock negative
+// are rejected with a validation error so that the API responds with a 400.
+func drawDisbursementStock(dao *daos.Dao, record *models.Record) ([]lotAllocation, error) {
+	item, err := dao.FindRecordById("inventory", record.GetString("medication"))
+	if err != nil {
+		return nil, validation.Errors{
+			"medication": validation.NewError("validation_unknown_medication", "The selected medication no longer exists in the inventory."),
+		}
+	}
+
+	allocations, err := drawLots(dao, item, disbursedAmount(record), false)
+	if err != nil {
+		return nil, err
+	}
+
+	// new records get their id on save, but the ledger entry needs it now
+	if !record.HasId() {
+		record.RefreshId()
+	}
+
+	return allocations, syncStock(dao, item.Id, stockChange{Reason: reasonDisbursement, Source: record})
+}
+
+// returnDisbursementStock gives the units of a disbursement back to the lots
+// they were drawn from.
+func returnDisbursementStock(dao *daos.Dao, record *models.Record) error {
+	item, err := dao.FindRecordById("inventory", record.GetString("medication"))
+	if err != nil {
+		// nothing to give back to an inventory item that was removed
+		return nil
+	}
+
+	allocations := []lotAllocation{}
+	if err := record.UnmarshalJSONField("lot_allocations", &allocations); err != nil {
+		// disbursements created before lot tracking have no allocations
+		allocations = nil
+	}
+
+	if err := returnLots(dao, item, allocations, disbursedAmount(record)); err != nil {
+		return err
+	}
+
+	return syncStock(dao, item.Id, stockChange{Reason: reasonDisbursementReturn, Source: record})
+}
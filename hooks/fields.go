@@ -0,0 +1,182 @@
+package hooks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"medical-records/accounts"
+	"medical-records/settings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/list"
+)
+
+var (
+	clinicalWriters   = []string{accounts.RoleProvider, accounts.RoleAdmin}
+	dispensingWriters = []string{accounts.RoleProvider, accounts.RolePharmacy, accounts.RoleAdmin}
+)
+
+// fieldWriters maps the fields of the patients, encounters and
+// disbursements collections to the roles allowed to write them through the
+// records API.
+// Fields missing from the map can only be written by PocketBase admins and
+// the server itself.
+var fieldWriters = map[string]map[string][]string{
+	"patients": {
+		"first_name": clinicalWriters,
+		"last_name":  clinicalWriters,
+		"dob":        clinicalWriters,
+		"age":        clinicalWriters,
+		"gender":     clinicalWriters,
+
+		// the pharmacy confirms these before dispensing
+		"allergies":        dispensingWriters,
+		"pregnancy_status": dispensingWriters,
+		"smoker":           dispensingWriters,
+	},
+	"encounters": {
+		"patient":               clinicalWriters,
+		"height":                clinicalWriters,
+		"weight":                clinicalWriters,
+		"temperature":           clinicalWriters,
+		"heart_rate":            clinicalWriters,
+		"systolic_pressure":     clinicalWriters,
+		"diastolic_pressure":    clinicalWriters,
+		"pulse_ox":              clinicalWriters,
+		"allergies":             clinicalWriters,
+		"past_medical_history":  clinicalWriters,
+		"chief_complaint":       clinicalWriters,
+		"other_chief_complaint": clinicalWriters,
+		"subjective_notes":      clinicalWriters,
+		"urinalysis":            clinicalWriters,
+		"urinalysis_result":     clinicalWriters,
+		"blood_sugar":           clinicalWriters,
+		"blood_sugar_result":    clinicalWriters,
+		"pregnancy_test":        clinicalWriters,
+		"pregnancy_test_result": clinicalWriters,
+		"diagnosis":             clinicalWriters,
+		"other_diagnosis":       clinicalWriters,
+	},
+	"disbursements": {
+		"encounter":       dispensingWriters,
+		"medication":      dispensingWriters,
+		"quantity":        dispensingWriters,
+		"multiplier":      dispensingWriters,
+		"frequency":       dispensingWriters,
+		"frequency_hours": dispensingWriters,
+		"notes":           dispensingWriters,
+
+		// linking a prescription to a diagnosis is a clinical decision
+		"associated_diagnosis": clinicalWriters,
+	},
+}
+
+// serverFields are written by other hooks and never by the clients, so the
+// field permissions ignore them.
+var serverFields = map[string][]string{
+	"encounters":    lockFields,
+	"disbursements": {"lot_allocations"},
+}
+
+// unifiedRoles share their permissions while display_preferences.unified_roles
+// is enabled.
+var unifiedRoles = []string{accounts.RoleProvider, accounts.RolePharmacy}
+
+// registerFieldHooks rejects record API creates and updates of patients,
+// encounters and disbursements that change fields the user's role may not
+// write.
+func registerFieldHooks(app core.App) {
+	app.OnRecordBeforeCreateRequest("patients", "encounters", "disbursements").Add(func(e *core.RecordCreateEvent) error {
+		return checkFieldWriters(app, e.HttpContext, models.NewRecord(e.Collection), e.Record)
+	})
+
+	app.OnRecordBeforeUpdateRequest("patients", "encounters", "disbursements").Add(func(e *core.RecordUpdateEvent) error {
+		return checkFieldWriters(app, e.HttpContext, e.Record.OriginalCopy(), e.Record)
+	})
+}
+
+// checkFieldWriters returns a 403 error listing the fields changed between
+// before and after that the request user may not write.
+func checkFieldWriters(app core.App, c echo.Context, before, after *models.Record) error {
+	if admin, _ := c.Get(apis.ContextAdminKey).(*models.Admin); admin != nil {
+		return nil
+	}
+
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	if user == nil {
+		// the collection rules already require an authenticated user
+		return nil
+	}
+	role := user.GetString("role")
+
+	preferences := settings.Current(app).DisplayPreferences
+	if preferences.OverrideFieldRestrictions &&
+		(role == accounts.RoleAdmin || preferences.OverrideFieldRestrictionsAllRoles) {
+		return nil
+	}
+
+	roles := []string{role}
+	if preferences.UnifiedRoles && list.ExistInSlice(role, unifiedRoles) {
+		roles = unifiedRoles
+	}
+
+	collection := after.Collection().Name
+	denied := validation.Errors{}
+
+	for _, field := range after.Collection().Schema.Fields() {
+		if list.ExistInSlice(field.Name, serverFields[collection]) {
+			continue
+		}
+
+		if SameValue(before.Get(field.Name), after.Get(field.Name)) {
+			continue
+		}
+
+		if !canWriteField(roles, fieldWriters[collection][field.Name]) {
+			denied[field.Name] = validation.NewError(
+				"validation_field_forbidden",
+				fmt.Sprintf("The %s role cannot change this field.", displayRole(role)),
+			)
+		}
+	}
+
+	if len(denied) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(denied))
+	for name := range denied {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return apis.NewForbiddenError(
+		fmt.Sprintf("You are not allowed to change %s.", strings.Join(names, ", ")),
+		denied,
+	)
+}
+
+// canWriteField reports whether one of roles is one of the writers.
+func canWriteField(roles []string, writers []string) bool {
+	for _, role := range roles {
+		if list.ExistInSlice(role, writers) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// displayRole returns the role name used in the error messages.
+func displayRole(role string) string {
+	if role == "" {
+		return "current"
+	}
+
+	return role
+}
@@ -0,0 +1,24 @@
+// Package hooks contains the server-side record hooks shared by the desktop
+// launcher (main.go) and the headless server (main.sevalla.go).
+package hooks
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Register attaches all MEDS record hooks to the provided app.
+// It must be called before the app is started.
+func Register(app core.App) {
+	registerActorHooks(app)
+	registerUserHooks(app)
+	registerInventoryHooks(app)
+	registerLedgerHooks(app)
+	registerAuditHooks(app)
+	registerLockHooks(app)
+	registerFieldHooks(app)
+	registerDisbursementHooks(app)
+	registerTimezoneHooks(app)
+	registerQueueHooks(app)
+	registerCareTeamHooks(app)
+	registerKioskHooks(app)
+}
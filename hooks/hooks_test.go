@@ -0,0 +1,37 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/migrate"
+
+	_ "medical-records/migrations"
+)
+
+// newTestApp returns an app with a fresh data directory, all the MEDS
+// migrations applied and the record hooks registered.
+func newTestApp(t *testing.T) core.App {
+	t.Helper()
+
+	app := core.NewBaseApp(core.BaseAppConfig{DataDir: t.TempDir()})
+	if err := app.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		app.ResetBootstrapState()
+	})
+
+	runner, err := migrate.NewRunner(app.DB(), m.AppMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	Register(app)
+
+	return app
+}
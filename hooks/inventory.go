@@ -0,0 +1,386 @@
+package hooks
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// lotAllocation records how much of a disbursement was drawn from a lot.
+type lotAllocation struct {
+	Lot      string  `json:"lot"`
+	Quantity float64 `json:"quantity"`
+}
+
+// registerInventoryHooks keeps inventory.stock equal to the sum of the
+// remaining quantities of its lots.
+//
+// Lot changes made through the API or the admin UI re-derive the item stock,
+// while direct edits of inventory.stock (eg. from the Inventory page) are
+// turned into lot adjustments so that the two never drift apart. The lot
+// movements and their ledger entries are written in the same transaction as
+// the inventory or lot change that caused them.
+func registerInventoryHooks(app core.App) {
+	registerTransactionalWrites(app, "inventory", txHooks{
+		afterCreate: func(txDao *daos.Dao, item *models.Record) error {
+			stock := item.GetFloat("stock")
+			if stock <= 0 {
+				return nil
+			}
+
+			if _, err := createLot(txDao, item.Id, "OPENING", stock); err != nil {
+				return err
+			}
+
+			return recordStockChange(txDao, item, stock, stockChange{Reason: reasonOpeningBalance, Source: item})
+		},
+	})
+
+	syncLotInventory := func(reason string) txHook {
+		return func(txDao *daos.Dao, lot *models.Record) error {
+			ids := []string{lot.GetString("inventory")}
+			if original := lot.OriginalCopy().GetString("inventory"); original != ids[0] {
+				ids = append(ids, original)
+			}
+
+			for _, id := range ids {
+				if err := syncStock(txDao, id, stockChange{Reason: reason, Source: lot}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+	}
+
+	registerTransactionalWrites(app, "inventory_lots", txHooks{
+		afterCreate: syncLotInventory(reasonLotReceived),
+		afterUpdate: syncLotInventory(reasonLotAdjustment),
+		afterDelete: syncLotInventory(reasonLotRemoved),
+	})
+
+	app.OnModelBeforeUpdate("inventory").Add(func(e *core.ModelEvent) error {
+		item, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		return reconcileLots(e.Dao, item)
+	})
+
+	app.OnModelBeforeCreate("inventory_lots").Add(func(e *core.ModelEvent) error {
+		lot, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		// a freshly received lot is full unless told otherwise
+		if lot.GetFloat("remaining_quantity") == 0 {
+			lot.Set("remaining_quantity", lot.GetFloat("received_quantity"))
+		}
+		if lot.GetFloat("received_quantity") == 0 {
+			lot.Set("received_quantity", lot.GetFloat("remaining_quantity"))
+		}
+
+		return nil
+	})
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		e.Router.GET("/api/meds/inventory/lots/expiring", func(c echo.Context) error {
+			return expiringLotsHandler(app, c)
+		}, apis.ActivityLogger(app), apis.RequireAdminOrRecordAuth())
+
+		return nil
+	})
+}
+
+// expiringLotsHandler lists the lots with remaining stock that expire within
+// the number of days given by the "days" query parameter (30 by default).
+// Already expired lots are included as well.
+func expiringLotsHandler(app core.App, c echo.Context) error {
+	days := 30
+	if raw := c.QueryParam("days"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return apis.NewBadRequestError("The days parameter must be a non-negative number.", nil)
+		}
+		days = parsed
+	}
+
+	until, err := types.ParseDateTime(time.Now().UTC().AddDate(0, 0, days))
+	if err != nil {
+		return apis.NewBadRequestError("", err)
+	}
+
+	lots, err := app.Dao().FindRecordsByFilter(
+		"inventory_lots",
+		"remaining_quantity > 0 && expiry_date != '' && expiry_date <= {:until}",
+		"expiry_date",
+		0,
+		0,
+		dbx.Params{"until": until.String()},
+	)
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the inventory lots.", err)
+	}
+
+	if errs := app.Dao().ExpandRecords(lots, []string{"inventory"}, nil); len(errs) > 0 {
+		return apis.NewBadRequestError("Failed to expand the inventory lots.", nil)
+	}
+
+	now := time.Now()
+	result := make([]map[string]any, 0, len(lots))
+	for _, lot := range lots {
+		expiry := lot.GetDateTime("expiry_date").Time()
+
+		entry := map[string]any{
+			"id":                 lot.Id,
+			"inventory":          lot.GetString("inventory"),
+			"lot_number":         lot.GetString("lot_number"),
+			"expiry_date":        lot.GetDateTime("expiry_date"),
+			"remaining_quantity": lot.GetFloat("remaining_quantity"),
+			"days_until_expiry":  int(expiry.Sub(now).Hours() / 24),
+			"expired":            expiry.Before(now),
+		}
+		if item := lot.ExpandedOne("inventory"); item != nil {
+			entry["drug_name"] = item.GetString("drug_name")
+			entry["dose"] = item.GetString("dose")
+		}
+
+		result = append(result, entry)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"days":  days,
+		"items": result,
+	})
+}
+
+// findLots returns the lots of an inventory item in first-expiring-first-out
+// order. Lots without an expiry date are used last.
+func findLots(dao *daos.Dao, inventoryId string) ([]*models.Record, error) {
+	lots, err := dao.FindRecordsByFilter(
+		"inventory_lots",
+		"inventory = {:inventory}",
+		"",
+		0,
+		0,
+		dbx.Params{"inventory": inventoryId},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(lots, func(i, j int) bool {
+		a := lots[i].GetDateTime("expiry_date")
+		b := lots[j].GetDateTime("expiry_date")
+
+		switch {
+		case a.IsZero() && b.IsZero():
+			return lots[i].Created.Time().Before(lots[j].Created.Time())
+		case a.IsZero():
+			return false
+		case b.IsZero():
+			return true
+		case a.Time().Equal(b.Time()):
+			return lots[i].Created.Time().Before(lots[j].Created.Time())
+		default:
+			return a.Time().Before(b.Time())
+		}
+	})
+
+	return lots, nil
+}
+
+// lotExpired reports whether the lot expiry date has passed.
+func lotExpired(lot *models.Record, now time.Time) bool {
+	expiry := lot.GetDateTime("expiry_date")
+	return !expiry.IsZero() && expiry.Time().Before(now)
+}
+
+// createLot adds a new lot without an expiry date to an inventory item.
+func createLot(dao *daos.Dao, inventoryId string, lotNumber string, quantity float64) (*models.Record, error) {
+	collection, err := dao.FindCollectionByNameOrId("inventory_lots")
+	if err != nil {
+		return nil, err
+	}
+
+	lot := models.NewRecord(collection)
+	lot.Set("inventory", inventoryId)
+	lot.Set("lot_number", lotNumber)
+	lot.Set("received_quantity", quantity)
+	lot.Set("remaining_quantity", quantity)
+
+	if err := dao.WithoutHooks().SaveRecord(lot); err != nil {
+		return nil, err
+	}
+
+	return lot, nil
+}
+
+// drawLots takes amount units of an inventory item from its lots in FEFO
+// order. Expired lots are skipped unless includeExpired is set.
+func drawLots(dao *daos.Dao, item *models.Record, amount float64, includeExpired bool) ([]lotAllocation, error) {
+	lots, err := findLots(dao, item.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	usable := make([]*models.Record, 0, len(lots))
+	available := 0.0
+	for _, lot := range lots {
+		if !includeExpired && lotExpired(lot, now) {
+			continue
+		}
+		usable = append(usable, lot)
+		available += lot.GetFloat("remaining_quantity")
+	}
+
+	if available < amount {
+		return nil, validation.Errors{
+			"quantity": validation.NewError(
+				"validation_insufficient_stock",
+				fmt.Sprintf("Not enough stock for %s. Needed: %g, available: %g.", item.GetString("drug_name"), amount, available),
+			),
+		}
+	}
+
+	allocations := []lotAllocation{}
+	left := amount
+	for _, lot := range usable {
+		if left <= 0 {
+			break
+		}
+
+		remaining := lot.GetFloat("remaining_quantity")
+		if remaining <= 0 {
+			continue
+		}
+
+		taken := min(remaining, left)
+		lot.Set("remaining_quantity", remaining-taken)
+		if err := dao.WithoutHooks().SaveRecord(lot); err != nil {
+			return nil, err
+		}
+
+		allocations = append(allocations, lotAllocation{Lot: lot.Id, Quantity: taken})
+		left -= taken
+	}
+
+	return allocations, nil
+}
+
+// returnLots puts previously drawn units back into the lots they came from.
+// Units without a (still existing) source lot go into an adjustment lot.
+func returnLots(dao *daos.Dao, item *models.Record, allocations []lotAllocation, amount float64) error {
+	unallocated := amount
+
+	for _, allocation := range allocations {
+		unallocated -= allocation.Quantity
+
+		lot, err := dao.FindRecordById("inventory_lots", allocation.Lot)
+		if err != nil {
+			unallocated += allocation.Quantity
+			continue
+		}
+
+		lot.Set("remaining_quantity", lot.GetFloat("remaining_quantity")+allocation.Quantity)
+		if err := dao.WithoutHooks().SaveRecord(lot); err != nil {
+			return err
+		}
+	}
+
+	if unallocated > 0 {
+		if _, err := createLot(dao, item.Id, "RETURNED", unallocated); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sumLots returns the total remaining quantity of an inventory item.
+func sumLots(dao *daos.Dao, inventoryId string) (float64, error) {
+	var total float64
+
+	err := dao.DB().
+		Select("COALESCE(SUM(remaining_quantity), 0)").
+		From("inventory_lots").
+		Where(dbx.HashExp{"inventory": inventoryId}).
+		Row(&total)
+
+	return total, err
+}
+
+// syncStock re-derives inventory.stock from the item lots and appends the
+// difference to the ledger. Missing inventory items are ignored.
+func syncStock(dao *daos.Dao, inventoryId string, change stockChange) error {
+	if inventoryId == "" {
+		return nil
+	}
+
+	item, err := dao.FindRecordById("inventory", inventoryId)
+	if err != nil {
+		return nil
+	}
+
+	total, err := sumLots(dao, inventoryId)
+	if err != nil {
+		return err
+	}
+
+	delta := total - item.GetFloat("stock")
+	if delta == 0 {
+		return nil
+	}
+
+	item.Set("stock", total)
+	if err := dao.SaveRecord(item); err != nil {
+		return err
+	}
+
+	return recordStockChange(dao, item, delta, change)
+}
+
+// reconcileLots turns a direct change of inventory.stock into lot movements:
+// increases are booked into a new adjustment lot and decreases are drawn
+// from the existing lots in FEFO order (expired lots first). dao must be the
+// one writing item, so that the movements share its transaction.
+func reconcileLots(dao *daos.Dao, item *models.Record) error {
+	stock := item.GetFloat("stock")
+	if stock < 0 {
+		return validation.Errors{
+			"stock": validation.NewError("validation_negative_stock", "Stock cannot be negative."),
+		}
+	}
+
+	total, err := sumLots(dao, item.Id)
+	if err != nil {
+		return err
+	}
+
+	delta := stock - total
+	switch {
+	case delta > 0:
+		_, err = createLot(dao, item.Id, "ADJUSTMENT", delta)
+	case delta < 0:
+		_, err = drawLots(dao, item, -delta, true)
+	}
+	if err != nil {
+		return err
+	}
+
+	return recordStockChange(dao, item, delta, stockChange{Reason: reasonManualAdjustment, Source: item})
+}
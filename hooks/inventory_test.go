@@ -0,0 +1,195 @@
+package hooks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// saveTestItem adds an inventory item without lots.
+func saveTestItem(t *testing.T, app core.App) *models.Record {
+	t.Helper()
+
+	collection, err := app.Dao().FindCollectionByNameOrId("inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := models.NewRecord(collection)
+	item.Set("drug_name", "Amoxicillin")
+	item.Set("drug_category", "Antibiotic")
+	item.Set("dose", "500mg")
+	item.Set("unit_size", "1 tablet")
+	item.Set("fixed_quantity", 10)
+	if err := app.Dao().WithoutHooks().SaveRecord(item); err != nil {
+		t.Fatal(err)
+	}
+
+	return item
+}
+
+// saveTestLot adds a lot to item. A zero expiry means the lot doesn't
+// expire, created orders the lots with the same expiry.
+func saveTestLot(t *testing.T, app core.App, item *models.Record, number string, quantity float64, expiry time.Time, created time.Time) *models.Record {
+	t.Helper()
+
+	collection, err := app.Dao().FindCollectionByNameOrId("inventory_lots")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lot := models.NewRecord(collection)
+	lot.Set("inventory", item.Id)
+	lot.Set("lot_number", number)
+	lot.Set("received_quantity", quantity)
+	lot.Set("remaining_quantity", quantity)
+	if !expiry.IsZero() {
+		lot.Set("expiry_date", expiry)
+	}
+	lot.Created, _ = types.ParseDateTime(created)
+	if err := app.Dao().WithoutHooks().SaveRecord(lot); err != nil {
+		t.Fatal(err)
+	}
+
+	return lot
+}
+
+// lotNumbers returns the lot numbers of lots.
+func lotNumbers(lots []*models.Record) []string {
+	numbers := make([]string, len(lots))
+	for i, lot := range lots {
+		numbers[i] = lot.GetString("lot_number")
+	}
+
+	return numbers
+}
+
+func TestFindLotsFEFO(t *testing.T) {
+	app := newTestApp(t)
+	item := saveTestItem(t, app)
+
+	now := time.Now()
+	saveTestLot(t, app, item, "LATE", 5, now.AddDate(0, 0, 60), now.Add(-4*time.Hour))
+	saveTestLot(t, app, item, "NO-EXPIRY", 5, time.Time{}, now.Add(-5*time.Hour))
+	saveTestLot(t, app, item, "SOON-NEWER", 5, now.AddDate(0, 0, 10), now.Add(-1*time.Hour))
+	saveTestLot(t, app, item, "SOON-OLDER", 5, now.AddDate(0, 0, 10), now.Add(-2*time.Hour))
+	saveTestLot(t, app, item, "NO-EXPIRY-NEWER", 5, time.Time{}, now.Add(-3*time.Hour))
+
+	lots, err := findLots(app.Dao(), item.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"SOON-OLDER", "SOON-NEWER", "LATE", "NO-EXPIRY", "NO-EXPIRY-NEWER"}
+	got := lotNumbers(lots)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected lots %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected lots %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestDrawLots(t *testing.T) {
+	now := time.Now()
+
+	scenarios := []struct {
+		name           string
+		amount         float64
+		includeExpired bool
+		expected       []lotAllocation // by lot number
+		insufficient   bool
+	}{
+		{
+			name:     "draws the first expiring lot first",
+			amount:   3,
+			expected: []lotAllocation{{Lot: "SOON", Quantity: 3}},
+		},
+		{
+			name:     "continues with the next lot",
+			amount:   6,
+			expected: []lotAllocation{{Lot: "SOON", Quantity: 4}, {Lot: "LATE", Quantity: 2}},
+		},
+		{
+			name:     "skips the expired lots",
+			amount:   10,
+			expected: []lotAllocation{{Lot: "SOON", Quantity: 4}, {Lot: "LATE", Quantity: 6}},
+		},
+		{
+			name:           "draws the expired lots when asked",
+			amount:         3,
+			includeExpired: true,
+			expected:       []lotAllocation{{Lot: "EXPIRED", Quantity: 3}},
+		},
+		{
+			name:         "expired lots don't count as stock",
+			amount:       11,
+			insufficient: true,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			app := newTestApp(t)
+			item := saveTestItem(t, app)
+
+			lots := map[string]*models.Record{
+				"EXPIRED": saveTestLot(t, app, item, "EXPIRED", 8, now.AddDate(0, 0, -1), now.Add(-3*time.Hour)),
+				"SOON":    saveTestLot(t, app, item, "SOON", 4, now.AddDate(0, 0, 5), now.Add(-2*time.Hour)),
+				"LATE":    saveTestLot(t, app, item, "LATE", 6, now.AddDate(0, 0, 90), now.Add(-1*time.Hour)),
+			}
+			numbers := map[string]string{}
+			for number, lot := range lots {
+				numbers[lot.Id] = number
+			}
+
+			allocations, err := drawLots(app.Dao(), item, s.amount, s.includeExpired)
+
+			if s.insufficient {
+				var errs validation.Errors
+				if !errors.As(err, &errs) || errs["quantity"] == nil {
+					t.Fatalf("Expected an insufficient stock error, got %v", err)
+				}
+				for number, lot := range lots {
+					stored, err := app.Dao().FindRecordById("inventory_lots", lot.Id)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if stored.GetFloat("remaining_quantity") != lot.GetFloat("remaining_quantity") {
+						t.Fatalf("Expected lot %s to be left untouched", number)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(allocations) != len(s.expected) {
+				t.Fatalf("Expected %d allocations, got %v", len(s.expected), allocations)
+			}
+			for i, expected := range s.expected {
+				got := allocations[i]
+				if numbers[got.Lot] != expected.Lot || got.Quantity != expected.Quantity {
+					t.Fatalf("Expected allocation %d to take %g from %s, got %g from %s", i, expected.Quantity, expected.Lot, got.Quantity, numbers[got.Lot])
+				}
+
+				stored, err := app.Dao().FindRecordById("inventory_lots", got.Lot)
+				if err != nil {
+					t.Fatal(err)
+				}
+				left := lots[expected.Lot].GetFloat("received_quantity") - expected.Quantity
+				if stored.GetFloat("remaining_quantity") != left {
+					t.Fatalf("Expected %g left in lot %s, got %g", left, expected.Lot, stored.GetFloat("remaining_quantity"))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package hooks
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// kioskPriority is the queue priority of self check-ins, the same as the
+// default of the check-in form.
+const kioskPriority = 3
+
+// kioskMaxNameLength bounds the names typed on the kiosk.
+const kioskMaxNameLength = 100
+
+// kioskCheckIn is the data a patient enters on the kiosk.
+type kioskCheckIn struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Dob       string `json:"dob"`
+	Gender    string `json:"gender"`
+}
+
+// validate trims the fields and checks them.
+func (k *kioskCheckIn) validate() error {
+	k.FirstName = strings.TrimSpace(k.FirstName)
+	k.LastName = strings.TrimSpace(k.LastName)
+	k.Dob = strings.TrimSpace(k.Dob)
+	k.Gender = strings.TrimSpace(k.Gender)
+
+	return validation.ValidateStruct(k,
+		validation.Field(&k.FirstName, validation.Required, validation.Length(1, kioskMaxNameLength)),
+		validation.Field(&k.LastName, validation.Required, validation.Length(1, kioskMaxNameLength)),
+		validation.Field(&k.Dob, validation.Date(time.DateOnly)),
+		validation.Field(&k.Gender, validation.Length(0, 20)),
+	)
+}
+
+// registerKioskHooks exposes the anonymous self check-in route used by the
+// waiting room kiosk. It is the only way to reach the patients without
+// signing in and it never returns patient data.
+func registerKioskHooks(app core.App) {
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		e.Router.POST("/api/meds/kiosk/check-in", func(c echo.Context) error {
+			return kioskCheckInHandler(app, c)
+		}, apis.ActivityLogger(app))
+
+		return nil
+	})
+}
+
+// kioskCheckInHandler adds the patient to the queue. A patient with the
+// same name and date of birth is reused, otherwise a minimal patient is
+// created. A patient already waiting keeps the current line number.
+func kioskCheckInHandler(app core.App, c echo.Context) error {
+	body := &kioskCheckIn{}
+	if err := c.Bind(body); err != nil {
+		return apis.NewBadRequestError("Failed to read the request data.", err)
+	}
+	if err := body.validate(); err != nil {
+		return apis.NewBadRequestError("Please check your details.", err)
+	}
+
+	result := struct {
+		LineNumber  int            `json:"line_number"`
+		CheckInTime types.DateTime `json:"check_in_time"`
+	}{}
+
+	err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		patient, err := kioskPatient(txDao, body)
+		if err != nil {
+			return err
+		}
+
+		item, err := waitingQueueItem(txDao, patient.Id)
+		if err != nil {
+			return err
+		}
+
+		if item == nil {
+			collection, err := txDao.FindCollectionByNameOrId("queue")
+			if err != nil {
+				return err
+			}
+
+			item = models.NewRecord(collection)
+			item.Set("patient", patient.Id)
+			item.Set("status", queueCheckedIn)
+			item.Set("priority", kioskPriority)
+
+			if err := txDao.SaveRecord(item); err != nil {
+				return err
+			}
+		}
+
+		result.LineNumber = item.GetInt("line_number")
+		result.CheckInTime = item.GetDateTime("check_in_time")
+
+		return nil
+	})
+	if err != nil {
+		return apis.NewBadRequestError("Failed to check in.", err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// kioskPatient returns the patient matching the kiosk data or creates it.
+// Patients are only matched when the date of birth is known.
+func kioskPatient(dao *daos.Dao, body *kioskCheckIn) (*models.Record, error) {
+	if body.Dob != "" {
+		existing, err := dao.FindRecordsByExpr("patients", dbx.NewExp(
+			"LOWER(TRIM([[first_name]])) = {:first} AND LOWER(TRIM([[last_name]])) = {:last} AND date([[dob]]) = {:dob}",
+			dbx.Params{
+				"first": strings.ToLower(body.FirstName),
+				"last":  strings.ToLower(body.LastName),
+				"dob":   body.Dob,
+			},
+		))
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) > 0 {
+			return existing[0], nil
+		}
+	}
+
+	collection, err := dao.FindCollectionByNameOrId("patients")
+	if err != nil {
+		return nil, err
+	}
+
+	patient := models.NewRecord(collection)
+	patient.Set("first_name", body.FirstName)
+	patient.Set("last_name", body.LastName)
+	patient.Set("gender", body.Gender)
+	if body.Dob != "" {
+		patient.Set("dob", body.Dob+" 00:00:00.000Z")
+	}
+
+	if err := dao.SaveRecord(patient); err != nil {
+		return nil, err
+	}
+
+	return patient, nil
+}
+
+// waitingQueueItem returns the queue item of patient from the current
+// clinic day that is not completed yet.
+func waitingQueueItem(dao *daos.Dao, patientId string) (*models.Record, error) {
+	_, start, end := clinicDay(clinicLocation(dao), time.Now())
+
+	items, err := dao.FindRecordsByFilter(
+		"queue",
+		"patient = {:patient} && status != {:completed} && check_in_time >= {:start} && check_in_time < {:end}",
+		"-check_in_time", 1, 0,
+		dbx.Params{"patient": patientId, "completed": queueCompleted, "start": start, "end": end},
+	)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+
+	return items[0], nil
+}
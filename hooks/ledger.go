@@ -0,0 +1,156 @@
+package hooks
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// Ledger reasons stored in inventory_transactions.reason.
+const (
+	reasonOpeningBalance     = "opening_balance"
+	reasonManualAdjustment   = "manual_adjustment"
+	reasonLotReceived        = "lot_received"
+	reasonLotAdjustment      = "lot_adjustment"
+	reasonLotRemoved         = "lot_removed"
+	reasonDisbursement       = "disbursement"
+	reasonDisbursementReturn = "disbursement_return"
+)
+
+// stockChange describes why inventory.stock changed.
+type stockChange struct {
+	Reason string
+
+	// Source is the record whose change caused the stock change.
+	Source *models.Record
+}
+
+// registerLedgerHooks makes the inventory_transactions ledger append-only
+// for API clients and exposes the reconciliation route.
+// The ledger entries themselves are written by the inventory hooks.
+func registerLedgerHooks(app core.App) {
+	app.OnRecordBeforeCreateRequest("inventory_transactions").Add(func(e *core.RecordCreateEvent) error {
+		return apis.NewForbiddenError("The inventory ledger is maintained by the server.", nil)
+	})
+
+	app.OnRecordBeforeUpdateRequest("inventory_transactions").Add(func(e *core.RecordUpdateEvent) error {
+		return apis.NewForbiddenError("The inventory ledger is append-only.", nil)
+	})
+
+	app.OnRecordBeforeDeleteRequest("inventory_transactions").Add(func(e *core.RecordDeleteEvent) error {
+		return apis.NewForbiddenError("The inventory ledger is append-only.", nil)
+	})
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		e.Router.GET("/api/meds/inventory/reconcile", func(c echo.Context) error {
+			return reconcileHandler(app, c)
+		}, apis.ActivityLogger(app), apis.RequireAdminOrRecordAuth())
+
+		return nil
+	})
+}
+
+// recordStockChange appends a ledger entry for a stock change of item.
+func recordStockChange(dao *daos.Dao, item *models.Record, delta float64, change stockChange) error {
+	if delta == 0 {
+		return nil
+	}
+
+	collection, err := dao.FindCollectionByNameOrId("inventory_transactions")
+	if err != nil {
+		return err
+	}
+
+	actor := actorOf(change.Source)
+
+	entry := models.NewRecord(collection)
+	entry.Set("inventory", item.Id)
+	entry.Set("delta", delta)
+	entry.Set("stock_after", item.GetFloat("stock"))
+	entry.Set("reason", change.Reason)
+	entry.Set("user", actor.UserId)
+	entry.Set("admin", actor.AdminId)
+	if change.Source != nil {
+		entry.Set("source_collection", change.Source.Collection().Name)
+		entry.Set("source_record", change.Source.Id)
+	}
+
+	return dao.SaveRecord(entry)
+}
+
+// reconcileHandler recomputes the stock of every inventory item from the
+// ledger and reports the items whose stored stock (or lot total) drifted.
+func reconcileHandler(app core.App, c echo.Context) error {
+	items, err := app.Dao().FindRecordsByExpr("inventory")
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the inventory.", err)
+	}
+
+	ledgerTotals, err := sumByInventory(app.Dao(), "inventory_transactions", "delta")
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the inventory ledger.", err)
+	}
+
+	lotTotals, err := sumByInventory(app.Dao(), "inventory_lots", "remaining_quantity")
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the inventory lots.", err)
+	}
+
+	result := make([]map[string]any, 0, len(items))
+	drifted := 0
+	for _, item := range items {
+		stock := item.GetFloat("stock")
+		ledger := ledgerTotals[item.Id]
+		lots := lotTotals[item.Id]
+
+		drift := stock - ledger
+		if drift != 0 || stock != lots {
+			drifted++
+		}
+
+		result = append(result, map[string]any{
+			"id":           item.Id,
+			"drug_name":    item.GetString("drug_name"),
+			"dose":         item.GetString("dose"),
+			"stock":        stock,
+			"ledger_stock": ledger,
+			"lot_stock":    lots,
+			"drift":        drift,
+			"lot_drift":    stock - lots,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"drifted": drifted,
+		"items":   result,
+	})
+}
+
+// sumByInventory returns the sum of column grouped by the inventory
+// relation of the given collection table.
+func sumByInventory(dao *daos.Dao, table string, column string) (map[string]float64, error) {
+	rows := []struct {
+		Inventory string  `db:"inventory"`
+		Total     float64 `db:"total"`
+	}{}
+
+	err := dao.DB().
+		Select("inventory", "COALESCE(SUM(["+column+"]), 0) AS total").
+		From(table).
+		GroupBy("inventory").
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		totals[row.Inventory] = row.Total
+	}
+
+	return totals, nil
+}
@@ -0,0 +1,375 @@
+package hooks
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"medical-records/settings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/list"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// lockSweepInterval is how often expired encounter locks are released.
+const lockSweepInterval = time.Minute
+
+// forceUnlockRoles may edit encounters locked by someone else and take over
+// or release their locks. PocketBase admins always can.
+var forceUnlockRoles = []string{"admin"}
+
+// lockFields are the encounter fields owned by the lock routes.
+var lockFields = []string{"active_editor", "last_edit_activity"}
+
+// encounterLock describes the lock of an encounter as returned by the
+// lock routes.
+type encounterLock struct {
+	Encounter    string         `json:"encounter"`
+	Holder       string         `json:"holder"`
+	HolderName   string         `json:"holderName"`
+	LastActivity types.DateTime `json:"lastActivity"`
+	Expires      types.DateTime `json:"expires"`
+	HeldByYou    bool           `json:"heldByYou"`
+}
+
+// registerLockHooks exposes the encounter lock routes, rejects updates and
+// deletes of encounters locked by someone else and releases idle locks in
+// the background.
+func registerLockHooks(app core.App) {
+	sweeper := &lockSweeper{app: app}
+
+	app.OnRecordBeforeUpdateRequest("encounters").Add(func(e *core.RecordUpdateEvent) error {
+		original := e.Record.OriginalCopy()
+
+		// the lock can only be changed through the lock routes
+		for _, field := range lockFields {
+			e.Record.Set(field, original.Get(field))
+		}
+
+		return checkEncounterLock(app.Dao(), e.HttpContext, original)
+	})
+
+	app.OnRecordBeforeDeleteRequest("encounters").Add(func(e *core.RecordDeleteEvent) error {
+		return checkEncounterLock(app.Dao(), e.HttpContext, e.Record)
+	})
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		e.Router.POST("/api/meds/encounters/:id/lock", func(c echo.Context) error {
+			return acquireLockHandler(app, c)
+		}, apis.ActivityLogger(app), apis.RequireRecordAuth("users"))
+
+		e.Router.POST("/api/meds/encounters/:id/lock/heartbeat", func(c echo.Context) error {
+			return heartbeatLockHandler(app, c)
+		}, apis.ActivityLogger(app), apis.RequireRecordAuth("users"))
+
+		e.Router.DELETE("/api/meds/encounters/:id/lock", func(c echo.Context) error {
+			return releaseLockHandler(app, c)
+		}, apis.ActivityLogger(app), apis.RequireAdminOrRecordAuth("users"))
+
+		sweeper.start()
+
+		return nil
+	})
+
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		sweeper.stop()
+		return nil
+	})
+}
+
+// acquireLockHandler locks an encounter for the authenticated user.
+// Locks held by someone else are only taken over when they expired or when
+// the user has a force-unlock role and sends {"force": true}.
+func acquireLockHandler(app core.App, c echo.Context) error {
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+
+	body := struct {
+		Force bool `json:"force"`
+	}{}
+	if c.Request().ContentLength > 0 {
+		if err := c.Bind(&body); err != nil {
+			return apis.NewBadRequestError("Failed to read the request data.", err)
+		}
+	}
+
+	var lock *encounterLock
+
+	err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		encounter, err := txDao.FindRecordById("encounters", c.PathParam("id"))
+		if err != nil {
+			return apis.NewNotFoundError("", err)
+		}
+
+		timeout := lockTimeout(txDao)
+
+		holder := activeLockHolder(encounter, timeout)
+		if holder != "" && holder != user.Id && !(body.Force && canForceUnlock(c)) {
+			return lockConflictError(txDao, encounter, user, timeout)
+		}
+
+		encounter.Set("active_editor", user.Id)
+		encounter.Set("last_edit_activity", types.NowDateTime())
+		if err := txDao.SaveRecord(encounter); err != nil {
+			return err
+		}
+
+		lock = describeLock(txDao, encounter, user, timeout)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, lock)
+}
+
+// heartbeatLockHandler keeps the lock of the authenticated user alive.
+// It fails with 409 once the lock was released or taken over.
+func heartbeatLockHandler(app core.App, c echo.Context) error {
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+
+	var lock *encounterLock
+
+	err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		encounter, err := txDao.FindRecordById("encounters", c.PathParam("id"))
+		if err != nil {
+			return apis.NewNotFoundError("", err)
+		}
+
+		timeout := lockTimeout(txDao)
+
+		if activeLockHolder(encounter, timeout) != user.Id {
+			return lockConflictError(txDao, encounter, user, timeout)
+		}
+
+		encounter.Set("last_edit_activity", types.NowDateTime())
+		if err := txDao.SaveRecord(encounter); err != nil {
+			return err
+		}
+
+		lock = describeLock(txDao, encounter, user, timeout)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, lock)
+}
+
+// releaseLockHandler releases the lock of an encounter. Only the holder and
+// users with a force-unlock role may release an active lock.
+func releaseLockHandler(app core.App, c echo.Context) error {
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+
+	err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		encounter, err := txDao.FindRecordById("encounters", c.PathParam("id"))
+		if err != nil {
+			return apis.NewNotFoundError("", err)
+		}
+
+		if encounter.GetString("active_editor") == "" {
+			return nil
+		}
+
+		holder := activeLockHolder(encounter, lockTimeout(txDao))
+		if holder != "" && (user == nil || holder != user.Id) && !canForceUnlock(c) {
+			return apis.NewForbiddenError("Only the editor holding the lock can release it.", nil)
+		}
+
+		return releaseLock(txDao, encounter)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// checkEncounterLock rejects changes to an encounter whose active lock is
+// held by someone other than the request user.
+func checkEncounterLock(dao *daos.Dao, c echo.Context, encounter *models.Record) error {
+	timeout := lockTimeout(dao)
+
+	holder := activeLockHolder(encounter, timeout)
+	if holder == "" || canForceUnlock(c) {
+		return nil
+	}
+
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	if user != nil && user.Id == holder {
+		return nil
+	}
+
+	return lockConflictError(dao, encounter, user, timeout)
+}
+
+// activeLockHolder returns the id of the user holding the lock of encounter
+// or an empty string when it is unlocked or the lock expired.
+func activeLockHolder(encounter *models.Record, timeout time.Duration) string {
+	holder := encounter.GetString("active_editor")
+	if holder == "" {
+		return ""
+	}
+
+	lastActivity := encounter.GetDateTime("last_edit_activity")
+	if lastActivity.IsZero() || time.Since(lastActivity.Time()) > timeout {
+		return ""
+	}
+
+	return holder
+}
+
+// canForceUnlock reports whether the request was made by a PocketBase admin
+// or by a user with one of the forceUnlockRoles.
+func canForceUnlock(c echo.Context) bool {
+	if admin, _ := c.Get(apis.ContextAdminKey).(*models.Admin); admin != nil {
+		return true
+	}
+
+	user, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+
+	return user != nil && list.ExistInSlice(user.GetString("role"), forceUnlockRoles)
+}
+
+// lockConflictError builds the 409 error returned when the lock of encounter
+// is held by someone else.
+func lockConflictError(dao *daos.Dao, encounter, user *models.Record, timeout time.Duration) error {
+	editor := "someone else"
+	if lock := describeLock(dao, encounter, user, timeout); lock.HolderName != "" {
+		editor = lock.HolderName
+	}
+
+	return apis.NewApiError(
+		http.StatusConflict,
+		fmt.Sprintf("The encounter is being edited by %s.", editor),
+		nil,
+	)
+}
+
+// describeLock returns the lock of encounter as seen by user.
+func describeLock(dao *daos.Dao, encounter, user *models.Record, timeout time.Duration) *encounterLock {
+	lock := &encounterLock{
+		Encounter:    encounter.Id,
+		Holder:       activeLockHolder(encounter, timeout),
+		LastActivity: encounter.GetDateTime("last_edit_activity"),
+	}
+
+	if lock.Holder == "" {
+		return lock
+	}
+
+	expires, _ := types.ParseDateTime(lock.LastActivity.Time().Add(timeout))
+	lock.Expires = expires
+	lock.HeldByYou = user != nil && user.Id == lock.Holder
+
+	if holder, err := dao.FindRecordById("users", lock.Holder); err == nil {
+		lock.HolderName = holder.GetString("name")
+		if lock.HolderName == "" {
+			lock.HolderName = holder.Username()
+		}
+	}
+
+	return lock
+}
+
+// releaseLock clears the lock fields of encounter.
+func releaseLock(dao *daos.Dao, encounter *models.Record) error {
+	encounter.Set("active_editor", "")
+	encounter.Set("last_edit_activity", "")
+
+	return dao.SaveRecord(encounter)
+}
+
+// lockTimeout returns the idle period after which encounter locks expire.
+func lockTimeout(dao *daos.Dao) time.Duration {
+	return settings.Load(dao).LockTimeout()
+}
+
+// lockSweeper periodically releases the encounter locks that expired.
+type lockSweeper struct {
+	app core.App
+
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	cancel chan struct{}
+}
+
+func (s *lockSweeper) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		return
+	}
+
+	s.cancel = make(chan struct{})
+	s.wg.Add(1)
+	go s.loop(s.cancel)
+}
+
+func (s *lockSweeper) stop() {
+	s.mu.Lock()
+	if s.cancel != nil {
+		close(s.cancel)
+		s.cancel = nil
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *lockSweeper) loop(cancel <-chan struct{}) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(lockSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sweep()
+
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep releases all encounter locks that have been idle for longer than
+// the lock timeout.
+func (s *lockSweeper) sweep() {
+	dao := s.app.Dao()
+	if dao == nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-lockTimeout(dao)).UTC().Format(types.DefaultDateLayout)
+
+	encounters, err := dao.FindRecordsByFilter(
+		"encounters",
+		"active_editor != '' && (last_edit_activity = '' || last_edit_activity < {:cutoff})",
+		"", 0, 0,
+		dbx.Params{"cutoff": cutoff},
+	)
+	if err != nil {
+		log.Printf("Failed to find the expired encounter locks: %v", err)
+		return
+	}
+
+	for _, encounter := range encounters {
+		if err := releaseLock(dao, encounter); err != nil {
+			log.Printf("Failed to release the lock of encounter %s: %v", encounter.Id, err)
+		}
+	}
+}
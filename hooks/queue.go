@@ -0,0 +1,288 @@
+package hooks
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/list"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Queue statuses in workflow order.
+const (
+	queueCheckedIn     = "checked_in"
+	queueWithCareTeam  = "with_care_team"
+	queueReadyPharmacy = "ready_pharmacy"
+	queueWithPharmacy  = "with_pharmacy"
+	queueAtCheckout    = "at_checkout"
+	queueCompleted     = "completed"
+)
+
+// queueTransitions lists the statuses a queue item may move to from each
+// status. Besides the regular workflow it allows:
+//   - sending a patient back to the waiting room or to the care team
+//   - skipping the pharmacy (or the checkout) when there is nothing to dispense
+//
+// completed is final.
+var queueTransitions = map[string][]string{
+	queueCheckedIn:     {queueWithCareTeam},
+	queueWithCareTeam:  {queueReadyPharmacy, queueCheckedIn, queueAtCheckout, queueCompleted},
+	queueReadyPharmacy: {queueWithPharmacy, queueWithCareTeam, queueAtCheckout},
+	queueWithPharmacy:  {queueAtCheckout, queueReadyPharmacy, queueCompleted},
+	queueAtCheckout:    {queueCompleted},
+	queueCompleted:     {},
+}
+
+// encounterCarryOverFields are copied from the patient to a new encounter
+// when the patient record has them.
+var encounterCarryOverFields = []string{
+	"height",
+	"weight",
+	"temperature",
+	"heart_rate",
+	"systolic_pressure",
+	"diastolic_pressure",
+	"pulse_ox",
+	"allergies",
+}
+
+// registerQueueHooks enforces the queue status transitions, assigns the
+// daily line numbers, stamps the check_in_time, start_time and end_time
+// fields and exposes the start encounter route.
+func registerQueueHooks(app core.App) {
+	// the line number counter is incremented in the insert's transaction
+	registerTransactionalWrites(app, "queue", txHooks{})
+
+	app.OnModelBeforeCreate("queue").Add(func(e *core.ModelEvent) error {
+		item, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		status := item.GetString("status")
+		if status == "" {
+			status = queueCheckedIn
+			item.Set("status", status)
+		}
+
+		if status != queueCheckedIn {
+			return validation.Errors{
+				"status": validation.NewError(
+					"validation_invalid_queue_status",
+					fmt.Sprintf("New queue items must start as %s.", queueCheckedIn),
+				),
+			}
+		}
+
+		if item.GetDateTime("check_in_time").IsZero() {
+			item.Set("check_in_time", types.NowDateTime())
+		}
+
+		// the server owns the numbering, client values would clash
+		number, err := nextLineNumber(e.Dao, time.Now())
+		if err != nil {
+			return err
+		}
+		item.Set("line_number", number)
+
+		return nil
+	})
+
+	app.OnModelBeforeUpdate("queue").Add(func(e *core.ModelEvent) error {
+		item, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		return applyQueueTransition(item.OriginalCopy(), item)
+	})
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		e.Router.POST("/api/meds/queue/:id/start-encounter", func(c echo.Context) error {
+			return startEncounterHandler(app, c)
+		}, apis.ActivityLogger(app), apis.RequireAdminOrRecordAuth("users"))
+
+		return nil
+	})
+}
+
+// applyQueueTransition validates the move of item from the status of its
+// original version to its current status and stamps the visit times.
+// Client provided times are replaced by the server time.
+func applyQueueTransition(original, item *models.Record) error {
+	from := original.GetString("status")
+	to := item.GetString("status")
+	if from == to {
+		return nil
+	}
+
+	allowed, known := queueTransitions[from]
+	if known && !list.ExistInSlice(to, allowed) {
+		message := fmt.Sprintf("A queue item cannot move from %s to %s.", from, to)
+		if len(allowed) == 0 {
+			message += fmt.Sprintf(" %s is final.", from)
+		} else {
+			message += fmt.Sprintf(" Allowed: %s.", strings.Join(allowed, ", "))
+		}
+
+		return validation.Errors{
+			"status": validation.NewError("validation_invalid_queue_transition", message),
+		}
+	}
+
+	now := types.NowDateTime()
+
+	switch to {
+	case queueWithCareTeam:
+		// keep the first contact time when a patient returns to the care team
+		if start := original.GetDateTime("start_time"); !start.IsZero() {
+			item.Set("start_time", start)
+		} else {
+			item.Set("start_time", now)
+		}
+	case queueCompleted:
+		item.Set("end_time", now)
+	}
+
+	return nil
+}
+
+// nextLineNumber hands out the next line number of the clinic day containing
+// now. The counter row of a day starts after the highest number already used
+// that day. dao must be the one inserting the queue item: the counter is then
+// incremented in the same transaction as the insert, so concurrent check-ins
+// get unique, sequential numbers and a failed insert gives its number back.
+func nextLineNumber(dao *daos.Dao, now time.Time) (int, error) {
+	day, start, end := clinicDay(clinicLocation(dao), now)
+
+	var number int
+
+	err := dao.RunInTransaction(func(txDao *daos.Dao) error {
+		return txDao.DB().NewQuery(`
+			INSERT INTO queue_line_counters (day, last_number)
+			VALUES ({:day}, (
+				SELECT COALESCE(MAX(line_number), 0) + 1
+				FROM queue
+				WHERE created >= {:start} AND created < {:end}
+			))
+			ON CONFLICT(day) DO UPDATE SET last_number = last_number + 1
+			RETURNING last_number
+		`).Bind(dbx.Params{
+			"day":   day,
+			"start": start,
+			"end":   end,
+		}).Row(&number)
+	})
+
+	return number, err
+}
+
+// startEncounterHandler moves a queue item to the care team in a single
+// transaction. It links the encounter already attached to the queue item,
+// the latest encounter of the patient from the current clinic day or a new
+// encounter with the patient's vitals and assigns the item to the request
+// user.
+//
+// Calling it again for an item that is already with the care team returns
+// the linked encounter without changing anything, so concurrent clicks
+// never create duplicate encounters.
+func startEncounterHandler(app core.App, c echo.Context) error {
+	actor := contextActor(c)
+
+	result := struct {
+		Encounter string         `json:"encounter"`
+		Created   bool           `json:"created"`
+		Queue     *models.Record `json:"queue"`
+	}{}
+
+	err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		item, err := txDao.FindRecordById("queue", c.PathParam("id"))
+		if err != nil {
+			return apis.NewNotFoundError("", err)
+		}
+
+		result.Queue = item
+		result.Encounter = item.GetString("encounter")
+
+		if item.GetString("status") == queueWithCareTeam && result.Encounter != "" {
+			return nil
+		}
+
+		if result.Encounter == "" {
+			encounter, created, err := todaysEncounter(txDao, item.GetString("patient"), actor)
+			if err != nil {
+				return err
+			}
+
+			result.Encounter = encounter.Id
+			result.Created = created
+		}
+
+		item.Set("status", queueWithCareTeam)
+		item.Set("encounter", result.Encounter)
+		if actor.UserId != "" {
+			item.Set("assigned_to", actor.UserId)
+		}
+		item.Set(actorKey, actor)
+
+		return txDao.SaveRecord(item)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// todaysEncounter returns the latest encounter of patient from the current
+// clinic day or creates a new one with the patient's vitals.
+func todaysEncounter(dao *daos.Dao, patientId string, actor requestActor) (*models.Record, bool, error) {
+	_, start, end := clinicDay(clinicLocation(dao), time.Now())
+
+	existing, err := dao.FindRecordsByFilter(
+		"encounters",
+		"patient = {:patient} && created >= {:start} && created < {:end}",
+		"-created", 1, 0,
+		dbx.Params{"patient": patientId, "start": start, "end": end},
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(existing) > 0 {
+		return existing[0], false, nil
+	}
+
+	patient, err := dao.FindRecordById("patients", patientId)
+	if err != nil {
+		return nil, false, apis.NewBadRequestError("The queue item has no valid patient.", err)
+	}
+
+	collection, err := dao.FindCollectionByNameOrId("encounters")
+	if err != nil {
+		return nil, false, err
+	}
+
+	encounter := models.NewRecord(collection)
+	encounter.Set("patient", patient.Id)
+	for _, field := range encounterCarryOverFields {
+		if patient.Collection().Schema.GetFieldByName(field) != nil {
+			encounter.Set(field, patient.Get(field))
+		}
+	}
+	encounter.Set(actorKey, actor)
+
+	if err := dao.SaveRecord(encounter); err != nil {
+		return nil, false, err
+	}
+
+	return encounter, true, nil
+}
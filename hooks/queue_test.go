@@ -0,0 +1,116 @@
+package hooks
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// saveTestPatient adds a patient without running the record hooks.
+func saveTestPatient(t *testing.T, app core.App) *models.Record {
+	t.Helper()
+
+	collection, err := app.Dao().FindCollectionByNameOrId("patients")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patient := models.NewRecord(collection)
+	patient.Set("first_name", "Ana")
+	patient.Set("last_name", "Lopez")
+	patient.Set("dob", "1990-02-03 00:00:00.000Z")
+	patient.Set("gender", "female")
+	patient.Set("age", 34)
+	if err := app.Dao().WithoutHooks().SaveRecord(patient); err != nil {
+		t.Fatal(err)
+	}
+
+	return patient
+}
+
+// checkIn adds a queue item for patient through the record hooks.
+func checkIn(app core.App, patient *models.Record) (*models.Record, error) {
+	collection, err := app.Dao().FindCollectionByNameOrId("queue")
+	if err != nil {
+		return nil, err
+	}
+
+	item := models.NewRecord(collection)
+	item.Set("patient", patient.Id)
+
+	return item, app.Dao().SaveRecord(item)
+}
+
+func TestLineNumbersConcurrentCheckIns(t *testing.T) {
+	app := newTestApp(t)
+	patient := saveTestPatient(t, app)
+
+	const total = 20
+
+	var wg sync.WaitGroup
+	numbers := make([]int, total)
+	errs := make([]error, total)
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			item, err := checkIn(app, patient)
+			errs[i] = err
+			if err == nil {
+				numbers[i] = item.GetInt("line_number")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sort.Ints(numbers)
+	for i, number := range numbers {
+		if number != i+1 {
+			t.Fatalf("Expected the line numbers 1 to %d, got %v", total, numbers)
+		}
+	}
+}
+
+func TestLineNumbersFailedCheckIn(t *testing.T) {
+	app := newTestApp(t)
+	patient := saveTestPatient(t, app)
+
+	fail := false
+	app.OnModelBeforeCreate("queue").Add(func(e *core.ModelEvent) error {
+		if fail {
+			return errors.New("check-in failed")
+		}
+		return nil
+	})
+
+	first, err := checkIn(app, patient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fail = true
+	if _, err := checkIn(app, patient); err == nil {
+		t.Fatal("Expected the check-in to fail")
+	}
+	fail = false
+
+	second, err := checkIn(app, patient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.GetInt("line_number") != 1 || second.GetInt("line_number") != 2 {
+		t.Fatalf("Expected the line numbers 1 and 2, got %d and %d", first.GetInt("line_number"), second.GetInt("line_number"))
+	}
+}
@@ -0,0 +1,60 @@
+package hooks
+
+import (
+	"time"
+	_ "time/tzdata" // the clinic timezone must resolve on hosts without a zoneinfo database
+
+	"medical-records/settings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// registerTimezoneHooks rejects settings with an unknown clinic timezone.
+func registerTimezoneHooks(app core.App) {
+	validate := func(e *core.ModelEvent) error {
+		settings, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		name := settings.GetString("clinic_timezone")
+		if name == "" {
+			return nil
+		}
+
+		if _, err := time.LoadLocation(name); err != nil {
+			return validation.Errors{
+				"clinic_timezone": validation.NewError(
+					"validation_invalid_timezone",
+					"Unknown timezone, use an IANA name such as America/Guatemala.",
+				),
+			}
+		}
+
+		return nil
+	}
+
+	app.OnModelBeforeCreate("settings").Add(validate)
+	app.OnModelBeforeUpdate("settings").Add(validate)
+}
+
+// clinicLocation returns the timezone that defines the clinic day.
+// It falls back to the server timezone when the settings don't name one.
+func clinicLocation(dao *daos.Dao) *time.Location {
+	return settings.Load(dao).Location()
+}
+
+// clinicDay returns the clinic day containing t as YYYY-MM-DD together with
+// its bounds in the PocketBase datetime format.
+func clinicDay(location *time.Location, t time.Time) (day, start, end string) {
+	local := t.In(location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+
+	return midnight.Format("2006-01-02"),
+		midnight.UTC().Format(types.DefaultDateLayout),
+		midnight.AddDate(0, 0, 1).UTC().Format(types.DefaultDateLayout)
+}
@@ -0,0 +1,81 @@
+package hooks
+
+import (
+	"sync"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/hook"
+)
+
+// txHook runs inside the transaction of a record write, right after the row
+// was written.
+type txHook func(txDao *daos.Dao, record *models.Record) error
+
+// txHooks are the hooks of registerTransactionalWrites. Unlike the
+// OnModelAfter* hooks, which PocketBase only calls once the transaction is
+// committed, their changes are committed or rolled back together with the
+// record.
+type txHooks struct {
+	afterCreate txHook
+	afterUpdate txHook
+	afterDelete txHook
+}
+
+// registerTransactionalWrites runs every create, update and delete of the
+// collection in a single transaction together with its OnModelBefore* hooks
+// and the given txHooks.
+//
+// PocketBase v0.22 writes API records without a transaction, so changes
+// made by the before hooks (eg. stock movements) would otherwise be
+// committed even when writing the record fails. The write is therefore
+// restarted inside a transaction and the original one is skipped. Writes
+// that already run in a transaction (eg. record deletes) simply join it.
+//
+// The wrappers are prepended to the model hooks, so that every other hook
+// of the collection only runs once, inside the transaction.
+func registerTransactionalWrites(app core.App, collection string, hooks txHooks) {
+	// the models whose write is currently restarted inside a transaction
+	var writing sync.Map
+
+	wrap := func(write func(txDao *daos.Dao, m models.Model) error, after txHook) func(e *core.ModelEvent) error {
+		return func(e *core.ModelEvent) error {
+			// the write restarted below, let it through
+			if _, busy := writing.LoadOrStore(e.Model, struct{}{}); busy {
+				return nil
+			}
+			defer writing.Delete(e.Model)
+
+			err := e.Dao.RunInTransaction(func(txDao *daos.Dao) error {
+				if err := write(txDao, e.Model); err != nil {
+					return err
+				}
+
+				record, ok := e.Model.(*models.Record)
+				if !ok || after == nil {
+					return nil
+				}
+
+				return after(txDao, record)
+			})
+			if err != nil {
+				return err
+			}
+
+			// the record was written, skip the write outside the transaction
+			return hook.StopPropagation
+		}
+	}
+
+	save := func(txDao *daos.Dao, m models.Model) error {
+		return txDao.Save(m)
+	}
+	remove := func(txDao *daos.Dao, m models.Model) error {
+		return txDao.Delete(m)
+	}
+
+	app.OnModelBeforeCreate(collection).PreAdd(wrap(save, hooks.afterCreate))
+	app.OnModelBeforeUpdate(collection).PreAdd(wrap(save, hooks.afterUpdate))
+	app.OnModelBeforeDelete(collection).PreAdd(wrap(remove, hooks.afterDelete))
+}
@@ -0,0 +1,18 @@
+package hooks
+
+import (
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// registerUserHooks keeps deactivated users from signing in.
+// Their existing tokens are invalidated when they are deactivated.
+func registerUserHooks(app core.App) {
+	app.OnRecordAuthRequest("users").Add(func(e *core.RecordAuthEvent) error {
+		if e.Record.GetBool("deactivated") {
+			return apis.NewForbiddenError("This account was deactivated, please contact the clinic admin.", nil)
+		}
+
+		return nil
+	})
+}
@@ -1,21 +1,34 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"medical-records/accounts"
+	"medical-records/backup"
+	"medical-records/hooks"
+	"medical-records/network"
+	"medical-records/settings"
+	"medical-records/sitesync"
+	"medical-records/stats"
+	"medical-records/transfer"
 	_ "medical-records/migrations"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/data/binding"
 	"fyne.io/fyne/v2/dialog"
@@ -26,7 +39,11 @@ import (
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
 	"github.com/pocketbase/pocketbase/plugins/migratecmd"
+	"github.com/pocketbase/pocketbase/tools/list"
+	"github.com/skip2/go-qrcode"
+	"github.com/spf13/pflag"
 )
 
 var (
@@ -36,9 +53,48 @@ var (
 	patientCount   = binding.NewString()
 	encounterCount = binding.NewString()
 	serverRunning  = false
+	serverStopping = false
+	serverDone     chan struct{}  // closed once the running server has fully stopped
+	serverConfig   network.Config // listen config of the running server
 	serverMutex    sync.Mutex
+
+	checkInsToday      = binding.NewString()
+	disbursementsToday = binding.NewString()
+	lowStockCount      = binding.NewString()
+	clinicTimezoneText = binding.NewString()
+	queueCounts        = map[string]binding.String{}
+
+	mainWindow fyne.Window
+
+	// connectCodes holds the QR codes of the Connect Devices panel
+	connectCodes *fyne.Container
+
+	// demoMode seeds the demo accounts, enabled with --demo
+	demoMode bool
+
+	backupScheduler    *backup.Scheduler
+	mdnsAdvertiser     *network.Advertiser
+	backupScheduleText = binding.NewString()
+	lastBackupText     = binding.NewString()
+	nextBackupText     = binding.NewString()
 )
 
+// queueStatusLabels are the Dashboard labels of the queue statuses.
+var queueStatusLabels = map[string]string{
+	"checked_in":     "Checked In",
+	"with_care_team": "With Care Team",
+	"ready_pharmacy": "Ready for Pharmacy",
+	"with_pharmacy":  "With Pharmacy",
+	"at_checkout":    "At Checkout",
+	"completed":      "Completed",
+}
+
+// backupSchedulePreference is the preferences key of the automatic backup schedule
+const backupSchedulePreference = "backup_schedule"
+
+// serverConfigPreference is the preferences key of the server listen config
+const serverConfigPreference = "server_config"
+
 // Custom log writer to capture logs for the GUI
 type logWriter struct{}
 
@@ -61,14 +117,21 @@ func main() {
 	// Set up custom logger
 	log.SetOutput(logWriter{})
 
+	demoMode = list.ExistInSlice("--demo", os.Args[1:])
+
 	// Initialize status
 	serverStatus.Set("Stopped")
-	patientCount.Set("0")
-	encounterCount.Set("0")
+	for _, status := range stats.QueueStatuses {
+		queueCounts[status] = binding.NewString()
+	}
+	updateDashboardStats(stats.Snapshot{})
+	updateClinicSettings(settings.Defaults())
 
 	// Create Fyne app
-	a := app.New()
+	a := app.NewWithID("org.meds.launcher")
+	updateBackupStatus(backup.Status{Schedule: loadBackupSchedule()})
 	w := a.NewWindow("Medical Records System")
+	mainWindow = w
 	w.Resize(fyne.NewSize(800, 600))
 
 	// Create tabs
@@ -81,35 +144,30 @@ func main() {
 	// Set the content of the window
 	w.SetContent(tabs)
 
+	// Keep the Connect Devices QR codes in line with the network addresses
+	go network.WatchAddresses(5*time.Second, nil, func([]network.Address) {
+		refreshConnectPanel()
+	})
+
 	// Start the server in a goroutine
 	go func() {
 		// Print startup information immediately
 		fmt.Println("\n=== MEDS System Information ===")
-		fmt.Println("Default credentials for PocketBase - username: user@example.com, password: password123")
-		fmt.Println("Default credentials for MEDS Provider users - provider@example.com through provider6@example.com, password: password123")
-		fmt.Println("Default credentials for MEDS Pharmacy users - pharmacyuser@example.com through pharmacyuser4@example.com, password: password123")
-		fmt.Println("Default credentials for MEDS Admin users - admin@example.com, password: password123")
-		fmt.Println("Admin UI: http://127.0.0.1:8090/_/")
-		fmt.Println("Main application: http://127.0.0.1:8090")
-
-		// Log all available network interfaces
-		interfaces, err := net.Interfaces()
+		if demoMode {
+			fmt.Println("Demo accounts are enabled (--demo), never use this mode with real patient data")
+		}
+		config := loadServerConfig()
+		fmt.Printf("Admin UI: %s/_/\n", config.LocalURL())
+		fmt.Printf("Main application: %s\n", config.LocalURL())
+
+		// Log all available network addresses
+		addresses, err := config.DeviceAddresses()
 		if err != nil {
 			fmt.Printf("Warning: Could not get network interfaces: %v\n", err)
 		} else {
 			fmt.Println("\nAvailable network addresses:")
-			for _, iface := range interfaces {
-				addrs, err := iface.Addrs()
-				if err != nil {
-					continue
-				}
-				for _, addr := range addrs {
-					if ipnet, ok := addr.(*net.IPNet); ok {
-						if ipnet.IP.To4() != nil && !ipnet.IP.IsLoopback() {
-							fmt.Printf("- http://%s:8090/ (%s)\n", ipnet.IP.String(), iface.Name)
-						}
-					}
-				}
+			for _, address := range addresses {
+				fmt.Printf("- %s/ (%s)\n", config.URL(address.IP.String()), address.Interface)
 			}
 		}
 
@@ -120,6 +178,13 @@ func main() {
 
 	// Show the window and run the app
 	w.ShowAndRun()
+
+	// Shut the server down cleanly (taking the shutdown backup) once the window is closed
+	select {
+	case <-stopServer():
+	case <-time.After(60 * time.Second):
+		fmt.Println("Timed out while stopping the server")
+	}
 }
 
 func createDashboardTab() fyne.CanvasObject {
@@ -137,11 +202,41 @@ func createDashboardTab() fyne.CanvasObject {
 	patientsValue := widget.NewLabelWithData(patientCount)
 	encountersLabel := widget.NewLabel("Total Encounters:")
 	encountersValue := widget.NewLabelWithData(encounterCount)
+	checkInsLabel := widget.NewLabel("Check-ins Today:")
+	checkInsValue := widget.NewLabelWithData(checkInsToday)
+	disbursementsLabel := widget.NewLabel("Disbursements Today:")
+	disbursementsValue := widget.NewLabelWithData(disbursementsToday)
+	lowStockLabel := widget.NewLabel("Low Stock Items:")
+	lowStockValue := widget.NewLabelWithData(lowStockCount)
+	clinicTimezoneLabel := widget.NewLabel("Clinic Timezone:")
+	clinicTimezoneValue := widget.NewLabelWithData(clinicTimezoneText)
+
+	// Queue section
+	queueGrid := container.NewGridWithColumns(2)
+	for _, status := range stats.QueueStatuses {
+		queueGrid.Add(widget.NewLabel(queueStatusLabels[status] + ":"))
+		queueGrid.Add(widget.NewLabelWithData(queueCounts[status]))
+	}
+
+	// Automatic backups section
+	scheduleLabel := widget.NewLabel("Automatic Backups:")
+	scheduleValue := widget.NewLabelWithData(backupScheduleText)
+	lastBackupLabel := widget.NewLabel("Last Backup:")
+	lastBackupValue := widget.NewLabelWithData(lastBackupText)
+	lastBackupValue.Wrapping = fyne.TextWrapWord
+	nextBackupLabel := widget.NewLabel("Next Backup:")
+	nextBackupValue := widget.NewLabelWithData(nextBackupText)
+
+	// Connect Devices section
+	connectCodes = container.NewHBox()
 
 	// Backup section
 	backupButton := widget.NewButton("Backup Database", func() {
 		backupDatabase()
 	})
+	restoreButton := widget.NewButton("Restore Backup", func() {
+		restoreDatabase()
+	})
 
 	// Layout
 	statusBox := container.NewHBox(
@@ -157,22 +252,89 @@ func createDashboardTab() fyne.CanvasObject {
 		container.NewGridWithColumns(2,
 			patientsLabel, patientsValue,
 			encountersLabel, encountersValue,
+			checkInsLabel, checkInsValue,
+			disbursementsLabel, disbursementsValue,
+			lowStockLabel, lowStockValue,
+			clinicTimezoneLabel, clinicTimezoneValue,
+		),
+	)
+
+	queueBox := container.NewVBox(
+		widget.NewLabel("Queue"),
+		queueGrid,
+	)
+
+	backupsBox := container.NewVBox(
+		widget.NewLabel("Backups"),
+		container.NewGridWithColumns(2,
+			scheduleLabel, scheduleValue,
+			lastBackupLabel, lastBackupValue,
+			nextBackupLabel, nextBackupValue,
 		),
 	)
 
 	actionsBox := container.NewVBox(
 		widget.NewLabel("Actions"),
 		backupButton,
+		restoreButton,
 	)
 
-	return container.NewVBox(
+	connectBox := container.NewVBox(
+		widget.NewLabel("Connect Devices"),
+		container.NewHScroll(connectCodes),
+	)
+
+	return container.NewVScroll(container.NewVBox(
 		statusBox,
 		widget.NewSeparator(),
-		statsBox,
+		container.NewGridWithColumns(2, statsBox, queueBox),
+		widget.NewSeparator(),
+		connectBox,
+		widget.NewSeparator(),
+		backupsBox,
 		widget.NewSeparator(),
 		actionsBox,
 		layout.NewSpacer(),
-	)
+	))
+}
+
+// refreshConnectPanel shows a QR code of the application URL for each
+// address the devices can reach the server at.
+func refreshConnectPanel() {
+	if connectCodes == nil {
+		return
+	}
+
+	config := currentServerConfig()
+
+	var codes []fyne.CanvasObject
+	if config.Hostname != "" && config.AllInterfaces() {
+		url := config.URL(network.LocalHostname(config.Hostname)) + "/"
+		codes = append(codes, container.NewVBox(
+			qrCode(url, 160),
+			widget.NewLabelWithStyle(url, fyne.TextAlignCenter, fyne.TextStyle{}),
+			widget.NewLabelWithStyle("Server name (mDNS)", fyne.TextAlignCenter, fyne.TextStyle{Italic: true}),
+		))
+	}
+
+	addresses, err := config.DeviceAddresses()
+	if err != nil {
+		codes = append(codes, widget.NewLabel(fmt.Sprintf("Could not get network interfaces: %v", err)))
+	}
+	for _, address := range addresses {
+		url := config.URL(address.IP.String()) + "/"
+		codes = append(codes, container.NewVBox(
+			qrCode(url, 160),
+			widget.NewLabelWithStyle(url, fyne.TextAlignCenter, fyne.TextStyle{}),
+			widget.NewLabelWithStyle(address.Interface, fyne.TextAlignCenter, fyne.TextStyle{Italic: true}),
+		))
+	}
+	if err == nil && len(addresses) == 0 {
+		codes = []fyne.CanvasObject{widget.NewLabel("No network connection, join the clinic Wi-Fi or hotspot to connect devices.")}
+	}
+
+	connectCodes.Objects = codes
+	connectCodes.Refresh()
 }
 
 func createLogsTab() fyne.CanvasObject {
@@ -189,19 +351,19 @@ func createLogsTab() fyne.CanvasObject {
 }
 
 func createSettingsTab() fyne.CanvasObject {
-	// User management section (stretch goal)
-	userManagementButton := widget.NewButton("Manage Users (Coming Soon)", func() {
-		dialog.ShowInformation("Coming Soon", "User management functionality will be available in a future update.", nil)
+	// User management section
+	userManagementButton := widget.NewButton("Manage Users", func() {
+		showUserManager()
 	})
 
 	// Open admin UI
 	adminUIButton := widget.NewButton("Open Admin UI", func() {
-		openBrowser("http://localhost:8090/_/")
+		openBrowser(currentServerConfig().LocalURL() + "/_/")
 	})
 
 	// Open main app
 	mainAppButton := widget.NewButton("Open Main Application", func() {
-		openBrowser("http://localhost:8090")
+		openBrowser(currentServerConfig().LocalURL())
 	})
 
 	return container.NewVBox(
@@ -210,223 +372,1251 @@ func createSettingsTab() fyne.CanvasObject {
 		adminUIButton,
 		mainAppButton,
 		widget.NewSeparator(),
+		widget.NewLabel("Server"),
+		createServerConfigForm(),
+		widget.NewSeparator(),
 		widget.NewLabel("User Management"),
 		userManagementButton,
+		widget.NewSeparator(),
+		widget.NewLabel("Automatic Backups"),
+		createBackupScheduleForm(),
 		layout.NewSpacer(),
 	)
 }
 
-func startServer() {
+func createServerConfigForm() fyne.CanvasObject {
+	config := loadServerConfig()
+
+	hostEntry := widget.NewEntry()
+	hostEntry.SetPlaceHolder("0.0.0.0 (all interfaces)")
+	hostEntry.SetText(config.Host)
+	portEntry := widget.NewEntry()
+	portEntry.SetText(strconv.Itoa(config.Port))
+	tlsCheck := widget.NewCheck("Serve HTTPS with a certificate from the local CA", nil)
+	tlsCheck.SetChecked(config.TLS)
+	hostnameEntry := widget.NewEntry()
+	hostnameEntry.SetPlaceHolder("empty to not advertise the server")
+	hostnameEntry.SetText(config.Hostname)
+
+	saveButton := widget.NewButton("Save Server Settings", func() {
+		port, err := strconv.Atoi(strings.TrimSpace(portEntry.Text))
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("The port must be a number"), mainWindow)
+			return
+		}
+
+		updated := network.Config{
+			Host:     strings.TrimSpace(hostEntry.Text),
+			Port:     port,
+			TLS:      tlsCheck.Checked,
+			Hostname: strings.ToLower(strings.TrimSpace(hostnameEntry.Text)),
+		}
+		if err := updated.Validate(); err != nil {
+			dialog.ShowError(err, mainWindow)
+			return
+		}
+
+		if err := saveServerConfig(updated); err != nil {
+			dialog.ShowError(err, mainWindow)
+			return
+		}
+
+		// a new name is advertised right away, the rest needs a restart
+		renamed := renameServer(updated.Hostname)
+
+		refreshConnectPanel()
+
+		message := "The server settings were saved."
+		if runningApp() != nil && (!renamed || updated != currentServerConfig()) {
+			message += " Restart the server to apply them."
+		}
+		dialog.ShowInformation("Saved", message, mainWindow)
+	})
+
+	caButton := widget.NewButton("Install Certificate on Devices", func() {
+		showCACertificate()
+	})
+
+	return container.NewVBox(
+		widget.NewForm(
+			widget.NewFormItem("Address", hostEntry),
+			widget.NewFormItem("Port", portEntry),
+			widget.NewFormItem("", tlsCheck),
+			widget.NewFormItem("Server name", container.NewBorder(nil, nil, nil, widget.NewLabel(".local"), hostnameEntry)),
+		),
+		saveButton,
+		caButton,
+	)
+}
+
+// showCACertificate shows QR codes to download the local CA certificate on
+// the devices, and lets the certificate be saved to a file (eg. to copy it
+// with a USB stick).
+func showCACertificate() {
+	config := currentServerConfig()
+	app := runningApp()
+	if app == nil || !config.TLS {
+		dialog.ShowInformation("Error", "Enable HTTPS and start the server to install the certificate on devices.", mainWindow)
+		return
+	}
+
+	ca, err := network.LoadOrCreateCA(filepath.Join(app.DataDir(), "tls"))
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("Failed to load the CA certificate: %v", err), mainWindow)
+		return
+	}
+
+	addresses, err := config.DeviceAddresses()
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("Could not get network interfaces: %v", err), mainWindow)
+		return
+	}
+
+	codes := container.NewGridWrap(fyne.NewSize(240, 280))
+	for _, address := range addresses {
+		url := config.CACertificateURL(address.IP.String())
+		codes.Add(container.NewVBox(
+			qrCode(url, 240),
+			widget.NewLabelWithStyle(address.Interface, fyne.TextAlignCenter, fyne.TextStyle{}),
+		))
+		log.Printf("CA certificate available at %s", url)
+	}
+	if len(addresses) == 0 {
+		codes.Add(widget.NewLabel("No network address found"))
+	}
+
+	saveButton := widget.NewButton("Save Certificate...", func() {
+		saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, mainWindow)
+				return
+			}
+			if writer == nil {
+				// User cancelled
+				return
+			}
+			defer writer.Close()
+
+			if _, err := writer.Write(ca.PEM); err != nil {
+				dialog.ShowError(fmt.Errorf("Failed to save the certificate: %v", err), mainWindow)
+			}
+		}, mainWindow)
+		saveDialog.SetFileName("meds-ca.crt")
+		saveDialog.Show()
+	})
+
+	help := widget.NewLabel(fmt.Sprintf(
+		"Scan a code from a device on the same network, accept the browser warning once and install the downloaded certificate as a trusted CA (%s).",
+		ca.Certificate.Subject.CommonName,
+	))
+	help.Wrapping = fyne.TextWrapWord
+
+	content := container.NewBorder(help, saveButton, nil, nil, container.NewVScroll(codes))
+	d := dialog.NewCustom("Install Certificate on Devices", "Close", content, mainWindow)
+	d.Resize(fyne.NewSize(560, 480))
+	d.Show()
+}
+
+// qrCode renders text as a QR code image of size x size.
+func qrCode(text string, size float32) fyne.CanvasObject {
+	png, err := qrcode.Encode(text, qrcode.Medium, 256)
+	if err != nil {
+		return widget.NewLabel(text)
+	}
+
+	image := canvas.NewImageFromResource(fyne.NewStaticResource("qr.png", png))
+	image.FillMode = canvas.ImageFillContain
+	image.SetMinSize(fyne.NewSize(size, size))
+
+	return image
+}
+
+// loadServerConfig returns the persisted server listen config.
+func loadServerConfig() network.Config {
+	config := network.DefaultConfig()
+
+	raw := fyne.CurrentApp().Preferences().String(serverConfigPreference)
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &config); err != nil || config.Validate() != nil {
+			log.Printf("Warning: Invalid server settings, using the defaults")
+			return network.DefaultConfig()
+		}
+	}
+
+	return config
+}
+
+// saveServerConfig persists config. It's used from the next server start.
+func saveServerConfig(config network.Config) error {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	fyne.CurrentApp().Preferences().SetString(serverConfigPreference, string(raw))
+
+	return nil
+}
+
+// renameServer advertises the running server as name. It reports whether
+// the server was renamed, which requires a running advertisement.
+func renameServer(name string) bool {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+
+	if mdnsAdvertiser == nil || name == "" || serverStopping {
+		return false
+	}
+
+	if err := mdnsAdvertiser.SetHostname(name); err != nil {
+		log.Printf("Warning: Failed to rename the server: %v", err)
+		return false
+	}
+	serverConfig.Hostname = name
+	log.Printf("Advertising the server as %s", network.LocalHostname(name))
+
+	return true
+}
+
+// currentServerConfig returns the listen config of the running server, or
+// the persisted one when the server is stopped.
+func currentServerConfig() network.Config {
 	serverMutex.Lock()
 	defer serverMutex.Unlock()
 
 	if serverRunning {
+		return serverConfig
+	}
+
+	return loadServerConfig()
+}
+
+// runningApp returns the app of the running server, or nil when the server
+// is stopped or stopping.
+func runningApp() *pocketbase.PocketBase {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+
+	if !serverRunning || serverStopping {
+		return nil
+	}
+
+	return pbApp
+}
+
+// showUserManager opens the user management window. The users are managed
+// through the DAO of the running server.
+func showUserManager() {
+	if runningApp() == nil {
+		dialog.ShowInformation("Error", "Server must be running to manage users.", mainWindow)
 		return
 	}
 
-	serverRunning = true
-	serverStatus.Set("Starting...")
+	w := fyne.CurrentApp().NewWindow("User Management")
+	w.Resize(fyne.NewSize(750, 500))
+
+	var users []*models.Record
+	selected := -1
+
+	userList := widget.NewList(
+		func() int {
+			return len(users)
+		},
+		func() fyne.CanvasObject {
+			return container.NewGridWithColumns(4,
+				widget.NewLabel("Name"),
+				widget.NewLabel("Email"),
+				widget.NewLabel("Role"),
+				widget.NewLabel("Status"),
+			)
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			user := users[id]
+			status := "Active"
+			if user.GetBool("deactivated") {
+				status = "Deactivated"
+			}
 
-	go func() {
-		pbApp = pocketbase.New()
+			labels := item.(*fyne.Container).Objects
+			labels[0].(*widget.Label).SetText(accounts.DisplayName(user))
+			labels[1].(*widget.Label).SetText(user.Email())
+			labels[2].(*widget.Label).SetText(user.GetString("role"))
+			labels[3].(*widget.Label).SetText(status)
+		},
+	)
 
-		// Register the migration command
-		migratecmd.MustRegister(pbApp, pbApp.RootCmd, migratecmd.Config{
-			// Enable auto-migration file creation during development
-			Automigrate: false,
+	deactivateButton := widget.NewButton("Deactivate", nil)
+	resetButton := widget.NewButton("Reset Password", nil)
+	deactivateButton.Disable()
+	resetButton.Disable()
+
+	userList.OnSelected = func(id widget.ListItemID) {
+		selected = id
+		if users[id].GetBool("deactivated") {
+			deactivateButton.SetText("Reactivate")
+		} else {
+			deactivateButton.SetText("Deactivate")
+		}
+		deactivateButton.Enable()
+		resetButton.Enable()
+	}
+	userList.OnUnselected = func(id widget.ListItemID) {
+		selected = -1
+		deactivateButton.Disable()
+		resetButton.Disable()
+	}
+
+	refresh := func() {
+		app := runningApp()
+		if app == nil {
+			dialog.ShowInformation("Error", "The server is no longer running.", w)
+			return
+		}
+
+		list, err := accounts.List(app.Dao())
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Failed to load the users: %v", err), w)
+			return
+		}
+
+		users = list
+		userList.UnselectAll()
+		userList.Refresh()
+	}
+
+	// withApp runs action with the running server, or reports that it stopped
+	withApp := func(action func(app *pocketbase.PocketBase)) {
+		app := runningApp()
+		if app == nil {
+			dialog.ShowInformation("Error", "The server is no longer running.", w)
+			return
+		}
+		action(app)
+	}
+
+	addButton := widget.NewButtonWithIcon("Add User", theme.ContentAddIcon(), func() {
+		withApp(func(app *pocketbase.PocketBase) {
+			showAddUserForm(w, app, refresh)
 		})
+	})
+
+	resetButton.OnTapped = func() {
+		if selected < 0 {
+			return
+		}
+		user := users[selected]
+		withApp(func(app *pocketbase.PocketBase) {
+			showResetPasswordForm(w, app, user, refresh)
+		})
+	}
+
+	deactivateButton.OnTapped = func() {
+		if selected < 0 {
+			return
+		}
+		user := users[selected]
+		deactivate := !user.GetBool("deactivated")
+
+		message := fmt.Sprintf("Reactivate %s?", accounts.DisplayName(user))
+		if deactivate {
+			message = fmt.Sprintf("Deactivate %s?\n\nThe user is signed out and can no longer sign in.", accounts.DisplayName(user))
+		}
+
+		dialog.ShowConfirm("User Status", message, func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			withApp(func(app *pocketbase.PocketBase) {
+				if err := accounts.SetDeactivated(app.Dao(), user.Id, deactivate); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				log.Printf("User %s was updated (deactivated: %v)", user.Email(), deactivate)
+				refresh()
+			})
+		}, w)
+	}
+
+	importButton := widget.NewButtonWithIcon("Import CSV", theme.UploadIcon(), func() {
+		withApp(func(app *pocketbase.PocketBase) {
+			importUsers(w, app, refresh)
+		})
+	})
+
+	refreshButton := widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), refresh)
+
+	header := container.NewGridWithColumns(4,
+		widget.NewLabelWithStyle("Name", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		widget.NewLabelWithStyle("Email", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		widget.NewLabelWithStyle("Role", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		widget.NewLabelWithStyle("Status", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+	)
+
+	actions := container.NewHBox(
+		addButton,
+		resetButton,
+		deactivateButton,
+		importButton,
+		layout.NewSpacer(),
+		refreshButton,
+	)
+
+	w.SetContent(container.NewBorder(header, actions, nil, nil, userList))
+	refresh()
+	w.Show()
+}
+
+// showAddUserForm asks for a new user and creates it.
+func showAddUserForm(parent fyne.Window, app *pocketbase.PocketBase, onCreated func()) {
+	name := widget.NewEntry()
+	email := widget.NewEntry()
+	email.Validator = func(s string) error {
+		if strings.TrimSpace(s) == "" {
+			return errors.New("required")
+		}
+		return nil
+	}
+	role := widget.NewSelect(accounts.Roles, nil)
+	role.SetSelected(accounts.RoleProvider)
+	password := widget.NewPasswordEntry()
+	password.Validator = passwordValidator
+	confirm := widget.NewPasswordEntry()
+	confirm.Validator = func(s string) error {
+		if s != password.Text {
+			return errors.New("the passwords don't match")
+		}
+		return nil
+	}
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Name", name),
+		widget.NewFormItem("Email", email),
+		widget.NewFormItem("Role", role),
+		widget.NewFormItem("Password", password),
+		widget.NewFormItem("Repeat password", confirm),
+	}
+
+	form := dialog.NewForm("Add User", "Create", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+
+		user, err := accounts.NewUser(app.Dao(), name.Text, email.Text, role.Selected, password.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Failed to create the user: %v", err), parent)
+			return
+		}
+
+		log.Printf("Created the %s user %s", user.GetString("role"), user.Email())
+		onCreated()
+	}, parent)
+	form.Resize(fyne.NewSize(450, 0))
+	form.Show()
+}
+
+// showResetPasswordForm asks for a new password of user and sets it.
+func showResetPasswordForm(parent fyne.Window, app *pocketbase.PocketBase, user *models.Record, onReset func()) {
+	password := widget.NewPasswordEntry()
+	password.Validator = passwordValidator
+	confirm := widget.NewPasswordEntry()
+	confirm.Validator = func(s string) error {
+		if s != password.Text {
+			return errors.New("the passwords don't match")
+		}
+		return nil
+	}
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("User", widget.NewLabel(fmt.Sprintf("%s (%s)", accounts.DisplayName(user), user.Email()))),
+		widget.NewFormItem("New password", password),
+		widget.NewFormItem("Repeat password", confirm),
+	}
+
+	form := dialog.NewForm("Reset Password", "Reset", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+
+		if err := accounts.ResetPassword(app.Dao(), user.Id, password.Text); err != nil {
+			dialog.ShowError(fmt.Errorf("Failed to reset the password: %v", err), parent)
+			return
+		}
+
+		log.Printf("Reset the password of %s", user.Email())
+		dialog.ShowInformation("Password Reset", "The password was reset and the user was signed out.", parent)
+		onReset()
+	}, parent)
+	form.Resize(fyne.NewSize(450, 0))
+	form.Show()
+}
+
+// importUsers creates users from a CSV volunteer roster. When passwords were
+// generated it offers to save them so they can be handed out.
+func importUsers(parent fyne.Window, app *pocketbase.PocketBase, onImported func()) {
+	openDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		if reader == nil {
+			// User cancelled
+			return
+		}
+		defer reader.Close()
+
+		result, err := accounts.ImportCSV(app.Dao(), reader)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Import failed: %v", err), parent)
+			return
+		}
+
+		log.Printf("Imported %d users from %s (%d rows skipped)", len(result.Created), reader.URI().Path(), len(result.Skipped))
+		onImported()
+
+		summary := fmt.Sprintf("Created %d users.", len(result.Created))
+		if len(result.Skipped) > 0 {
+			summary += fmt.Sprintf("\n\nSkipped %d rows:", len(result.Skipped))
+			for _, issue := range result.Skipped {
+				summary += fmt.Sprintf("\n- line %d %s: %s", issue.Line, issue.Email, issue.Reason)
+			}
+		}
+
+		if !result.GeneratedPasswords() {
+			dialog.ShowInformation("Import Complete", summary, parent)
+			return
+		}
 
-		pbApp.OnBeforeServe().Add(func(e *core.ServeEvent) error {
-			// Get the executable's directory
-			exePath, err := os.Executable()
+		summary += "\n\nPasswords were generated for some users. Save them now, they can't be shown again."
+		dialog.ShowCustomConfirm("Import Complete", "Save Passwords", "Close", widget.NewLabel(summary), func(save bool) {
+			if save {
+				saveImportCredentials(parent, result)
+			}
+		}, parent)
+	}, parent)
+
+	openDialog.SetFilter(storage.NewExtensionFileFilter([]string{".csv"}))
+	openDialog.Show()
+}
+
+// saveImportCredentials writes the generated passwords of an import to a
+// CSV file chosen by the user.
+func saveImportCredentials(parent fyne.Window, result *accounts.ImportResult) {
+	saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		if writer == nil {
+			// User cancelled
+			return
+		}
+		defer writer.Close()
+
+		if err := result.WriteCredentials(writer); err != nil {
+			dialog.ShowError(fmt.Errorf("Failed to save the passwords: %v", err), parent)
+			return
+		}
+
+		dialog.ShowInformation("Passwords Saved", fmt.Sprintf("The passwords were saved to: %s", writer.URI().Path()), parent)
+	}, parent)
+
+	saveDialog.SetFilter(storage.NewExtensionFileFilter([]string{".csv"}))
+	saveDialog.SetFileName(fmt.Sprintf("imported_users_%s.csv", time.Now().Format("2006-01-02_15-04-05")))
+	saveDialog.Show()
+}
+
+// passwordValidator checks the length of the passwords entered in the forms.
+func passwordValidator(s string) error {
+	if len(s) < accounts.MinPasswordLength {
+		return fmt.Errorf("use at least %d characters", accounts.MinPasswordLength)
+	}
+	return nil
+}
+
+// backupIntervals are the intervals offered in the backup settings.
+var backupIntervals = []struct {
+	label    string
+	interval time.Duration
+}{
+	{"Off", 0},
+	{"Every 15 minutes", 15 * time.Minute},
+	{"Every 30 minutes", 30 * time.Minute},
+	{"Every hour", time.Hour},
+	{"Every 2 hours", 2 * time.Hour},
+	{"Every 4 hours", 4 * time.Hour},
+	{"Every 12 hours", 12 * time.Hour},
+	{"Every day", 24 * time.Hour},
+}
+
+func createBackupScheduleForm() fyne.CanvasObject {
+	schedule := loadBackupSchedule()
+
+	dirEntry := widget.NewEntry()
+	dirEntry.SetPlaceHolder("pb_data/backups")
+	dirEntry.SetText(schedule.Dir)
+	browseButton := widget.NewButton("Browse...", func() {
+		dialog.ShowFolderOpen(func(dir fyne.ListableURI, err error) {
 			if err != nil {
-				log.Printf("Warning: Could not determine executable path: %v", err)
-				exePath = "."
+				dialog.ShowError(err, mainWindow)
+				return
+			}
+			if dir != nil {
+				dirEntry.SetText(dir.Path())
 			}
-			exeDir := filepath.Dir(exePath)
+		}, mainWindow)
+	})
 
-			// Determine the frontend build directory
-			frontendDir := filepath.Join(exeDir, "frontend", "build")
+	labels := make([]string, 0, len(backupIntervals))
+	selected := ""
+	for _, option := range backupIntervals {
+		labels = append(labels, option.label)
+		if option.interval == schedule.Interval {
+			selected = option.label
+		}
+	}
+	intervalSelect := widget.NewSelect(labels, nil)
+	if selected == "" {
+		// keep intervals that are not part of the presets
+		selected = "Every " + schedule.Interval.String()
+		intervalSelect.Options = append(intervalSelect.Options, selected)
+	}
+	intervalSelect.SetSelected(selected)
+
+	onShutdownCheck := widget.NewCheck("Also back up when the server stops", nil)
+	onShutdownCheck.SetChecked(schedule.OnShutdown)
+
+	keepDailyEntry := widget.NewEntry()
+	keepDailyEntry.SetText(strconv.Itoa(schedule.KeepDaily))
+	keepWeeklyEntry := widget.NewEntry()
+	keepWeeklyEntry.SetText(strconv.Itoa(schedule.KeepWeekly))
+
+	saveButton := widget.NewButton("Save Backup Settings", func() {
+		keepDaily, err := strconv.Atoi(strings.TrimSpace(keepDailyEntry.Text))
+		if err != nil || keepDaily < 0 {
+			dialog.ShowError(fmt.Errorf("Daily backups to keep must be a non-negative number"), mainWindow)
+			return
+		}
+		keepWeekly, err := strconv.Atoi(strings.TrimSpace(keepWeeklyEntry.Text))
+		if err != nil || keepWeekly < 0 {
+			dialog.ShowError(fmt.Errorf("Weekly backups to keep must be a non-negative number"), mainWindow)
+			return
+		}
+
+		updated := loadBackupSchedule()
+		updated.Dir = strings.TrimSpace(dirEntry.Text)
+		updated.OnShutdown = onShutdownCheck.Checked
+		updated.KeepDaily = keepDaily
+		updated.KeepWeekly = keepWeekly
+		for _, option := range backupIntervals {
+			if option.label == intervalSelect.Selected {
+				updated.Interval = option.interval
+			}
+		}
+
+		saveBackupSchedule(updated)
+		dialog.ShowInformation("Saved", "The automatic backup settings were saved.", mainWindow)
+	})
+
+	return container.NewVBox(
+		widget.NewForm(
+			widget.NewFormItem("Directory", container.NewBorder(nil, nil, nil, browseButton, dirEntry)),
+			widget.NewFormItem("Schedule", intervalSelect),
+			widget.NewFormItem("", onShutdownCheck),
+			widget.NewFormItem("Keep daily", keepDailyEntry),
+			widget.NewFormItem("Keep weekly", keepWeeklyEntry),
+		),
+		saveButton,
+	)
+}
+
+// loadBackupSchedule returns the persisted automatic backup schedule.
+func loadBackupSchedule() backup.Schedule {
+	schedule := backup.DefaultSchedule()
+
+	raw := fyne.CurrentApp().Preferences().String(backupSchedulePreference)
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &schedule); err != nil {
+			log.Printf("Warning: Invalid backup settings, using the defaults: %v", err)
+			return backup.DefaultSchedule()
+		}
+	}
+
+	return schedule
+}
+
+// saveBackupSchedule persists schedule and applies it to the running server.
+func saveBackupSchedule(schedule backup.Schedule) {
+	raw, err := json.Marshal(schedule)
+	if err != nil {
+		dialog.ShowError(err, mainWindow)
+		return
+	}
+	fyne.CurrentApp().Preferences().SetString(backupSchedulePreference, string(raw))
+
+	serverMutex.Lock()
+	scheduler := backupScheduler
+	serverMutex.Unlock()
+
+	if scheduler != nil {
+		scheduler.SetSchedule(schedule)
+	} else {
+		updateBackupStatus(backup.Status{Schedule: schedule})
+	}
+}
+
+// updateBackupStatus shows the automatic backup status on the Dashboard.
+func updateBackupStatus(status backup.Status) {
+	backupScheduleText.Set(status.Schedule.String())
+
+	switch {
+	case status.LastBackup.IsZero():
+		lastBackupText.Set("None yet")
+	case status.LastError != nil:
+		lastBackupText.Set(fmt.Sprintf("Failed at %s: %v", status.LastBackup.Format("2006-01-02 15:04"), status.LastError))
+	default:
+		lastBackupText.Set(fmt.Sprintf("%s (%s)", status.LastBackup.Format("2006-01-02 15:04"), status.LastFile))
+	}
+
+	if status.NextBackup.IsZero() {
+		nextBackupText.Set("-")
+	} else {
+		nextBackupText.Set(status.NextBackup.Format("2006-01-02 15:04"))
+	}
+}
+
+func startServer() {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+
+	if serverRunning {
+		return
+	}
+
+	serverRunning = true
+	serverStatus.Set("Starting...")
+
+	app := pocketbase.New()
+	done := make(chan struct{})
+	config := loadServerConfig()
+
+	pbApp = app
+	serverDone = done
+	serverConfig = config
+
+	go runServer(app, config, done)
+}
+
+// runServer bootstraps the app and serves it until stopServer shuts it down.
+// done is closed once the HTTP server has stopped and the DB is closed.
+func runServer(app *pocketbase.PocketBase, config network.Config, done chan struct{}) {
+	defer close(done)
+
+	// Register the migration command
+	migratecmd.MustRegister(app, app.RootCmd, migratecmd.Config{
+		// Enable auto-migration file creation during development
+		Automigrate: false,
+	})
+
+	// Validate and cache the clinic settings, the Dashboard shows their timezone
+	settings.Register(app).Subscribe(updateClinicSettings)
+
+	// Register server-side record hooks
+	hooks.Register(app)
+
+	// Track the record changes exchanged with the other sites
+	sitesync.Register(app)
+
+	// Export and import the signed transfer bundles
+	transfer.Register(app)
+
+	// Keep the Dashboard stats up to date from the record hooks
+	stats.Watch(app, updateDashboardStats)
+
+	// Take automatic backups while serving and at shutdown
+	scheduler := backup.Register(app, loadBackupSchedule())
+	scheduler.OnChange = updateBackupStatus
+
+	// Advertise the server on the LAN by name
+	var advertiser *network.Advertiser
+	if config.Hostname != "" && config.AllInterfaces() {
+		advertiser = network.Advertise(app, config.Hostname, config.Port, config.TLS)
+	}
+
+	serverMutex.Lock()
+	backupScheduler = scheduler
+	mdnsAdvertiser = advertiser
+	serverMutex.Unlock()
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		// Get the executable's directory
+		exePath, err := os.Executable()
+		if err != nil {
+			log.Printf("Warning: Could not determine executable path: %v", err)
+			exePath = "."
+		}
+		exeDir := filepath.Dir(exePath)
+
+		// Determine the frontend build directory
+		frontendDir := filepath.Join(exeDir, "frontend", "build")
+		if !fileExists(frontendDir) {
+			// Fallback to local directory during development
+			frontendDir = "frontend/build"
 			if !fileExists(frontendDir) {
-				// Fallback to local directory during development
-				frontendDir = "frontend/build"
-				if !fileExists(frontendDir) {
-					// Try other common locations
-					possibleLocations := []string{
-						"../frontend/build",
-						"./frontend/build",
-						"./build",
-						"../build",
-					}
+				// Try other common locations
+				possibleLocations := []string{
+					"../frontend/build",
+					"./frontend/build",
+					"./build",
+					"../build",
+				}
 
-					for _, loc := range possibleLocations {
-						if fileExists(loc) {
-							frontendDir = loc
-							break
-						}
+				for _, loc := range possibleLocations {
+					if fileExists(loc) {
+						frontendDir = loc
+						break
 					}
 				}
 			}
+		}
+
+		log.Printf("Serving frontend from: %s", frontendDir)
+
+		// Serve static files from the frontend build directory
+		e.Router.GET("/*", apis.StaticDirectoryHandler(os.DirFS(frontendDir), true))
+
+		serverStatus.Set("Running")
+
+		return nil
+	})
+
+	if err := app.Bootstrap(); err != nil {
+		serverFailed(app, err)
+		return
+	}
+
+	// Apply the migrations before serving so that the accounts can be checked
+	if err := accounts.Migrate(app); err != nil {
+		serverFailed(app, err)
+		return
+	}
+
+	if err := prepareAccounts(app); err != nil {
+		serverFailed(app, err)
+		return
+	}
 
-			log.Printf("Serving frontend from: %s", frontendDir)
+	serveConfig := apis.ServeConfig{
+		HttpAddr:        config.Addr(),
+		ShowStartBanner: true,
+	}
 
-			// Serve static files from the frontend build directory
-			e.Router.GET("/*", apis.StaticDirectoryHandler(os.DirFS(frontendDir), true))
+	if config.TLS {
+		dir := filepath.Join(app.DataDir(), "tls")
 
-			serverStatus.Set("Running")
+		cert, err := network.LoadOrCreateCertificate(dir, config.CertificateHosts())
+		if err != nil {
+			serverFailed(app, fmt.Errorf("failed to prepare the TLS certificate: %w", err))
+			return
+		}
 
-			// Start a goroutine to update stats periodically
-			go updateStats()
+		// Let the devices download the local CA to trust the certificate
+		if err := network.RegisterCARoute(app, dir); err != nil {
+			serverFailed(app, fmt.Errorf("failed to load the CA certificate: %w", err))
+			return
+		}
 
+		// Serve the local certificate instead of the Let's Encrypt one
+		app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+			e.Server.TLSConfig = &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{cert},
+			}
 			return nil
 		})
 
-		// Set up the server
-		pbApp.RootCmd.SetArgs([]string{"serve", "--http=0.0.0.0:8090"})
+		serveConfig = apis.ServeConfig{
+			HttpsAddr:       config.Addr(),
+			ShowStartBanner: true,
+		}
+	}
 
-		// Start the server
-		if err := pbApp.Start(); err != nil {
-			log.Printf("Server error: %v", err)
-			serverStatus.Set("Error")
-			serverRunning = false
+	// Serve blocks until the server is shut down by the terminate hook
+	_, err := apis.Serve(app, serveConfig)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		serverFailed(app, err)
+	}
+}
+
+// prepareAccounts seeds the demo accounts when requested and runs the
+// first-run setup wizard until the superuser and the first clinic admin
+// exist. It fails when the wizard is cancelled or the server is stopped.
+func prepareAccounts(app *pocketbase.PocketBase) error {
+	if demoMode {
+		if err := accounts.SeedDemo(app.Dao()); err != nil {
+			return err
 		}
-	}()
+	} else if inUse := accounts.DemoAccountsInUse(app.Dao()); len(inUse) > 0 {
+		log.Printf("Warning: These demo accounts still use the demo password: %s", strings.Join(inUse, ", "))
+	}
+
+	pending, err := accounts.Check(app.Dao())
+	if err != nil || !pending.Any() {
+		return err
+	}
+
+	serverStatus.Set("Waiting for setup...")
+
+	stopped := make(chan struct{})
+	var stopOnce sync.Once
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		stopOnce.Do(func() { close(stopped) })
+		return nil
+	})
+
+	setup := accounts.Setup{}
+	for {
+		submitted := make(chan bool, 1)
+		form := showSetupWizard(pending, &setup, submitted)
+
+		select {
+		case <-stopped:
+			form.Hide()
+			return errors.New("the server was stopped during the first-run setup")
+		case ok := <-submitted:
+			if !ok {
+				return errors.New("the first-run setup was cancelled")
+			}
+		}
+
+		err := setup.Run(app.Dao())
+		if err == nil {
+			log.Println("First-run setup completed")
+			serverStatus.Set("Starting...")
+			return nil
+		}
+
+		dialog.ShowError(fmt.Errorf("Setup failed: %v", err), mainWindow)
+	}
 }
 
-func stopServer() {
+// showSetupWizard asks for the pending first-run accounts and stores them in
+// setup. It sends whether the form was submitted to submitted.
+func showSetupWizard(pending accounts.Pending, setup *accounts.Setup, submitted chan<- bool) dialog.Dialog {
+	required := func(s string) error {
+		if strings.TrimSpace(s) == "" {
+			return errors.New("required")
+		}
+		return nil
+	}
+
+	var items []*widget.FormItem
+
+	superuserEmail := widget.NewEntry()
+	superuserEmail.SetText(setup.SuperuserEmail)
+	superuserEmail.Validator = required
+	superuserPassword := widget.NewPasswordEntry()
+	superuserPassword.Validator = passwordValidator
+	superuserConfirm := widget.NewPasswordEntry()
+	superuserConfirm.Validator = func(s string) error {
+		if s != superuserPassword.Text {
+			return errors.New("the passwords don't match")
+		}
+		return nil
+	}
+	if pending.Superuser {
+		items = append(items,
+			widget.NewFormItem("", widget.NewLabel("Superuser (PocketBase Admin UI)")),
+			widget.NewFormItem("Email", superuserEmail),
+			widget.NewFormItem("Password", superuserPassword),
+			widget.NewFormItem("Repeat password", superuserConfirm),
+		)
+	}
+
+	adminName := widget.NewEntry()
+	adminName.SetText(setup.AdminName)
+	adminName.Validator = required
+	adminEmail := widget.NewEntry()
+	adminEmail.SetText(setup.AdminEmail)
+	adminEmail.Validator = required
+	adminPassword := widget.NewPasswordEntry()
+	adminPassword.Validator = passwordValidator
+	adminConfirm := widget.NewPasswordEntry()
+	adminConfirm.Validator = func(s string) error {
+		if s != adminPassword.Text {
+			return errors.New("the passwords don't match")
+		}
+		return nil
+	}
+	if pending.Admin {
+		items = append(items,
+			widget.NewFormItem("", widget.NewLabel("Clinic admin (MEDS application)")),
+			widget.NewFormItem("Name", adminName),
+			widget.NewFormItem("Email", adminEmail),
+			widget.NewFormItem("Password", adminPassword),
+			widget.NewFormItem("Repeat password", adminConfirm),
+		)
+	}
+
+	form := dialog.NewForm("First-Run Setup", "Create Accounts", "Cancel", items, func(ok bool) {
+		setup.SuperuserEmail = strings.TrimSpace(superuserEmail.Text)
+		setup.SuperuserPassword = superuserPassword.Text
+		setup.AdminName = strings.TrimSpace(adminName.Text)
+		setup.AdminEmail = strings.TrimSpace(adminEmail.Text)
+		setup.AdminPassword = adminPassword.Text
+		submitted <- ok
+	}, mainWindow)
+	form.Resize(fyne.NewSize(500, 0))
+	form.Show()
+
+	return form
+}
+
+// serverFailed releases the resources of an app that stopped on its own
+// (eg. because the port is already in use) so that it can be started again.
+func serverFailed(app *pocketbase.PocketBase, err error) {
+	serverMutex.Lock()
+	if pbApp != app || serverStopping {
+		// stopped by stopServer, which is already taking care of it
+		serverMutex.Unlock()
+		return
+	}
+	serverStopping = true
+	serverMutex.Unlock()
+
+	log.Printf("Server error: %v", err)
+	serverStatus.Set("Error")
+
+	terminateApp(app)
+
+	serverMutex.Lock()
+	pbApp = nil
+	backupScheduler = nil
+	mdnsAdvertiser = nil
+	serverRunning = false
+	serverStopping = false
+	serverMutex.Unlock()
+}
+
+// terminateApp gracefully shuts down the HTTP server of app (draining the
+// in-flight requests) and closes its database connections.
+func terminateApp(app *pocketbase.PocketBase) {
+	err := app.OnTerminate().Trigger(&core.TerminateEvent{
+		App: app,
+	}, func(e *core.TerminateEvent) error {
+		return e.App.ResetBootstrapState()
+	})
+	if err != nil {
+		log.Printf("Error while stopping the server: %v", err)
+	}
+}
+
+// stopServer shuts the running server down in the background.
+// The returned channel is closed once the server has fully stopped.
+func stopServer() <-chan struct{} {
 	serverMutex.Lock()
 	defer serverMutex.Unlock()
 
+	stopped := make(chan struct{})
+
 	if !serverRunning || pbApp == nil {
-		return
+		close(stopped)
+		return stopped
+	}
+
+	if serverStopping {
+		// a shutdown is already in progress
+		done := serverDone
+		go func() {
+			<-done
+			close(stopped)
+		}()
+		return stopped
 	}
 
+	serverStopping = true
 	serverStatus.Set("Stopping...")
 
-	// Stop the server
-	serverRunning = false
-	serverStatus.Set("Stopped")
+	app := pbApp
+	done := serverDone
 
-	// The PocketBase library doesn't have a Stop() method, so we'll just set the flag
-	// and let the server stop gracefully when the application exits
+	go func() {
+		defer close(stopped)
+
+		terminateApp(app)
+		<-done
+
+		serverMutex.Lock()
+		pbApp = nil
+		backupScheduler = nil
+		mdnsAdvertiser = nil
+		serverRunning = false
+		serverStopping = false
+		serverMutex.Unlock()
+
+		serverStatus.Set("Stopped")
+		log.Println("Server stopped")
+		refreshConnectPanel()
+	}()
+
+	return stopped
 }
 
-func updateStats() {
-	for serverRunning {
-		if pbApp != nil && pbApp.Dao() != nil {
-			// Get patient count
-			patients, err := pbApp.Dao().FindRecordsByExpr("patients")
-			if err == nil {
-				patientCount.Set(fmt.Sprintf("%d", len(patients)))
-			}
+// updateDashboardStats shows a new stats snapshot on the Dashboard.
+func updateDashboardStats(snapshot stats.Snapshot) {
+	patientCount.Set(fmt.Sprintf("%d", snapshot.Patients))
+	encounterCount.Set(fmt.Sprintf("%d", snapshot.Encounters))
+	checkInsToday.Set(fmt.Sprintf("%d", snapshot.CheckInsToday))
+	disbursementsToday.Set(fmt.Sprintf("%d", snapshot.DisbursementsToday))
+	lowStockCount.Set(fmt.Sprintf("%d", snapshot.LowStock))
 
-			// Get encounter count
-			encounters, err := pbApp.Dao().FindRecordsByExpr("encounters")
-			if err == nil {
-				encounterCount.Set(fmt.Sprintf("%d", len(encounters)))
-			}
-		}
+	for _, status := range stats.QueueStatuses {
+		queueCounts[status].Set(fmt.Sprintf("%d", snapshot.QueueByStatus[status]))
+	}
+}
 
-		// Update every 5 seconds
-		time.Sleep(5 * time.Second)
+// updateClinicSettings shows the clinic settings on the Dashboard.
+func updateClinicSettings(current settings.Settings) {
+	if current.ClinicTimezone == "" {
+		clinicTimezoneText.Set(fmt.Sprintf("Server time (%s)", time.Local.String()))
+		return
 	}
+
+	clinicTimezoneText.Set(current.Location().String())
 }
 
 func backupDatabase() {
-	if !serverRunning {
-		dialog.ShowInformation("Error", "Server must be running to backup the database.", nil)
+	serverMutex.Lock()
+	app := pbApp
+	running := serverRunning && !serverStopping
+	serverMutex.Unlock()
+
+	if !running || app == nil {
+		dialog.ShowInformation("Error", "Server must be running to backup the database.", mainWindow)
 		return
 	}
 
-	// Use a file save dialog instead of a progress dialog
 	saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
 		if err != nil {
-			dialog.ShowError(err, nil)
+			dialog.ShowError(err, mainWindow)
 			return
 		}
 		if writer == nil {
 			// User cancelled
 			return
 		}
-		defer writer.Close()
-
-		// Get the selected path
-		backupPath := writer.URI().Path()
-
-		// Create a directory for the backup
-		backupDir := filepath.Dir(backupPath)
-		timestamp := time.Now().Format("2006-01-02_15-04-05")
-		backupDirWithTimestamp := filepath.Join(backupDir, fmt.Sprintf("pb_data_backup_%s", timestamp))
 
 		// Perform backup in a goroutine
 		go func() {
-			err := copyDir("pb_data", backupDirWithTimestamp)
+			defer writer.Close()
+
+			manifest, err := backup.Write(app, writer)
 			if err != nil {
-				dialog.ShowError(fmt.Errorf("Backup failed: %v", err), nil)
-			} else {
-				dialog.ShowInformation("Backup Complete", fmt.Sprintf("Database backed up to: %s", backupDirWithTimestamp), nil)
+				dialog.ShowError(fmt.Errorf("Backup failed: %v", err), mainWindow)
+				return
 			}
+
+			dialog.ShowInformation("Backup Complete", fmt.Sprintf(
+				"Database backed up to: %s\n\n%s",
+				writer.URI().Path(),
+				describeBackup(manifest),
+			), mainWindow)
 		}()
-	}, nil)
+	}, mainWindow)
 
 	// Set filter for zip files
 	saveDialog.SetFilter(storage.NewExtensionFileFilter([]string{".zip"}))
-	saveDialog.SetFileName("medical_records_backup.zip")
+	saveDialog.SetFileName(fmt.Sprintf("medical_records_backup_%s.zip", time.Now().Format("2006-01-02_15-04-05")))
 	saveDialog.Show()
 }
 
-// Helper function to copy a directory
-func copyDir(src, dst string) error {
-	// Create destination directory
-	if err := os.MkdirAll(dst, 0755); err != nil {
-		return err
-	}
+func restoreDatabase() {
+	serverMutex.Lock()
+	running := serverRunning
+	serverMutex.Unlock()
 
-	// Get directory contents
-	entries, err := os.ReadDir(src)
-	if err != nil {
-		return err
+	if running {
+		dialog.ShowInformation("Error", "Stop the server before restoring a backup.", mainWindow)
+		return
 	}
 
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
+	openDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, mainWindow)
+			return
+		}
+		if reader == nil {
+			// User cancelled
+			return
+		}
+		reader.Close()
+
+		backupPath := reader.URI().Path()
 
-		if entry.IsDir() {
-			// Recursively copy subdirectory
-			if err := copyDir(srcPath, dstPath); err != nil {
-				return err
+		manifest, err := backup.ReadManifest(backupPath)
+		if err != nil {
+			dialog.ShowError(err, mainWindow)
+			return
+		}
+
+		message := fmt.Sprintf(
+			"Replace the current database with this backup?\n\n%s\n\nThe current data will be kept in a \"pre-restore\" folder next to it.",
+			describeBackup(manifest),
+		)
+
+		dialog.ShowConfirm("Restore Backup", message, func(confirmed bool) {
+			if !confirmed {
+				return
 			}
-		} else {
-			// Copy file
-			if err := copyFile(srcPath, dstPath); err != nil {
-				return err
+
+			// the server could have been started while the dialog was open
+			serverMutex.Lock()
+			defer serverMutex.Unlock()
+			if serverRunning {
+				dialog.ShowInformation("Error", "Stop the server before restoring a backup.", mainWindow)
+				return
 			}
-		}
-	}
 
-	return nil
+			if _, err := backup.Restore(backupPath, dataDir()); err != nil {
+				dialog.ShowError(fmt.Errorf("Restore failed: %v", err), mainWindow)
+				return
+			}
+
+			log.Printf("Restored backup %s", backupPath)
+			dialog.ShowInformation("Restore Complete", "The backup was restored. Start the server to use it.", mainWindow)
+		}, mainWindow)
+	}, mainWindow)
+
+	openDialog.SetFilter(storage.NewExtensionFileFilter([]string{".zip"}))
+	openDialog.Show()
 }
 
-// Helper function to copy a file
-func copyFile(src, dst string) error {
-	// Open source file
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
+// describeBackup formats the manifest of a backup for the dialogs.
+func describeBackup(manifest *backup.Manifest) string {
+	return fmt.Sprintf(
+		"Created: %s\nSchema version: %s\nPatients: %d, Encounters: %d",
+		manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+		manifest.SchemaVersion,
+		manifest.RecordCounts["patients"],
+		manifest.RecordCounts["encounters"],
+	)
+}
 
-	// Create destination file
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return err
+// dataDir returns the pb_data directory used by the embedded server. Like
+// pocketbase.New, it is taken from the --dir flag and defaults to pb_data
+// next to the executable (or in the working directory under go run).
+func dataDir() string {
+	dir := filepath.Join(filepath.Dir(os.Args[0]), "pb_data")
+	if strings.HasPrefix(os.Args[0], os.TempDir()) {
+		wd, _ := os.Getwd()
+		dir = filepath.Join(wd, "pb_data")
 	}
-	defer dstFile.Close()
 
-	// Copy contents
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+	flags := pflag.NewFlagSet("", pflag.ContinueOnError)
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&dir, "dir", dir, "")
+	flags.Parse(os.Args[1:])
+
+	return dir
 }
 
 func openBrowser(url string) {
@@ -442,7 +1632,7 @@ func openBrowser(url string) {
 	}
 
 	if err != nil {
-		dialog.ShowError(fmt.Errorf("Failed to open browser: %v", err), nil)
+		dialog.ShowError(fmt.Errorf("Failed to open browser: %v", err), mainWindow)
 	}
 }
 
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"medical-records/accounts"
+	"medical-records/backup"
+	"medical-records/hooks"
 	_ "medical-records/migrations"
+	"medical-records/network"
+	"medical-records/settings"
+	"medical-records/sitesync"
+	"medical-records/transfer"
 
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/apis"
@@ -21,6 +32,32 @@ func main() {
 		Automigrate: false,
 	})
 
+	// First-run setup for headless installations: "server setup"
+	app.RootCmd.AddCommand(accounts.NewSetupCommand(app))
+
+	// Demo accounts are only seeded on request
+	var demo bool
+	app.RootCmd.PersistentFlags().BoolVar(&demo, "demo", false, "seed the demo accounts (never use with real patient data)")
+
+	// Validate and cache the clinic settings
+	settings.Register(app)
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		return prepareAccounts(app, demo)
+	})
+
+	// Register server-side record hooks
+	hooks.Register(app)
+
+	// Track the record changes exchanged with the other sites
+	sitesync.Register(app)
+
+	// Export and import the signed transfer bundles
+	transfer.Register(app)
+
+	// Take automatic backups while serving and at shutdown
+	backup.Register(app, backupScheduleFromEnv())
+
 	// Serve static files from the React build
 	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
 		// Get the directory where the binary is located
@@ -48,8 +85,22 @@ func main() {
 		port = "8090"
 	}
 
-	// Set up command line arguments for serve command
-	os.Args = append([]string{os.Args[0], "serve", "--http=0.0.0.0:" + port})
+	// Advertise the server on the LAN by name when requested
+	if hostname := os.Getenv("MEDS_MDNS_HOSTNAME"); hostname != "" {
+		portNumber, err := strconv.Atoi(port)
+		if err != nil {
+			log.Fatalf("Invalid PORT %q: %v", port, err)
+		}
+		if err := network.ValidateHostname(hostname); err != nil {
+			log.Fatalf("Invalid MEDS_MDNS_HOSTNAME %q: %v", hostname, err)
+		}
+		network.Advertise(app, hostname, portNumber, false)
+	}
+
+	// Serve by default, other commands (eg. setup) run as given
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		os.Args = append([]string{os.Args[0], "serve", "--http=0.0.0.0:" + port}, os.Args[1:]...)
+	}
 
 	// Start the server
 	if err := app.Start(); err != nil {
@@ -57,6 +108,92 @@ func main() {
 	}
 }
 
+// prepareAccounts seeds the demo accounts when requested and runs the
+// first-run setup from the MEDS_SUPERUSER_* and MEDS_ADMIN_* environment
+// variables when it is still pending. It fails when the setup is pending
+// and the variables are not set.
+func prepareAccounts(app core.App, demo bool) error {
+	if demo {
+		if err := accounts.SeedDemo(app.Dao()); err != nil {
+			return err
+		}
+		log.Println("Warning: Demo accounts are enabled, never use this server with real patient data")
+	} else if inUse := accounts.DemoAccountsInUse(app.Dao()); len(inUse) > 0 {
+		log.Printf("Warning: These demo accounts still use the demo password: %s", strings.Join(inUse, ", "))
+	}
+
+	pending, err := accounts.Check(app.Dao())
+	if err != nil || !pending.Any() {
+		return err
+	}
+
+	setup := accounts.Setup{
+		SuperuserEmail:    os.Getenv("MEDS_SUPERUSER_EMAIL"),
+		SuperuserPassword: os.Getenv("MEDS_SUPERUSER_PASSWORD"),
+		AdminName:         os.Getenv("MEDS_ADMIN_NAME"),
+		AdminEmail:        os.Getenv("MEDS_ADMIN_EMAIL"),
+		AdminPassword:     os.Getenv("MEDS_ADMIN_PASSWORD"),
+	}
+	// without the accounts the admin UI would let anyone create the
+	// first superuser, so don't serve at all
+	if setup == (accounts.Setup{}) {
+		return fmt.Errorf("the first-run setup is pending, run %q or set the MEDS_SUPERUSER_* and MEDS_ADMIN_* variables", os.Args[0]+" setup")
+	}
+
+	if err := setup.Run(app.Dao()); err != nil {
+		return err
+	}
+	log.Println("First-run setup completed from the environment")
+
+	return nil
+}
+
+// backupScheduleFromEnv reads the automatic backup settings from the
+// MEDS_BACKUP_* environment variables, falling back to the defaults.
+func backupScheduleFromEnv() backup.Schedule {
+	schedule := backup.DefaultSchedule()
+
+	if dir := os.Getenv("MEDS_BACKUP_DIR"); dir != "" {
+		schedule.Dir = dir
+	}
+
+	if raw := os.Getenv("MEDS_BACKUP_INTERVAL"); raw != "" {
+		if raw == "0" {
+			schedule.Interval = 0
+		} else if interval, err := time.ParseDuration(raw); err == nil {
+			schedule.Interval = interval
+		} else {
+			log.Printf("Warning: Invalid MEDS_BACKUP_INTERVAL %q: %v", raw, err)
+		}
+	}
+
+	if raw := os.Getenv("MEDS_BACKUP_ON_SHUTDOWN"); raw != "" {
+		if onShutdown, err := strconv.ParseBool(raw); err == nil {
+			schedule.OnShutdown = onShutdown
+		} else {
+			log.Printf("Warning: Invalid MEDS_BACKUP_ON_SHUTDOWN %q: %v", raw, err)
+		}
+	}
+
+	if raw := os.Getenv("MEDS_BACKUP_KEEP_DAILY"); raw != "" {
+		if keep, err := strconv.Atoi(raw); err == nil {
+			schedule.KeepDaily = keep
+		} else {
+			log.Printf("Warning: Invalid MEDS_BACKUP_KEEP_DAILY %q: %v", raw, err)
+		}
+	}
+
+	if raw := os.Getenv("MEDS_BACKUP_KEEP_WEEKLY"); raw != "" {
+		if keep, err := strconv.Atoi(raw); err == nil {
+			schedule.KeepWeekly = keep
+		} else {
+			log.Printf("Warning: Invalid MEDS_BACKUP_KEEP_WEEKLY %q: %v", raw, err)
+		}
+	}
+
+	return schedule
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
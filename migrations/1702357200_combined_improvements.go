@@ -67,17 +67,6 @@ func init() {
 	m.Register(func(db dbx.Builder) error {
 		dao := daos.New(db)
 
-		// Create admin user if it doesn't exist
-		_, err := dao.FindAdminByEmail("user@example.com")
-		if err != nil {
-			admin := &models.Admin{}
-			admin.Email = "user@example.com"
-			admin.SetPassword("password123")
-			if err := dao.SaveAdmin(admin); err != nil {
-				return err
-			}
-		}
-
 		// Get users collection and add role field first
 		users, err := dao.FindCollectionByNameOrId("users")
 		if err == nil {
@@ -97,87 +86,6 @@ func init() {
 					return err
 				}
 			}
-
-			// Create provider user
-			_, err := dao.FindFirstRecordByData("users", "email", "provider@example.com")
-			if err != nil {
-				record := models.NewRecord(users)
-				record.Set("username", "provider")
-				record.Set("email", "provider@example.com")
-				record.Set("emailVisibility", true)
-				record.Set("role", "provider")
-				record.SetPassword("password123")
-				record.Set("verified", true)
-				if err := dao.SaveRecord(record); err != nil {
-					return err
-				}
-			}
-
-			// Create additional provider users (provider2-6)
-			for i := 2; i <= 6; i++ {
-				email := fmt.Sprintf("provider%d@example.com", i)
-				_, err := dao.FindFirstRecordByData("users", "email", email)
-				if err != nil {
-					record := models.NewRecord(users)
-					record.Set("username", fmt.Sprintf("provider%d", i))
-					record.Set("email", email)
-					record.Set("emailVisibility", true)
-					record.Set("role", "provider")
-					record.SetPassword("password123")
-					record.Set("verified", true)
-					if err := dao.SaveRecord(record); err != nil {
-						return err
-					}
-				}
-			}
-
-			// Create pharmacy user
-			_, err = dao.FindFirstRecordByData("users", "email", "pharmacyuser@example.com")
-			if err != nil {
-				record := models.NewRecord(users)
-				record.Set("username", "pharmacyuser")
-				record.Set("email", "pharmacyuser@example.com")
-				record.Set("emailVisibility", true)
-				record.Set("role", "pharmacy")
-				record.SetPassword("password123")
-				record.Set("verified", true)
-				if err := dao.SaveRecord(record); err != nil {
-					return err
-				}
-			}
-
-			// Create additional pharmacy users (pharmacyuser2-4)
-			for i := 2; i <= 4; i++ {
-				email := fmt.Sprintf("pharmacyuser%d@example.com", i)
-				_, err := dao.FindFirstRecordByData("users", "email", email)
-				if err != nil {
-					record := models.NewRecord(users)
-					record.Set("username", fmt.Sprintf("pharmacyuser%d", i))
-					record.Set("email", email)
-					record.Set("emailVisibility", true)
-					record.Set("role", "pharmacy")
-					record.SetPassword("password123")
-					record.Set("verified", true)
-					if err := dao.SaveRecord(record); err != nil {
-						return err
-					}
-				}
-			}
-
-			// Create admin user
-			_, err = dao.FindFirstRecordByData("users", "email", "admin@example.com")
-			if err != nil {
-				record := models.NewRecord(users)
-				record.Set("username", "admin")
-				record.Set("email", "admin@example.com")
-				record.Set("emailVisibility", true)
-				record.Set("role", "admin")
-				record.SetPassword("password123")
-				record.Set("verified", true)
-				if err := dao.SaveRecord(record); err != nil {
-					return err
-				}
-			}
 		}
 
 		// Create settings collection
@@ -251,13 +159,9 @@ func init() {
 		})
 		defaultSettings.Set("last_updated", time.Now().Format("2006-01-02 15:04:05.000Z"))
 
-		// Find admin user to set as updated_by
-		adminUser, err := dao.FindFirstRecordByData("users", "email", "admin@example.com")
-		if err == nil {
-			defaultSettings.Set("updated_by", adminUser.Id)
-			if err := dao.SaveRecord(defaultSettings); err != nil {
-				return err
-			}
+		// updated_by is set by the first-run setup (or the demo accounts)
+		if err := dao.SaveRecord(defaultSettings); err != nil {
+			return err
 		}
 
 		// Create inventory collection
@@ -0,0 +1,151 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		inventory, err := dao.FindCollectionByNameOrId("inventory")
+		if err != nil {
+			return err
+		}
+
+		// Create inventory_lots collection
+		lots := &models.Collection{
+			Name: "inventory_lots",
+			Type: "base",
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "inventory",
+					Type:     "relation",
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  inventory.Id,
+						MaxSelect:     types.Pointer(1),
+						CascadeDelete: true,
+					},
+				},
+				&schema.SchemaField{
+					Name:     "lot_number",
+					Type:     "text",
+					Required: true,
+				},
+				&schema.SchemaField{
+					Name:     "expiry_date",
+					Type:     "date",
+					Required: false,
+				},
+				&schema.SchemaField{
+					Name:     "received_quantity",
+					Type:     "number",
+					Required: false,
+					Options: &schema.NumberOptions{
+						Min: types.Pointer(0.0),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "remaining_quantity",
+					Type:     "number",
+					Required: false,
+					Options: &schema.NumberOptions{
+						Min: types.Pointer(0.0),
+					},
+				},
+			),
+		}
+
+		authRule := "@request.auth.id != ''"
+		adminRule := "@request.auth.role = 'admin'"
+		providerRule := "@request.auth.role = 'provider'"
+		pharmacyRule := "@request.auth.role = 'pharmacy'"
+
+		baseRule := fmt.Sprintf("%s || %s", adminRule, authRule)
+		deleteRule := fmt.Sprintf("%s || %s || %s", adminRule, providerRule, pharmacyRule)
+
+		lots.CreateRule = &baseRule
+		lots.UpdateRule = &baseRule
+		lots.DeleteRule = &deleteRule
+		lots.ListRule = &baseRule
+		lots.ViewRule = &baseRule
+
+		if err := dao.SaveCollection(lots); err != nil {
+			return err
+		}
+
+		// Disbursements remember which lots they were drawn from so that
+		// the stock can be returned to the same lots on update/delete
+		disbursements, err := dao.FindCollectionByNameOrId("disbursements")
+		if err != nil {
+			return err
+		}
+
+		if disbursements.Schema.GetFieldByName("lot_allocations") == nil {
+			disbursements.Schema.AddField(&schema.SchemaField{
+				Name:     "lot_allocations",
+				Type:     "json",
+				Required: false,
+				Options: &schema.JsonOptions{
+					MaxSize: 2097152, // 2MB
+				},
+			})
+
+			if err := dao.SaveCollection(disbursements); err != nil {
+				return err
+			}
+		}
+
+		// Move the existing stock into an opening lot per item so that
+		// inventory.stock stays equal to the sum of its lots
+		items, err := dao.FindRecordsByExpr("inventory")
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			stock := item.GetFloat("stock")
+			if stock <= 0 {
+				continue
+			}
+
+			lot := models.NewRecord(lots)
+			lot.Set("inventory", item.Id)
+			lot.Set("lot_number", "OPENING")
+			lot.Set("received_quantity", stock)
+			lot.Set("remaining_quantity", stock)
+			if err := dao.SaveRecord(lot); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		disbursements, err := dao.FindCollectionByNameOrId("disbursements")
+		if err == nil {
+			if field := disbursements.Schema.GetFieldByName("lot_allocations"); field != nil {
+				disbursements.Schema.RemoveField(field.Id)
+				if err := dao.SaveCollection(disbursements); err != nil {
+					return err
+				}
+			}
+		}
+
+		lots, err := dao.FindCollectionByNameOrId("inventory_lots")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(lots)
+	})
+}
@@ -0,0 +1,143 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		inventory, err := dao.FindCollectionByNameOrId("inventory")
+		if err != nil {
+			return err
+		}
+
+		users, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Create the append-only inventory_transactions ledger
+		transactions := &models.Collection{
+			Name: "inventory_transactions",
+			Type: "base",
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "inventory",
+					Type:     "relation",
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId:  inventory.Id,
+						MaxSelect:     types.Pointer(1),
+						CascadeDelete: false,
+					},
+				},
+				&schema.SchemaField{
+					Name:     "delta",
+					Type:     "number",
+					Required: true,
+				},
+				&schema.SchemaField{
+					Name:     "stock_after",
+					Type:     "number",
+					Required: false,
+				},
+				&schema.SchemaField{
+					Name:     "reason",
+					Type:     "select",
+					Required: true,
+					Options: &schema.SelectOptions{
+						MaxSelect: 1,
+						Values: []string{
+							"opening_balance",
+							"manual_adjustment",
+							"lot_received",
+							"lot_adjustment",
+							"lot_removed",
+							"disbursement",
+							"disbursement_return",
+						},
+					},
+				},
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     "relation",
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId: users.Id,
+						MaxSelect:    types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "admin",
+					Type:     "text",
+					Required: false,
+				},
+				&schema.SchemaField{
+					Name:     "source_collection",
+					Type:     "text",
+					Required: false,
+				},
+				&schema.SchemaField{
+					Name:     "source_record",
+					Type:     "text",
+					Required: false,
+				},
+			),
+		}
+
+		// Entries are written by the server hooks only
+		authRule := "@request.auth.id != ''"
+
+		transactions.ListRule = &authRule
+		transactions.ViewRule = &authRule
+		transactions.CreateRule = nil
+		transactions.UpdateRule = nil
+		transactions.DeleteRule = nil
+
+		if err := dao.SaveCollection(transactions); err != nil {
+			return err
+		}
+
+		// Book the current stock as the opening balance of the ledger
+		items, err := dao.FindRecordsByExpr("inventory")
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			stock := item.GetFloat("stock")
+			if stock == 0 {
+				continue
+			}
+
+			entry := models.NewRecord(transactions)
+			entry.Set("inventory", item.Id)
+			entry.Set("delta", stock)
+			entry.Set("stock_after", stock)
+			entry.Set("reason", "opening_balance")
+			entry.Set("source_collection", "inventory")
+			entry.Set("source_record", item.Id)
+			if err := dao.SaveRecord(entry); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		transactions, err := dao.FindCollectionByNameOrId("inventory_transactions")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(transactions)
+	})
+}
@@ -0,0 +1,102 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		users, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Create the read-only audit_log collection.
+		// patient and record are plain text so that the entries outlive
+		// the records they describe.
+		auditLog := &models.Collection{
+			Name: "audit_log",
+			Type: "base",
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "action",
+					Type:     "select",
+					Required: true,
+					Options: &schema.SelectOptions{
+						MaxSelect: 1,
+						Values:    []string{"create", "update", "delete"},
+					},
+				},
+				&schema.SchemaField{
+					Name:     "collection",
+					Type:     "text",
+					Required: true,
+				},
+				&schema.SchemaField{
+					Name:     "record",
+					Type:     "text",
+					Required: true,
+				},
+				&schema.SchemaField{
+					Name:     "patient",
+					Type:     "text",
+					Required: false,
+				},
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     "relation",
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId: users.Id,
+						MaxSelect:    types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "admin",
+					Type:     "text",
+					Required: false,
+				},
+				&schema.SchemaField{
+					Name:     "changes",
+					Type:     "json",
+					Required: false,
+					Options: &schema.JsonOptions{
+						MaxSize: 2097152, // 2MB
+					},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_audit_log_patient ON audit_log (patient)",
+				"CREATE INDEX idx_audit_log_user ON audit_log (user)",
+				"CREATE INDEX idx_audit_log_record ON audit_log (collection, record)",
+			},
+		}
+
+		// Entries are written by the server hooks only and readable by admins
+		adminRule := "@request.auth.role = 'admin'"
+
+		auditLog.ListRule = &adminRule
+		auditLog.ViewRule = &adminRule
+		auditLog.CreateRule = nil
+		auditLog.UpdateRule = nil
+		auditLog.DeleteRule = nil
+
+		return dao.SaveCollection(auditLog)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		auditLog, err := dao.FindCollectionByNameOrId("audit_log")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(auditLog)
+	})
+}
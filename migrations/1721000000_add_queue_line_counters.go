@@ -0,0 +1,98 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		// Line numbers are now allocated by the queue hooks
+		if _, err := db.NewQuery("DROP TRIGGER IF EXISTS tr_queue_line_number").Execute(); err != nil {
+			return err
+		}
+
+		// One row per clinic day holding the last line number handed out.
+		// It is a plain table because it is only touched by the server.
+		_, err := db.NewQuery(`
+			CREATE TABLE IF NOT EXISTS queue_line_counters (
+				day         TEXT PRIMARY KEY NOT NULL,
+				last_number INTEGER DEFAULT 0 NOT NULL
+			)
+		`).Execute()
+		if err != nil {
+			return err
+		}
+
+		queue, err := dao.FindCollectionByNameOrId("queue")
+		if err != nil {
+			return err
+		}
+
+		if field := queue.Schema.GetFieldByName("line_number"); field != nil {
+			field.Required = false
+		}
+
+		if err := dao.SaveCollection(queue); err != nil {
+			return err
+		}
+
+		settings, err := dao.FindCollectionByNameOrId("settings")
+		if err != nil {
+			return err
+		}
+
+		// IANA name such as "America/Guatemala", empty means the server timezone
+		settings.Schema.AddField(&schema.SchemaField{
+			Name:     "clinic_timezone",
+			Type:     "text",
+			Required: false,
+		})
+
+		return dao.SaveCollection(settings)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		if settings, err := dao.FindCollectionByNameOrId("settings"); err == nil {
+			if field := settings.Schema.GetFieldByName("clinic_timezone"); field != nil {
+				settings.Schema.RemoveField(field.Id)
+				if err := dao.SaveCollection(settings); err != nil {
+					return err
+				}
+			}
+		}
+
+		if queue, err := dao.FindCollectionByNameOrId("queue"); err == nil {
+			if field := queue.Schema.GetFieldByName("line_number"); field != nil {
+				field.Required = true
+				if err := dao.SaveCollection(queue); err != nil {
+					return err
+				}
+			}
+		}
+
+		if _, err := db.NewQuery("DROP TABLE IF EXISTS queue_line_counters").Execute(); err != nil {
+			return err
+		}
+
+		_, err := db.NewQuery(`
+			CREATE TRIGGER IF NOT EXISTS tr_queue_line_number
+			AFTER INSERT ON queue
+			BEGIN
+				UPDATE queue
+				SET line_number = (
+					SELECT COALESCE(MAX(line_number), 0) + 1
+					FROM queue
+					WHERE strftime('%Y-%m-%d', created) = strftime('%Y-%m-%d', NEW.created)
+				)
+				WHERE id = NEW.id AND NEW.line_number IS NULL;
+			END;
+		`).Execute()
+
+		return err
+	})
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		settings, err := dao.FindCollectionByNameOrId("settings")
+		if err != nil {
+			return err
+		}
+
+		// Idle minutes after which the server releases an encounter lock,
+		// empty means the default of 10 minutes
+		settings.Schema.AddField(&schema.SchemaField{
+			Name:     "encounter_lock_timeout",
+			Type:     "number",
+			Required: false,
+			Options: &schema.NumberOptions{
+				Min:       types.Pointer(1.0),
+				NoDecimal: true,
+			},
+		})
+
+		return dao.SaveCollection(settings)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		settings, err := dao.FindCollectionByNameOrId("settings")
+		if err != nil {
+			return nil
+		}
+
+		if field := settings.Schema.GetFieldByName("encounter_lock_timeout"); field != nil {
+			settings.Schema.RemoveField(field.Id)
+			return dao.SaveCollection(settings)
+		}
+
+		return nil
+	})
+}
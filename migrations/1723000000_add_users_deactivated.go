@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		users, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Deactivated users keep their history but can no longer sign in
+		users.Schema.AddField(&schema.SchemaField{
+			Name:     "deactivated",
+			Type:     "bool",
+			Required: false,
+		})
+
+		return dao.SaveCollection(users)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		users, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return nil
+		}
+
+		if field := users.Schema.GetFieldByName("deactivated"); field != nil {
+			users.Schema.RemoveField(field.Id)
+			return dao.SaveCollection(users)
+		}
+
+		return nil
+	})
+}
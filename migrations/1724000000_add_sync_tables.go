@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		// The site sync bookkeeping is only touched by the server, so like
+		// queue_line_counters it lives in plain tables.
+		statements := []string{
+			// site id, name and peer key of this installation
+			`CREATE TABLE IF NOT EXISTS sync_state (
+				key   TEXT PRIMARY KEY NOT NULL,
+				value TEXT DEFAULT '' NOT NULL
+			)`,
+			// version vector and local change sequence of every synced record
+			`CREATE TABLE IF NOT EXISTS sync_versions (
+				collection TEXT NOT NULL,
+				record     TEXT NOT NULL,
+				vector     TEXT DEFAULT '{}' NOT NULL,
+				seq        INTEGER DEFAULT 0 NOT NULL,
+				deleted    BOOLEAN DEFAULT FALSE NOT NULL,
+				PRIMARY KEY (collection, record)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_sync_versions_seq ON sync_versions (seq)`,
+			// remote records matched to an existing local record (eg. the
+			// same drug seeded with a different id on each laptop)
+			`CREATE TABLE IF NOT EXISTS sync_aliases (
+				collection TEXT NOT NULL,
+				remote     TEXT NOT NULL,
+				local      TEXT NOT NULL,
+				PRIMARY KEY (collection, remote)
+			)`,
+			// the other sites and how far the exchange with them got
+			`CREATE TABLE IF NOT EXISTS sync_peers (
+				site         TEXT PRIMARY KEY NOT NULL,
+				name         TEXT DEFAULT '' NOT NULL,
+				url          TEXT DEFAULT '' NOT NULL,
+				key          TEXT DEFAULT '' NOT NULL,
+				received_seq INTEGER DEFAULT 0 NOT NULL,
+				sent_seq     INTEGER DEFAULT 0 NOT NULL,
+				last_sync    TEXT DEFAULT '' NOT NULL,
+				last_error   TEXT DEFAULT '' NOT NULL
+			)`,
+			// concurrent changes waiting for a review
+			`CREATE TABLE IF NOT EXISTS sync_conflicts (
+				id             TEXT PRIMARY KEY NOT NULL,
+				collection     TEXT NOT NULL,
+				record         TEXT NOT NULL,
+				site           TEXT DEFAULT '' NOT NULL,
+				local_vector   TEXT DEFAULT '{}' NOT NULL,
+				remote_vector  TEXT DEFAULT '{}' NOT NULL,
+				local_data     TEXT DEFAULT '' NOT NULL,
+				remote_data    TEXT DEFAULT '' NOT NULL,
+				local_deleted  BOOLEAN DEFAULT FALSE NOT NULL,
+				remote_deleted BOOLEAN DEFAULT FALSE NOT NULL,
+				resolution     TEXT DEFAULT '' NOT NULL,
+				created        TEXT DEFAULT '' NOT NULL,
+				resolved       TEXT DEFAULT '' NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_sync_conflicts_record ON sync_conflicts (collection, record)`,
+		}
+
+		for _, statement := range statements {
+			if _, err := db.NewQuery(statement).Execute(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(db dbx.Builder) error {
+		for _, table := range []string{"sync_conflicts", "sync_peers", "sync_aliases", "sync_versions", "sync_state"} {
+			if _, err := db.NewQuery("DROP TABLE IF EXISTS " + table).Execute(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
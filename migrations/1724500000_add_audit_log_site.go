@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		auditLog, err := dao.FindCollectionByNameOrId("audit_log")
+		if err != nil {
+			return err
+		}
+
+		// Changes received from another site are audited with the id of
+		// that site instead of a user or admin
+		auditLog.Schema.AddField(&schema.SchemaField{
+			Name:     "site",
+			Type:     "text",
+			Required: false,
+		})
+
+		return dao.SaveCollection(auditLog)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		auditLog, err := dao.FindCollectionByNameOrId("audit_log")
+		if err != nil {
+			return nil
+		}
+
+		if field := auditLog.Schema.GetFieldByName("site"); field != nil {
+			auditLog.Schema.RemoveField(field.Id)
+			return dao.SaveCollection(auditLog)
+		}
+
+		return nil
+	})
+}
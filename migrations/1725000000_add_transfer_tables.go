@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		statements := []string{
+			// the transfer bundles imported so far with their import report
+			`CREATE TABLE IF NOT EXISTS transfer_imports (
+				id       TEXT PRIMARY KEY NOT NULL,
+				origin   TEXT DEFAULT '' NOT NULL,
+				signer   TEXT DEFAULT '' NOT NULL,
+				imported TEXT DEFAULT '' NOT NULL,
+				report   TEXT DEFAULT '' NOT NULL
+			)`,
+			// the local record each imported record was written to (or
+			// matched with), per signing installation, so that later
+			// bundles of the same laptop don't duplicate them
+			`CREATE TABLE IF NOT EXISTS transfer_records (
+				signer     TEXT NOT NULL,
+				collection TEXT NOT NULL,
+				source     TEXT NOT NULL,
+				local      TEXT NOT NULL,
+				PRIMARY KEY (signer, collection, source)
+			)`,
+		}
+
+		for _, statement := range statements {
+			if _, err := db.NewQuery(statement).Execute(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(db dbx.Builder) error {
+		for _, table := range []string{"transfer_records", "transfer_imports"} {
+			if _, err := db.NewQuery("DROP TABLE IF EXISTS " + table).Execute(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
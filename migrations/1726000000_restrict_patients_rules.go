@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		patients, err := dao.FindCollectionByNameOrId("patients")
+		if err != nil {
+			return err
+		}
+
+		// Only signed in staff can see patients. The fields each role may
+		// change are checked by the record hooks, anonymous check-ins go
+		// through the kiosk route.
+		patients.ListRule = types.Pointer("@request.auth.id != ''")
+		patients.ViewRule = types.Pointer("@request.auth.id != ''")
+		patients.CreateRule = types.Pointer("@request.auth.role = 'provider' || @request.auth.role = 'admin'")
+		patients.UpdateRule = types.Pointer("@request.auth.role = 'provider' || @request.auth.role = 'pharmacy' || @request.auth.role = 'admin'")
+		patients.DeleteRule = types.Pointer("@request.auth.role = 'provider' || @request.auth.role = 'admin'")
+
+		return dao.SaveCollection(patients)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		patients, err := dao.FindCollectionByNameOrId("patients")
+		if err != nil {
+			return nil
+		}
+
+		patients.ListRule = types.Pointer("")
+		patients.ViewRule = types.Pointer("")
+		patients.CreateRule = types.Pointer("")
+		patients.UpdateRule = types.Pointer("")
+
+		return dao.SaveCollection(patients)
+	})
+}
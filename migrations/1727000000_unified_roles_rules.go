@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	// collections whose delete rule is limited to providers and admins
+	providerDeleteCollections := []string{
+		"inventory", "encounters", "chief_complaints", "encounter_question_categories",
+		"encounter_questions", "encounter_responses", "bulk_distributions",
+		"bulk_distribution_items", "queue", "diagnosis", "patients",
+	}
+
+	adminRule := "@request.auth.role = 'admin'"
+	providerRule := "@request.auth.role = 'provider'"
+
+	// The pharmacy gets the provider rights while display_preferences.unified_roles
+	// is enabled. ?= needs an existing settings record, so nothing is widened
+	// before the settings are created.
+	unifiedPharmacyRule := "(@request.auth.role = 'pharmacy' && @collection.settings.display_preferences.unified_roles ?= true)"
+
+	deleteRule := fmt.Sprintf("%s || %s", adminRule, providerRule)
+	unifiedDeleteRule := fmt.Sprintf("%s || %s || %s", adminRule, providerRule, unifiedPharmacyRule)
+
+	patientCreateRule := fmt.Sprintf("%s || %s", providerRule, adminRule)
+	unifiedPatientCreateRule := fmt.Sprintf("%s || %s || %s", providerRule, adminRule, unifiedPharmacyRule)
+
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		for _, name := range providerDeleteCollections {
+			collection, err := dao.FindCollectionByNameOrId(name)
+			if err != nil {
+				return err
+			}
+
+			collection.DeleteRule = types.Pointer(unifiedDeleteRule)
+			if name == "patients" {
+				collection.CreateRule = types.Pointer(unifiedPatientCreateRule)
+			}
+
+			if err := dao.SaveCollection(collection); err != nil {
+				return err
+			}
+		}
+
+		// disbursements already allow both roles to delete
+		return nil
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		for _, name := range providerDeleteCollections {
+			collection, err := dao.FindCollectionByNameOrId(name)
+			if err != nil {
+				continue
+			}
+
+			collection.DeleteRule = types.Pointer(deleteRule)
+			if name == "patients" {
+				collection.CreateRule = types.Pointer(patientCreateRule)
+			}
+
+			if err := dao.SaveCollection(collection); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,308 @@
+package migrations
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// legacyCareTeam is a value of the former queue.intended_provider select.
+type legacyCareTeam struct {
+	value     string
+	name      string
+	specialty string
+}
+
+// legacyCareTeams lists the former select values in their original order.
+func legacyCareTeams() []legacyCareTeam {
+	teams := []legacyCareTeam{}
+	for i := 1; i <= 10; i++ {
+		teams = append(teams, legacyCareTeam{
+			value:     fmt.Sprintf("team%d", i),
+			name:      fmt.Sprintf("Care Team %d", i),
+			specialty: "general",
+		})
+	}
+
+	return append(teams,
+		legacyCareTeam{value: "gyn_team", name: "Gyn Team", specialty: "gynecology"},
+		legacyCareTeam{value: "optometry_team", name: "Optometry Team", specialty: "optometry"},
+	)
+}
+
+// legacyTeamPreferences are the display preferences that used to pick the
+// care teams offered by the queue.
+var legacyTeamPreferences = []string{"care_team_count", "show_gyn_team", "show_optometry_team"}
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		// Create care_teams collection
+		careTeams := &models.Collection{
+			Name: "care_teams",
+			Type: "base",
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "name",
+					Type:     "text",
+					Required: true,
+				},
+				&schema.SchemaField{
+					Name:     "specialty",
+					Type:     "text",
+					Required: false,
+				},
+				&schema.SchemaField{
+					Name:     "active",
+					Type:     "bool",
+					Required: false,
+				},
+				&schema.SchemaField{
+					Name:     "members",
+					Type:     "relation",
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId: "users",
+						MinSelect:    nil,
+						MaxSelect:    nil,
+					},
+				},
+				&schema.SchemaField{
+					Name:     "capacity",
+					Type:     "number",
+					Required: false,
+					Options: &schema.NumberOptions{
+						Min:       types.Pointer(0.0),
+						NoDecimal: true,
+					},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE UNIQUE INDEX idx_care_teams_name ON care_teams (name)",
+			},
+		}
+
+		// Every signed in user picks teams in the queue, admins manage them
+		authRule := "@request.auth.id != ''"
+		adminRule := "@request.auth.role = 'admin'"
+
+		careTeams.ListRule = &authRule
+		careTeams.ViewRule = &authRule
+		careTeams.CreateRule = &adminRule
+		careTeams.UpdateRule = &adminRule
+		careTeams.DeleteRule = &adminRule
+
+		if err := dao.SaveCollection(careTeams); err != nil {
+			return err
+		}
+
+		// Seed a team per former select value, active when the settings
+		// offered it
+		settingsRecord, preferences, err := legacyDisplayPreferences(dao)
+		if err != nil {
+			return err
+		}
+
+		teamCount := 6
+		if count, ok := preferences["care_team_count"].(float64); ok {
+			teamCount = int(count)
+		}
+		showGyn, _ := preferences["show_gyn_team"].(bool)
+		showOptometry, _ := preferences["show_optometry_team"].(bool)
+
+		teamIds := map[string]string{}
+		for i, legacy := range legacyCareTeams() {
+			active := i < teamCount
+			switch legacy.value {
+			case "gyn_team":
+				active = showGyn
+			case "optometry_team":
+				active = showOptometry
+			}
+
+			team := models.NewRecord(careTeams)
+			team.Set("name", legacy.name)
+			team.Set("specialty", legacy.specialty)
+			team.Set("active", active)
+			if err := dao.SaveRecord(team); err != nil {
+				return err
+			}
+
+			teamIds[legacy.value] = team.Id
+		}
+
+		// Turn queue.intended_provider into a relation. Single selects and
+		// single relations share the same column, so the values are kept and
+		// converted in place.
+		queue, err := dao.FindCollectionByNameOrId("queue")
+		if err != nil {
+			return err
+		}
+
+		field := queue.Schema.GetFieldByName("intended_provider")
+		if field == nil {
+			return fmt.Errorf("queue has no intended_provider field")
+		}
+
+		field.Type = schema.FieldTypeRelation
+		field.Options = &schema.RelationOptions{
+			CollectionId: careTeams.Id,
+			MinSelect:    nil,
+			MaxSelect:    types.Pointer(1),
+		}
+
+		if err := dao.SaveCollection(queue); err != nil {
+			return err
+		}
+
+		for value, id := range teamIds {
+			_, err := db.NewQuery("UPDATE queue SET intended_provider = {:id} WHERE intended_provider = {:value}").
+				Bind(dbx.Params{"id": id, "value": value}).
+				Execute()
+			if err != nil {
+				return err
+			}
+		}
+
+		// The care teams replace the display preferences that faked them
+		if settingsRecord != nil {
+			for _, key := range legacyTeamPreferences {
+				delete(preferences, key)
+			}
+			settingsRecord.Set("display_preferences", preferences)
+			if err := dao.SaveRecord(settingsRecord); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		careTeams, err := dao.FindCollectionByNameOrId("care_teams")
+		if err != nil {
+			return nil
+		}
+
+		// Map the seeded teams back to their select values, assignments to
+		// teams added later are dropped
+		teams, err := dao.FindRecordsByExpr(careTeams.Id)
+		if err != nil {
+			return err
+		}
+
+		byName := map[string]*models.Record{}
+		for _, team := range teams {
+			byName[team.GetString("name")] = team
+		}
+
+		teamCount := 0
+		showGyn := false
+		showOptometry := false
+		values := map[string]string{}
+		legacyValues := []any{}
+		for _, legacy := range legacyCareTeams() {
+			legacyValues = append(legacyValues, legacy.value)
+
+			team := byName[legacy.name]
+			if team == nil {
+				continue
+			}
+			values[team.Id] = legacy.value
+
+			if !team.GetBool("active") {
+				continue
+			}
+			switch legacy.value {
+			case "gyn_team":
+				showGyn = true
+			case "optometry_team":
+				showOptometry = true
+			default:
+				teamCount++
+			}
+		}
+
+		queue, err := dao.FindCollectionByNameOrId("queue")
+		if err != nil {
+			return err
+		}
+
+		if field := queue.Schema.GetFieldByName("intended_provider"); field != nil {
+			selectValues := make([]string, len(legacyValues))
+			for i, value := range legacyValues {
+				selectValues[i] = value.(string)
+			}
+
+			field.Type = schema.FieldTypeSelect
+			field.Options = &schema.SelectOptions{
+				MaxSelect: 1,
+				Values:    selectValues,
+			}
+
+			if err := dao.SaveCollection(queue); err != nil {
+				return err
+			}
+
+			for id, value := range values {
+				_, err := db.NewQuery("UPDATE queue SET intended_provider = {:value} WHERE intended_provider = {:id}").
+					Bind(dbx.Params{"id": id, "value": value}).
+					Execute()
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err := db.Update(
+				"queue",
+				dbx.Params{"intended_provider": ""},
+				dbx.NotIn("intended_provider", legacyValues...),
+			).Execute()
+			if err != nil {
+				return err
+			}
+		}
+
+		settingsRecord, current, err := legacyDisplayPreferences(dao)
+		if err != nil {
+			return err
+		}
+		if settingsRecord != nil {
+			current["care_team_count"] = max(teamCount, 1)
+			current["show_gyn_team"] = showGyn
+			current["show_optometry_team"] = showOptometry
+			settingsRecord.Set("display_preferences", current)
+			if err := dao.SaveRecord(settingsRecord); err != nil {
+				return err
+			}
+		}
+
+		return dao.DeleteCollection(careTeams)
+	})
+}
+
+// legacyDisplayPreferences returns the settings record and its decoded
+// display preferences. The record is nil when there are no settings yet.
+func legacyDisplayPreferences(dao *daos.Dao) (*models.Record, map[string]any, error) {
+	preferences := map[string]any{}
+
+	records, err := dao.FindRecordsByFilter("settings", "id != ''", "created", 1, 0)
+	if err != nil || len(records) == 0 {
+		return nil, preferences, err
+	}
+
+	if raw, ok := records[0].Get("display_preferences").(types.JsonRaw); ok && len(raw) > 0 {
+		if err := json.Unmarshal(raw, &preferences); err != nil || preferences == nil {
+			preferences = map[string]any{}
+		}
+	}
+
+	return records[0], preferences, nil
+}
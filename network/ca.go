@@ -0,0 +1,157 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	caCertificateFile = "ca.crt"
+	caKeyFile         = "ca.key"
+
+	// caValidity is long enough to not reinstall the CA on the devices
+	// between the trips
+	caValidity = 10 * 365 * 24 * time.Hour
+)
+
+// CA is the local root certificate authority that signs the server
+// certificates. Installing its certificate on a device once makes it trust
+// every server certificate issued later on (eg. when the LAN IPs change).
+type CA struct {
+	Certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+
+	// PEM is the PEM encoded certificate, as handed out to the devices.
+	PEM []byte
+}
+
+// LoadOrCreateCA returns the local CA stored in dir. A new one is generated
+// when there is none yet or when it is about to expire.
+func LoadOrCreateCA(dir string) (*CA, error) {
+	if ca, err := LoadCA(dir); err == nil && time.Now().Add(certificateValidity).Before(ca.Certificate.NotAfter) {
+		return ca, nil
+	}
+
+	certPath := filepath.Join(dir, caCertificateFile)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	certPEM, keyPEM, err := generateCA()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return nil, err
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCA(pair)
+}
+
+// LoadCA returns the local CA stored in dir, without creating one.
+func LoadCA(dir string) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, caCertificateFile), filepath.Join(dir, caKeyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return newCA(pair)
+}
+
+// newCA wraps a loaded CA key pair.
+func newCA(pair tls.Certificate) (*CA, error) {
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok || !cert.IsCA {
+		return nil, x509.ErrUnsupportedAlgorithm
+	}
+
+	return &CA{
+		Certificate: cert,
+		key:         key,
+		PEM:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pair.Certificate[0]}),
+	}, nil
+}
+
+// Signed reports whether cert was issued by the CA.
+func (ca *CA) Signed(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(ca.Certificate) == nil
+}
+
+// generateCA creates a PEM encoded root certificate and key.
+func generateCA() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name := "MEDS Local CA"
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		name += " (" + hostname + ")"
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"MEDS"}, CommonName: name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encodePair(der, key)
+}
+
+// encodePair PEM encodes a certificate and its key.
+func encodePair(der []byte, key *ecdsa.PrivateKey) (certPEM, keyPEM []byte, err error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
+
+// randomSerial returns a random 128 bit certificate serial number.
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,98 @@
+// Package network configures where the embedded MEDS server listens and
+// how the devices on the clinic network reach it.
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// DefaultPort is the port used when nothing is configured.
+const DefaultPort = 8090
+
+// Config is the listen configuration of the server.
+type Config struct {
+	// Host is the address to bind to. Empty or "0.0.0.0" listens on all
+	// the interfaces.
+	Host string `json:"host"`
+
+	// Port is the TCP port to listen on.
+	Port int `json:"port"`
+
+	// TLS serves HTTPS with a locally generated certificate.
+	TLS bool `json:"tls"`
+
+	// Hostname is advertised via mDNS as <hostname>.local when listening
+	// on all the interfaces. Empty disables the advertisement.
+	Hostname string `json:"hostname"`
+}
+
+// DefaultConfig returns the config used when nothing is configured.
+func DefaultConfig() Config {
+	return Config{
+		Host:     "0.0.0.0",
+		Port:     DefaultPort,
+		Hostname: DefaultHostname,
+	}
+}
+
+// Validate checks that the config can be listened on.
+func (c Config) Validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return errors.New("the port must be between 1 and 65535")
+	}
+
+	if c.Host != "" && net.ParseIP(c.Host) == nil {
+		return fmt.Errorf("%q is not an IP address", c.Host)
+	}
+
+	if c.Hostname != "" {
+		if err := ValidateHostname(c.Hostname); err != nil {
+			return fmt.Errorf("server name: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Addr returns the TCP address to listen on (eg. "0.0.0.0:8090").
+func (c Config) Addr() string {
+	host := c.Host
+	if host == "" {
+		host = "0.0.0.0"
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(c.Port))
+}
+
+// Scheme returns "https" when TLS is enabled and "http" otherwise.
+func (c Config) Scheme() string {
+	if c.TLS {
+		return "https"
+	}
+
+	return "http"
+}
+
+// AllInterfaces reports whether the server listens on every interface.
+func (c Config) AllInterfaces() bool {
+	ip := net.ParseIP(c.Host)
+
+	return c.Host == "" || (ip != nil && ip.IsUnspecified())
+}
+
+// URL returns the base URL of the server at host, without a trailing slash.
+func (c Config) URL(host string) string {
+	return fmt.Sprintf("%s://%s", c.Scheme(), net.JoinHostPort(host, strconv.Itoa(c.Port)))
+}
+
+// LocalURL returns the base URL used to open the server on this computer.
+func (c Config) LocalURL() string {
+	if c.AllInterfaces() || net.ParseIP(c.Host).IsLoopback() {
+		return c.URL("localhost")
+	}
+
+	return c.URL(c.Host)
+}
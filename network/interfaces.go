@@ -0,0 +1,100 @@
+package network
+
+import (
+	"net"
+	"time"
+)
+
+// Address is an IPv4 address of a network interface.
+type Address struct {
+	Interface string
+	IP        net.IP
+}
+
+// LANAddresses returns the non-loopback IPv4 addresses of the interfaces
+// that are up.
+func LANAddresses() ([]Address, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Address
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				if ipnet.IP.To4() != nil && !ipnet.IP.IsLoopback() {
+					result = append(result, Address{Interface: iface.Name, IP: ipnet.IP.To4()})
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// DeviceAddresses returns the addresses other devices can reach the server
+// at: the LAN addresses when listening on all the interfaces, the bound
+// address otherwise.
+func (c Config) DeviceAddresses() ([]Address, error) {
+	if !c.AllInterfaces() {
+		ip := net.ParseIP(c.Host)
+		if ip.IsLoopback() {
+			return nil, nil
+		}
+		return []Address{{IP: ip}}, nil
+	}
+
+	return LANAddresses()
+}
+
+// WatchAddresses calls onChange with the LAN addresses right away and then
+// every time they change (eg. when the laptop joins a hotspot). It polls
+// every interval until stop is closed.
+func WatchAddresses(interval time.Duration, stop <-chan struct{}, onChange func([]Address)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []Address
+	first := true
+
+	for {
+		addresses, err := LANAddresses()
+		if err == nil && (first || !sameAddresses(last, addresses)) {
+			first = false
+			last = addresses
+			onChange(addresses)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sameAddresses reports whether a and b hold the same addresses in the same
+// order.
+func sameAddresses(a, b []Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Interface != b[i].Interface || !a[i].IP.Equal(b[i].IP) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,198 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/mdns"
+	"github.com/miekg/dns"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// DefaultHostname is the mDNS name advertised when nothing is configured
+// (ie. "meds.local").
+const DefaultHostname = "meds"
+
+// hostnameRegex matches a single DNS label.
+var hostnameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidateHostname checks that name can be advertised as <name>.local.
+func ValidateHostname(name string) error {
+	if !hostnameRegex.MatchString(name) {
+		return errors.New("the name may only contain lowercase letters, digits and dashes (eg. \"meds\")")
+	}
+
+	return nil
+}
+
+// LocalHostname returns the mDNS host name of name (eg. "meds.local").
+func LocalHostname(name string) string {
+	return name + ".local"
+}
+
+// Advertiser announces the server on the LAN via mDNS (as <name>.local) and
+// DNS-SD (as an _http._tcp service, or _https._tcp with TLS), so that the
+// devices can reach it by name when the IPs change.
+type Advertiser struct {
+	port int
+	tls  bool
+
+	mu       sync.RWMutex
+	hostname string
+	ips      []net.IP
+	service  *mdns.MDNSService
+	server   *mdns.Server
+	stop     chan struct{}
+}
+
+// Advertise creates an advertiser that starts together with the app web
+// server and stops on app termination. Advertising failures are logged and
+// don't prevent the server from starting.
+func Advertise(app core.App, hostname string, port int, tls bool) *Advertiser {
+	a := &Advertiser{
+		hostname: hostname,
+		port:     port,
+		tls:      tls,
+	}
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		if err := a.start(); err != nil {
+			log.Printf("Warning: Failed to advertise %s: %v", LocalHostname(a.Hostname()), err)
+		}
+		return nil
+	})
+
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		a.shutdown()
+		return nil
+	})
+
+	return a
+}
+
+// Hostname returns the advertised name, without the ".local" suffix.
+func (a *Advertiser) Hostname() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.hostname
+}
+
+// SetHostname renames the advertised server.
+func (a *Advertiser) SetHostname(name string) error {
+	if err := ValidateHostname(name); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.hostname = name
+
+	return a.rebuild()
+}
+
+// Records implements mdns.Zone.
+func (a *Advertiser) Records(q dns.Question) []dns.RR {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.service == nil {
+		return nil
+	}
+
+	return a.service.Records(q)
+}
+
+// start listens for mDNS queries and keeps the advertised IPs in line with
+// the LAN addresses.
+func (a *Advertiser) start() error {
+	if err := ValidateHostname(a.Hostname()); err != nil {
+		return err
+	}
+
+	server, err := mdns.NewServer(&mdns.Config{Zone: a})
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+
+	a.mu.Lock()
+	a.server = server
+	a.stop = stop
+	a.mu.Unlock()
+
+	go WatchAddresses(10*time.Second, stop, a.setAddresses)
+
+	log.Printf("Advertising the server as %s", LocalHostname(a.Hostname()))
+
+	return nil
+}
+
+// shutdown stops answering the mDNS queries.
+func (a *Advertiser) shutdown() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.server == nil {
+		return
+	}
+
+	close(a.stop)
+	a.server.Shutdown()
+	a.server = nil
+	a.service = nil
+}
+
+// setAddresses advertises the server at addresses.
+func (a *Advertiser) setAddresses(addresses []Address) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.ips = make([]net.IP, 0, len(addresses))
+	for _, address := range addresses {
+		a.ips = append(a.ips, address.IP)
+	}
+
+	if err := a.rebuild(); err != nil {
+		log.Printf("Warning: Failed to advertise %s: %v", LocalHostname(a.hostname), err)
+	}
+}
+
+// rebuild recreates the advertised service from the current name and IPs.
+// It must be called with the lock held.
+func (a *Advertiser) rebuild() error {
+	if len(a.ips) == 0 {
+		// not connected to any network
+		a.service = nil
+		return nil
+	}
+
+	serviceType := "_http._tcp"
+	if a.tls {
+		serviceType = "_https._tcp"
+	}
+
+	service, err := mdns.NewMDNSService(
+		a.hostname,
+		serviceType,
+		"",
+		LocalHostname(a.hostname)+".",
+		a.port,
+		a.ips,
+		[]string{"path=/"},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create the mDNS service: %w", err)
+	}
+
+	a.service = service
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package network
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// CACertificatePath is the route the local CA certificate is downloaded
+// from, to install it on the devices.
+const CACertificatePath = "/api/meds/ca.crt"
+
+// RegisterCARoute serves the certificate of the local CA stored in dir,
+// which must already exist (eg. created by LoadOrCreateCertificate). The
+// certificate is loaded once, the route is public since the devices can't
+// sign in before they trust the server.
+func RegisterCARoute(app core.App, dir string) error {
+	ca, err := LoadCA(dir)
+	if err != nil {
+		return err
+	}
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		e.Router.GET(CACertificatePath, func(c echo.Context) error {
+			c.Response().Header().Set("Content-Disposition", `attachment; filename="meds-ca.crt"`)
+
+			return c.Blob(http.StatusOK, "application/x-x509-ca-cert", ca.PEM)
+		}, apis.ActivityLogger(app))
+
+		return nil
+	})
+
+	return nil
+}
+
+// CACertificateURL returns the URL the devices download the CA certificate
+// from at host.
+func (c Config) CACertificateURL(host string) string {
+	return c.URL(host) + CACertificatePath
+}
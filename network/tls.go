@@ -0,0 +1,143 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	certificateFile = "server.crt"
+	keyFile         = "server.key"
+
+	// certificateValidity stays below the 398 days accepted by browsers
+	certificateValidity = 397 * 24 * time.Hour
+
+	// certificateRenewal is how long before its expiry a certificate is
+	// replaced
+	certificateRenewal = 30 * 24 * time.Hour
+)
+
+// LoadOrCreateCertificate returns the server certificate stored in dir,
+// issued by the local CA of the same dir. A new one is issued when there is
+// none yet, when it is about to expire, when it doesn't cover all the hosts
+// (names or IPs) or when it wasn't issued by the current CA.
+func LoadOrCreateCertificate(dir string, hosts []string) (tls.Certificate, error) {
+	ca, err := LoadOrCreateCA(dir)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certPath := filepath.Join(dir, certificateFile)
+	keyPath := filepath.Join(dir, keyFile)
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil && covers(cert, ca, hosts) {
+		return cert, nil
+	}
+
+	certPEM, keyPEM, err := ca.issueCertificate(hosts)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// CertificateHosts returns the names and IPs the server certificate of c
+// must cover.
+func (c Config) CertificateHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1"}
+
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+
+	if c.Hostname != "" {
+		hosts = append(hosts, LocalHostname(c.Hostname))
+	}
+
+	addresses, _ := c.DeviceAddresses()
+	for _, address := range addresses {
+		hosts = append(hosts, address.IP.String())
+	}
+
+	return hosts
+}
+
+// covers reports whether cert was issued by ca, is still valid for a while
+// and covers hosts.
+func covers(cert tls.Certificate, ca *CA, hosts []string) bool {
+	if len(cert.Certificate) == 0 {
+		return false
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false
+	}
+
+	if !ca.Signed(leaf) || time.Now().Add(certificateRenewal).After(leaf.NotAfter) {
+		return false
+	}
+
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// issueCertificate creates a PEM encoded server certificate and key for
+// hosts, signed by the CA.
+func (ca *CA) issueCertificate(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"MEDS"}, CommonName: "MEDS local server"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encodePair(der, key)
+}
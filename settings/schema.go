@@ -0,0 +1,148 @@
+package settings
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// SchemaJSON is the JSON schema of the settings JSON fields.
+//
+//go:embed schema.json
+var SchemaJSON []byte
+
+// schemaNode is the subset of JSON schema used by schema.json.
+type schemaNode struct {
+	Type                 string                 `json:"type"`
+	Properties           map[string]*schemaNode `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Enum                 []any                  `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+}
+
+var rootSchema = mustParseSchema(SchemaJSON)
+
+func mustParseSchema(raw []byte) *schemaNode {
+	node := &schemaNode{}
+	if err := json.Unmarshal(raw, node); err != nil {
+		panic(fmt.Sprintf("invalid settings schema: %v", err))
+	}
+
+	return node
+}
+
+// validateField checks the raw JSON value of a settings field against its
+// schema and returns the problems found, one per invalid value.
+func validateField(field string, raw []byte) []string {
+	node := rootSchema.Properties[field]
+	if node == nil {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return []string{"must be valid JSON"}
+	}
+
+	problems := []string{}
+	node.validate("", value, &problems)
+
+	return problems
+}
+
+// validate appends the problems of value at path to problems.
+func (n *schemaNode) validate(path string, value any, problems *[]string) {
+	fail := func(format string, args ...any) {
+		message := fmt.Sprintf(format, args...)
+		if path != "" {
+			message = path + " " + message
+		}
+		*problems = append(*problems, message)
+	}
+
+	switch n.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			return
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			child := n.Properties[key]
+			if child == nil {
+				if n.AdditionalProperties != nil && !*n.AdditionalProperties {
+					fail("has unknown key %q", key)
+				}
+				continue
+			}
+			child.validate(joinPath(path, key), object[key], problems)
+		}
+
+		return
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be true or false")
+			return
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			fail("must be a string")
+			return
+		}
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+
+		f, err := number.Float64()
+		if err != nil || (n.Type == "integer" && f != math.Trunc(f)) {
+			fail("must be a whole number")
+			return
+		}
+		if n.Minimum != nil && f < *n.Minimum {
+			fail("must be at least %g", *n.Minimum)
+		}
+		if n.Maximum != nil && f > *n.Maximum {
+			fail("must be at most %g", *n.Maximum)
+		}
+	}
+
+	if len(n.Enum) > 0 {
+		for _, allowed := range n.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				return
+			}
+		}
+
+		options := make([]string, len(n.Enum))
+		for i, allowed := range n.Enum {
+			options[i] = fmt.Sprint(allowed)
+		}
+		fail("must be one of %s", strings.Join(options, ", "))
+	}
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
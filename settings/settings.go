@@ -0,0 +1,171 @@
+// Package settings gives the server code typed access to the clinic
+// settings stored in the single record of the settings collection.
+//
+// The JSON fields (unit_display and display_preferences) are checked
+// against schema.json on every save and completed with the defaults, so
+// the rest of the server can rely on their shape.
+package settings
+
+import (
+	"encoding/json"
+	"time"
+	_ "time/tzdata" // the clinic timezone must resolve on hosts without a zoneinfo database
+
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// CollectionName is the name of the settings collection.
+const CollectionName = "settings"
+
+// DefaultLockTimeout is used when the settings don't define
+// encounter_lock_timeout.
+const DefaultLockTimeout = 10 * time.Minute
+
+// UnitDisplay are the units the vitals are shown in.
+type UnitDisplay struct {
+	Height      string `json:"height"`
+	Weight      string `json:"weight"`
+	Temperature string `json:"temperature"`
+}
+
+// DisplayPreferences are the workflow options of the clinic.
+type DisplayPreferences struct {
+	ShowPriorityDropdown bool `json:"show_priority_dropdown"`
+
+	// ShowCareTeamAssignment offers the active records of the care_teams
+	// collection in the queue.
+	ShowCareTeamAssignment bool `json:"show_care_team_assignment"`
+
+	ShowMoveToCheckout bool `json:"show_move_to_checkout"`
+
+	// UnifiedRoles lets providers and pharmacy share their permissions.
+	UnifiedRoles bool `json:"unified_roles"`
+
+	// OverrideFieldRestrictions lets admins write every field.
+	OverrideFieldRestrictions bool `json:"override_field_restrictions"`
+
+	// OverrideFieldRestrictionsAllRoles extends the override to every
+	// role. It is cleared while OverrideFieldRestrictions is off.
+	OverrideFieldRestrictionsAllRoles bool `json:"override_field_restrictions_all_roles"`
+}
+
+// Settings is the typed content of the settings record.
+type Settings struct {
+	Id                 string             `json:"id"`
+	UnitDisplay        UnitDisplay        `json:"unit_display"`
+	DisplayPreferences DisplayPreferences `json:"display_preferences"`
+	ClinicTimezone     string             `json:"clinic_timezone"`
+
+	// EncounterLockTimeout is in minutes, 0 uses DefaultLockTimeout.
+	EncounterLockTimeout float64 `json:"encounter_lock_timeout"`
+
+	LastUpdated types.DateTime `json:"last_updated"`
+	UpdatedBy   string         `json:"updated_by"`
+}
+
+// DefaultUnitDisplay returns the units of a new installation.
+func DefaultUnitDisplay() UnitDisplay {
+	return UnitDisplay{
+		Height:      "cm",
+		Weight:      "kg",
+		Temperature: "F",
+	}
+}
+
+// DefaultDisplayPreferences returns the workflow options of a new
+// installation. frontend/src/services/settingsService.ts uses the same
+// values until the settings are loaded.
+func DefaultDisplayPreferences() DisplayPreferences {
+	return DisplayPreferences{
+		ShowMoveToCheckout: true,
+	}
+}
+
+// Defaults returns the settings of a new installation.
+func Defaults() Settings {
+	return Settings{
+		UnitDisplay:        DefaultUnitDisplay(),
+		DisplayPreferences: DefaultDisplayPreferences(),
+	}
+}
+
+// Location returns the timezone that defines the clinic day. It falls
+// back to the server timezone when the settings don't name a valid one.
+func (s Settings) Location() *time.Location {
+	if s.ClinicTimezone == "" {
+		return time.Local
+	}
+
+	location, err := time.LoadLocation(s.ClinicTimezone)
+	if err != nil {
+		return time.Local
+	}
+
+	return location
+}
+
+// LockTimeout returns the idle period after which encounter locks expire.
+func (s Settings) LockTimeout() time.Duration {
+	if s.EncounterLockTimeout <= 0 {
+		return DefaultLockTimeout
+	}
+
+	return time.Duration(s.EncounterLockTimeout * float64(time.Minute))
+}
+
+// FromRecord decodes a settings record. Missing or invalid JSON values
+// keep their defaults.
+func FromRecord(record *models.Record) Settings {
+	s := Defaults()
+	s.Id = record.Id
+	s.ClinicTimezone = record.GetString("clinic_timezone")
+	s.EncounterLockTimeout = record.GetFloat("encounter_lock_timeout")
+	s.LastUpdated = record.GetDateTime("last_updated")
+	s.UpdatedBy = record.GetString("updated_by")
+
+	decodeJSON(record, "unit_display", &s.UnitDisplay)
+	decodeJSON(record, "display_preferences", &s.DisplayPreferences)
+
+	return s
+}
+
+// decodeJSON decodes the JSON field of record over target, leaving target
+// untouched when the value can't be decoded.
+func decodeJSON[T any](record *models.Record, field string, target *T) {
+	raw, ok := record.Get(field).(types.JsonRaw)
+	if !ok || len(raw) == 0 {
+		return
+	}
+
+	decoded := *target
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return
+	}
+
+	*target = decoded
+}
+
+// Record returns the settings record: the oldest one should there be more
+// than one. It returns nil when there is none.
+func Record(dao *daos.Dao) (*models.Record, error) {
+	records, err := dao.FindRecordsByFilter(CollectionName, "id != ''", "created", 1, 0)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+
+	return records[0], nil
+}
+
+// Load reads the settings with dao, bypassing the cache (eg. to see the
+// changes of a running transaction). It returns the defaults when there is
+// no settings record.
+func Load(dao *daos.Dao) Settings {
+	record, err := Record(dao)
+	if err != nil || record == nil {
+		return Defaults()
+	}
+
+	return FromRecord(record)
+}
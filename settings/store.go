@@ -0,0 +1,246 @@
+package settings
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Errors of the single record guarantee.
+var (
+	ErrAlreadyExists = errors.New("the settings already exist, update them instead")
+	ErrDelete        = errors.New("the settings cannot be deleted")
+)
+
+// stores holds the Store registered for each app.
+var stores sync.Map
+
+// Store caches the settings of an app and reports their changes.
+type Store struct {
+	app core.App
+
+	mu          sync.RWMutex
+	cached      *Settings
+	subscribers map[int]func(Settings)
+	nextId      int
+}
+
+// Register validates and completes the settings on every save, keeps the
+// settings collection to a single record and returns the store caching it.
+// It must be called before the app is started.
+func Register(app core.App) *Store {
+	s := &Store{app: app, subscribers: map[int]func(Settings){}}
+	stores.Store(app, s)
+
+	app.OnModelBeforeCreate(CollectionName).Add(func(e *core.ModelEvent) error {
+		record, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		existing, err := Record(e.Dao)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return ErrAlreadyExists
+		}
+
+		return normalize(record)
+	})
+
+	app.OnModelBeforeUpdate(CollectionName).Add(func(e *core.ModelEvent) error {
+		record, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		return normalize(record)
+	})
+
+	app.OnModelBeforeDelete(CollectionName).Add(func(e *core.ModelEvent) error {
+		return ErrDelete
+	})
+
+	changed := func(e *core.ModelEvent) error {
+		if record, ok := e.Model.(*models.Record); ok {
+			s.set(FromRecord(record))
+		}
+		return nil
+	}
+	app.OnModelAfterCreate(CollectionName).Add(changed)
+	app.OnModelAfterUpdate(CollectionName).Add(changed)
+
+	// the API reports the single record errors as bad requests
+	app.OnRecordBeforeCreateRequest(CollectionName).Add(func(e *core.RecordCreateEvent) error {
+		if existing, _ := Record(app.Dao()); existing != nil {
+			return apis.NewBadRequestError("The settings already exist, update them instead.", nil)
+		}
+		return nil
+	})
+	app.OnRecordBeforeDeleteRequest(CollectionName).Add(func(e *core.RecordDeleteEvent) error {
+		return apis.NewBadRequestError("The settings cannot be deleted.", nil)
+	})
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		current, err := s.ensure()
+		if err != nil {
+			return err
+		}
+		s.set(current)
+
+		e.Router.GET("/api/meds/settings/schema", func(c echo.Context) error {
+			return c.JSONBlob(http.StatusOK, SchemaJSON)
+		}, apis.ActivityLogger(app), apis.RequireAdminOrRecordAuth("users"))
+
+		return nil
+	})
+
+	return s
+}
+
+// Current returns the cached settings of app. Apps without a registered
+// store read the settings record on every call.
+func Current(app core.App) Settings {
+	if s, ok := stores.Load(app); ok {
+		return s.(*Store).Get()
+	}
+
+	return Load(app.Dao())
+}
+
+// Get returns the cached settings, loading them on first use.
+func (s *Store) Get() Settings {
+	s.mu.RLock()
+	cached := s.cached
+	s.mu.RUnlock()
+
+	if cached != nil {
+		return *cached
+	}
+
+	current := Load(s.app.Dao())
+
+	s.mu.Lock()
+	if s.cached == nil {
+		s.cached = &current
+	}
+	s.mu.Unlock()
+
+	return current
+}
+
+// Subscribe calls fn with the settings once the app starts serving and
+// after every change, until the returned function is called. fn runs in
+// the goroutine that saved the settings and must not block.
+func (s *Store) Subscribe(fn func(Settings)) (unsubscribe func()) {
+	s.mu.Lock()
+	id := s.nextId
+	s.nextId++
+	s.subscribers[id] = fn
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		delete(s.subscribers, id)
+		s.mu.Unlock()
+	}
+}
+
+// set caches current and reports it to the subscribers.
+func (s *Store) set(current Settings) {
+	s.mu.Lock()
+	s.cached = &current
+	subscribers := make([]func(Settings), 0, len(s.subscribers))
+	for _, fn := range s.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	s.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(current)
+	}
+}
+
+// ensure creates the settings record with the defaults when there is none.
+func (s *Store) ensure() (Settings, error) {
+	var current Settings
+
+	err := s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		record, err := Record(txDao)
+		if err != nil {
+			return err
+		}
+
+		if record == nil {
+			collection, err := txDao.FindCollectionByNameOrId(CollectionName)
+			if err != nil {
+				return err
+			}
+
+			// updated_by is set by the first-run setup
+			defaults := Defaults()
+			record = models.NewRecord(collection)
+			record.Set("unit_display", defaults.UnitDisplay)
+			record.Set("display_preferences", defaults.DisplayPreferences)
+			record.Set("last_updated", types.NowDateTime())
+
+			if err := txDao.SaveRecord(record); err != nil {
+				return err
+			}
+
+			log.Printf("Created the default settings")
+		}
+
+		current = FromRecord(record)
+
+		return nil
+	})
+
+	return current, err
+}
+
+// normalize validates the JSON fields of a settings record against the
+// schema and completes them with the defaults.
+func normalize(record *models.Record) error {
+	current := FromRecord(record)
+	problems := validation.Errors{}
+
+	for _, field := range []string{"unit_display", "display_preferences"} {
+		raw, _ := record.Get(field).(types.JsonRaw)
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+
+		if messages := validateField(field, raw); len(messages) > 0 {
+			problems[field] = validation.NewError(
+				"validation_invalid_settings",
+				strings.Join(messages, ", ")+".",
+			)
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	// the all roles override only applies together with the admin one
+	if !current.DisplayPreferences.OverrideFieldRestrictions {
+		current.DisplayPreferences.OverrideFieldRestrictionsAllRoles = false
+	}
+
+	record.Set("unit_display", current.UnitDisplay)
+	record.Set("display_preferences", current.DisplayPreferences)
+
+	return nil
+}
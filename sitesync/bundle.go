@@ -0,0 +1,113 @@
+package sitesync
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pocketbase/pocketbase/daos"
+)
+
+// BundleFormat is the version of the bundle layout written by WriteBundle.
+const BundleFormat = 1
+
+// maxBundleLine limits the size of a single bundle line (one record).
+const maxBundleLine = 16 << 20
+
+// bundleHeader is the first line of a bundle.
+type bundleHeader struct {
+	Format    int       `json:"format"`
+	Site      string    `json:"site"`
+	Name      string    `json:"name"`
+	Since     int64     `json:"since"`
+	Seq       int64     `json:"seq"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WriteBundle writes the changes recorded after the sequence number since
+// to w, for sites that can't be reached over the network.
+//
+// A bundle is a gzip compressed JSON lines file: a header line followed by
+// one line per Change.
+func WriteBundle(dao *daos.Dao, w io.Writer, since int64) (*Batch, error) {
+	batch, err := Changes(dao, since)
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	encoder := json.NewEncoder(gz)
+
+	header := bundleHeader{
+		Format:    BundleFormat,
+		Site:      batch.Site,
+		Name:      batch.Name,
+		Since:     batch.Since,
+		Seq:       batch.Seq,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := encoder.Encode(header); err != nil {
+		return nil, err
+	}
+
+	for _, change := range batch.Changes {
+		if err := encoder.Encode(change); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+// ReadBundle reads the changes of a bundle written by WriteBundle.
+func ReadBundle(r io.Reader) (*Batch, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.New("the file is not a MEDS sync bundle")
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBundleLine)
+
+	if !scanner.Scan() {
+		return nil, errors.New("the sync bundle is empty")
+	}
+
+	header := bundleHeader{}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Site == "" {
+		return nil, errors.New("the file is not a MEDS sync bundle")
+	}
+	if header.Format < 1 || header.Format > BundleFormat {
+		return nil, fmt.Errorf("unsupported sync bundle format %d", header.Format)
+	}
+
+	batch := &Batch{
+		Site:    header.Site,
+		Name:    header.Name,
+		Since:   header.Since,
+		Seq:     header.Seq,
+		Changes: []Change{},
+	}
+
+	for scanner.Scan() {
+		change := Change{}
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			return nil, fmt.Errorf("invalid sync bundle line %d: %w", len(batch.Changes)+2, err)
+		}
+		batch.Changes = append(batch.Changes, change)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the sync bundle: %w", err)
+	}
+
+	return batch, nil
+}
@@ -0,0 +1,471 @@
+package sitesync
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"medical-records/hooks"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/list"
+	"github.com/spf13/cast"
+)
+
+// Change is the latest version of a synced record.
+type Change struct {
+	Collection string         `json:"collection"`
+	Record     string         `json:"record"`
+	Vector     Vector         `json:"vector"`
+	Deleted    bool           `json:"deleted,omitempty"`
+	Data       map[string]any `json:"data,omitempty"`
+}
+
+// Batch holds the changes a site made (or received) after the sequence
+// number Since, up to and including Seq.
+type Batch struct {
+	Site    string   `json:"site"`
+	Name    string   `json:"name"`
+	Since   int64    `json:"since"`
+	Seq     int64    `json:"seq"`
+	Changes []Change `json:"changes"`
+}
+
+// Report summarizes the outcome of applying a Batch.
+type Report struct {
+	Site      string   `json:"site"`
+	Applied   int      `json:"applied"`
+	Skipped   int      `json:"skipped"`
+	Matched   int      `json:"matched"`
+	Conflicts int      `json:"conflicts"`
+	Failed    []string `json:"failed,omitempty"`
+}
+
+// version is the sync_versions row of a record.
+type version struct {
+	Vector  Vector
+	Seq     int64
+	Deleted bool
+}
+
+// loadVersion returns the version of a record or nil if it has none.
+func loadVersion(dao *daos.Dao, collection string, id string) (*version, error) {
+	row := struct {
+		Vector  string `db:"vector"`
+		Seq     int64  `db:"seq"`
+		Deleted bool   `db:"deleted"`
+	}{}
+
+	err := dao.DB().
+		Select("vector", "seq", "deleted").
+		From("sync_versions").
+		Where(dbx.HashExp{"collection": collection, "record": id}).
+		One(&row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v := &version{Seq: row.Seq, Deleted: row.Deleted, Vector: Vector{}}
+	if err := json.Unmarshal([]byte(row.Vector), &v.Vector); err != nil {
+		return nil, fmt.Errorf("invalid version vector of %s/%s: %w", collection, id, err)
+	}
+
+	return v, nil
+}
+
+// saveVersion stores the version vector of a record under the next local
+// change sequence number, so that it is sent to the other sites again.
+func saveVersion(dao *daos.Dao, collection string, id string, vector Vector, deleted bool) error {
+	raw, err := json.Marshal(vector)
+	if err != nil {
+		return err
+	}
+
+	// all writes go through the single write connection, so MAX + 1 can't
+	// hand out the same number twice
+	_, err = dao.DB().NewQuery(`
+		INSERT INTO sync_versions (collection, record, vector, deleted, seq)
+		VALUES ({:collection}, {:record}, {:vector}, {:deleted}, (
+			SELECT COALESCE(MAX(seq), 0) + 1 FROM sync_versions
+		))
+		ON CONFLICT(collection, record) DO UPDATE SET
+			vector  = excluded.vector,
+			deleted = excluded.deleted,
+			seq     = excluded.seq
+	`).Bind(dbx.Params{
+		"collection": collection,
+		"record":     id,
+		"vector":     string(raw),
+		"deleted":    deleted,
+	}).Execute()
+
+	return err
+}
+
+// Changes returns the changes recorded after the sequence number since.
+func Changes(dao *daos.Dao, since int64) (*Batch, error) {
+	site, err := LoadSite(dao)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &Batch{Site: site.Id, Name: site.Name, Since: since, Seq: since, Changes: []Change{}}
+
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		rows := []struct {
+			Collection string `db:"collection"`
+			Record     string `db:"record"`
+			Vector     string `db:"vector"`
+			Seq        int64  `db:"seq"`
+			Deleted    bool   `db:"deleted"`
+		}{}
+
+		err := txDao.DB().
+			Select("collection", "record", "vector", "seq", "deleted").
+			From("sync_versions").
+			Where(dbx.NewExp("seq > {:since}", dbx.Params{"since": since})).
+			OrderBy("seq").
+			All(&rows)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			batch.Seq = max(batch.Seq, row.Seq)
+
+			spec, ok := findSpec(row.Collection)
+			if !ok {
+				continue
+			}
+
+			change := Change{
+				Collection: row.Collection,
+				Record:     row.Record,
+				Vector:     Vector{},
+				Deleted:    row.Deleted,
+			}
+			if err := json.Unmarshal([]byte(row.Vector), &change.Vector); err != nil {
+				return fmt.Errorf("invalid version vector of %s/%s: %w", row.Collection, row.Record, err)
+			}
+
+			if !change.Deleted {
+				record, err := txDao.FindRecordById(row.Collection, row.Record)
+				if err != nil {
+					continue
+				}
+				change.Data = exportData(spec, record)
+			}
+
+			batch.Changes = append(batch.Changes, change)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+// Apply merges the changes of another site into the local records.
+//
+// Newer remote versions replace the local records, older ones are skipped.
+// Concurrent versions keep the local record and are stored as conflicts.
+// Changes that fail (eg. the delete of a record that is still referenced)
+// are rolled back on their own, reported and sent again by the next
+// exchange.
+func Apply(dao *daos.Dao, batch *Batch) (*Report, error) {
+	site, err := LoadSite(dao)
+	if err != nil {
+		return nil, err
+	}
+
+	if batch.Site == "" {
+		return nil, errors.New("the changes have no site")
+	}
+	if batch.Site == site.Id {
+		return nil, errors.New("the changes come from this site")
+	}
+
+	report := &Report{Site: batch.Site}
+
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		for _, change := range applyOrder(batch.Changes) {
+			// every change gets a savepoint, so a change that fails halfway
+			// (eg. after writing the record) leaves nothing behind
+			if _, err := txDao.DB().NewQuery("SAVEPOINT sync_change").Execute(); err != nil {
+				return err
+			}
+
+			counts := *report
+			if err := applyChange(txDao, site, batch.Site, change, report); err != nil {
+				*report = counts
+				report.Failed = append(report.Failed, fmt.Sprintf("%s/%s: %v", change.Collection, change.Record, err))
+
+				if _, err := txDao.DB().NewQuery("ROLLBACK TO sync_change").Execute(); err != nil {
+					return err
+				}
+			}
+
+			if _, err := txDao.DB().NewQuery("RELEASE sync_change").Execute(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// applyOrder sorts changes so that the referenced records are written
+// before the records referencing them and deleted after them.
+func applyOrder(changes []Change) []Change {
+	position := map[string]int{}
+	for i, spec := range collections {
+		position[spec.Name] = i
+	}
+
+	sorted := make([]Change, len(changes))
+	copy(sorted, changes)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Deleted != b.Deleted {
+			return !a.Deleted
+		}
+		if a.Deleted {
+			return position[a.Collection] > position[b.Collection]
+		}
+		return position[a.Collection] < position[b.Collection]
+	})
+
+	return sorted
+}
+
+// applyChange merges a single remote change.
+func applyChange(dao *daos.Dao, site *Site, remoteSite string, change Change, report *Report) error {
+	spec, ok := findSpec(change.Collection)
+	if !ok {
+		report.Skipped++
+		return nil
+	}
+
+	// records matched to a local record keep the local fields
+	if _, ok, err := findAlias(dao, change.Collection, change.Record); err != nil || ok {
+		report.Skipped++
+		return err
+	}
+
+	current, err := loadVersion(dao, change.Collection, change.Record)
+	if err != nil {
+		return err
+	}
+
+	if current == nil {
+		if !change.Deleted && len(spec.Match) > 0 {
+			local, err := findMatch(dao, spec, change.Data)
+			if err != nil {
+				return err
+			}
+			if local != "" {
+				report.Matched++
+				return saveAlias(dao, change.Collection, change.Record, local)
+			}
+		}
+
+		if err := writeChange(dao, spec, remoteSite, change); err != nil {
+			return err
+		}
+		report.Applied++
+
+		return saveVersion(dao, change.Collection, change.Record, change.Vector, change.Deleted)
+	}
+
+	switch change.Vector.Compare(current.Vector) {
+	case After:
+		if err := writeChange(dao, spec, remoteSite, change); err != nil {
+			return err
+		}
+		report.Applied++
+
+		return saveVersion(dao, change.Collection, change.Record, change.Vector, change.Deleted)
+	case Concurrent:
+		merged := change.Vector.Merge(current.Vector)
+
+		if change.Deleted && current.Deleted {
+			report.Skipped++
+			return saveVersion(dao, change.Collection, change.Record, merged, true)
+		}
+
+		if err := saveConflict(dao, spec, remoteSite, change, current); err != nil {
+			return err
+		}
+		report.Conflicts++
+
+		// the local version now includes the remote one, so the other
+		// site takes it over on the next exchange
+		return saveVersion(dao, change.Collection, change.Record, merged.Increment(site.Id), current.Deleted)
+	default:
+		report.Skipped++
+		return nil
+	}
+}
+
+// writeChange creates, updates or deletes the local record of change
+// received from remoteSite.
+// The record hooks are skipped, they already ran on the site that made the
+// change (and would draw the local stock for the remote disbursements and
+// track the change as a local one). The change is audited with the remote
+// site as the actor instead.
+func writeChange(dao *daos.Dao, spec collectionSpec, remoteSite string, change Change) error {
+	record, findErr := dao.FindRecordById(change.Collection, change.Record)
+
+	if change.Deleted {
+		if findErr != nil {
+			return nil
+		}
+		if err := dao.WithoutHooks().DeleteRecord(record); err != nil {
+			return err
+		}
+		return hooks.AuditSyncedChange(dao, hooks.AuditDelete, record, remoteSite)
+	}
+
+	action := hooks.AuditUpdate
+	if findErr != nil {
+		action = hooks.AuditCreate
+
+		collection, err := dao.FindCollectionByNameOrId(change.Collection)
+		if err != nil {
+			return err
+		}
+
+		record = models.NewRecord(collection)
+		record.SetId(change.Record)
+		record.MarkAsNew()
+		if created, ok := change.Data["created"]; ok {
+			record.Set(schema.FieldNameCreated, created)
+		}
+	}
+
+	for _, name := range exchangedFields(spec, record.Collection()) {
+		value, ok := change.Data[name]
+		if !ok {
+			continue
+		}
+
+		if field := record.Collection().Schema.GetFieldByName(name); field.Type == schema.FieldTypeRelation {
+			translated, err := translateRelation(dao, field, value)
+			if err != nil {
+				return err
+			}
+			value = translated
+		}
+
+		record.Set(name, value)
+	}
+
+	if err := dao.WithoutHooks().SaveRecord(record); err != nil {
+		return err
+	}
+
+	return hooks.AuditSyncedChange(dao, action, record, remoteSite)
+}
+
+// translateRelation replaces the remote ids of a relation value by the ids
+// of the local records they were matched to.
+func translateRelation(dao *daos.Dao, field *schema.SchemaField, value any) (any, error) {
+	options, _ := field.Options.(*schema.RelationOptions)
+	if options == nil {
+		return value, nil
+	}
+
+	target, err := dao.FindCollectionByNameOrId(options.CollectionId)
+	if err != nil {
+		return value, nil
+	}
+
+	ids := list.ToUniqueStringSlice(value)
+	for i, id := range ids {
+		local, ok, err := findAlias(dao, target.Name, id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ids[i] = local
+		}
+	}
+
+	return ids, nil
+}
+
+// findMatch returns the id of the local record with the same Match field
+// values as data (if any).
+func findMatch(dao *daos.Dao, spec collectionSpec, data map[string]any) (string, error) {
+	collection, err := dao.FindCollectionByNameOrId(spec.Name)
+	if err != nil {
+		return "", err
+	}
+
+	exp := dbx.HashExp{}
+	for _, name := range spec.Match {
+		field := collection.Schema.GetFieldByName(name)
+		if field == nil {
+			return "", nil
+		}
+		exp[name] = cast.ToString(field.PrepareValue(data[name]))
+	}
+
+	records, err := dao.FindRecordsByExpr(spec.Name, exp)
+	if err != nil || len(records) == 0 {
+		return "", err
+	}
+
+	return records[0].Id, nil
+}
+
+// findAlias returns the local record a remote record was matched to.
+func findAlias(dao *daos.Dao, collection string, remote string) (string, bool, error) {
+	var local string
+
+	err := dao.DB().
+		Select("local").
+		From("sync_aliases").
+		Where(dbx.HashExp{"collection": collection, "remote": remote}).
+		Row(&local)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return local, true, nil
+}
+
+// saveAlias matches a remote record to a local one.
+func saveAlias(dao *daos.Dao, collection string, remote string, local string) error {
+	_, err := dao.DB().NewQuery(`
+		INSERT INTO sync_aliases (collection, remote, local)
+		VALUES ({:collection}, {:remote}, {:local})
+		ON CONFLICT(collection, remote) DO UPDATE SET local = excluded.local
+	`).Bind(dbx.Params{
+		"collection": collection,
+		"remote":     remote,
+		"local":      local,
+	}).Execute()
+
+	return err
+}
@@ -0,0 +1,269 @@
+package sitesync
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Conflict resolutions.
+const (
+	KeepLocal  = "local"
+	KeepRemote = "remote"
+)
+
+// Conflict resolution errors.
+var (
+	ErrConflictNotFound = errors.New("the conflict does not exist")
+	ErrConflictResolved = errors.New("the conflict was already resolved")
+)
+
+// Conflict is a record that was changed independently on two sites.
+type Conflict struct {
+	Id            string         `json:"id"`
+	Collection    string         `json:"collection"`
+	Record        string         `json:"record"`
+	Site          string         `json:"site"`
+	LocalVector   Vector         `json:"local_vector"`
+	RemoteVector  Vector         `json:"remote_vector"`
+	LocalData     map[string]any `json:"local_data"`
+	RemoteData    map[string]any `json:"remote_data"`
+	LocalDeleted  bool           `json:"local_deleted"`
+	RemoteDeleted bool           `json:"remote_deleted"`
+	Resolution    string         `json:"resolution"`
+	Created       string         `json:"created"`
+	Resolved      string         `json:"resolved"`
+}
+
+// conflictRow is the sync_conflicts row of a Conflict.
+type conflictRow struct {
+	Id            string `db:"id"`
+	Collection    string `db:"collection"`
+	Record        string `db:"record"`
+	Site          string `db:"site"`
+	LocalVector   string `db:"local_vector"`
+	RemoteVector  string `db:"remote_vector"`
+	LocalData     string `db:"local_data"`
+	RemoteData    string `db:"remote_data"`
+	LocalDeleted  bool   `db:"local_deleted"`
+	RemoteDeleted bool   `db:"remote_deleted"`
+	Resolution    string `db:"resolution"`
+	Created       string `db:"created"`
+	Resolved      string `db:"resolved"`
+}
+
+// conflict decodes the JSON columns of the row.
+func (row conflictRow) conflict() (*Conflict, error) {
+	c := &Conflict{
+		Id:            row.Id,
+		Collection:    row.Collection,
+		Record:        row.Record,
+		Site:          row.Site,
+		LocalDeleted:  row.LocalDeleted,
+		RemoteDeleted: row.RemoteDeleted,
+		Resolution:    row.Resolution,
+		Created:       row.Created,
+		Resolved:      row.Resolved,
+	}
+
+	columns := []struct {
+		raw    string
+		target any
+	}{
+		{row.LocalVector, &c.LocalVector},
+		{row.RemoteVector, &c.RemoteVector},
+		{row.LocalData, &c.LocalData},
+		{row.RemoteData, &c.RemoteData},
+	}
+	for _, column := range columns {
+		// deleted versions have no data
+		if column.raw == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(column.raw), column.target); err != nil {
+			return nil, fmt.Errorf("invalid conflict %s: %w", row.Id, err)
+		}
+	}
+
+	return c, nil
+}
+
+// saveConflict stores the remote change that conflicts with the current
+// local version of its record.
+func saveConflict(dao *daos.Dao, spec collectionSpec, remoteSite string, change Change, current *version) error {
+	row := conflictRow{
+		Id:            security.PseudorandomString(15),
+		Collection:    change.Collection,
+		Record:        change.Record,
+		Site:          remoteSite,
+		LocalDeleted:  current.Deleted,
+		RemoteDeleted: change.Deleted,
+		Created:       types.NowDateTime().String(),
+	}
+
+	if !current.Deleted {
+		if record, err := dao.FindRecordById(change.Collection, change.Record); err == nil {
+			raw, err := json.Marshal(exportData(spec, record))
+			if err != nil {
+				return err
+			}
+			row.LocalData = string(raw)
+		}
+	}
+
+	if !change.Deleted {
+		raw, err := json.Marshal(change.Data)
+		if err != nil {
+			return err
+		}
+		row.RemoteData = string(raw)
+	}
+
+	localVector, err := json.Marshal(current.Vector)
+	if err != nil {
+		return err
+	}
+	row.LocalVector = string(localVector)
+
+	remoteVector, err := json.Marshal(change.Vector)
+	if err != nil {
+		return err
+	}
+	row.RemoteVector = string(remoteVector)
+
+	_, err = dao.DB().Insert("sync_conflicts", dbx.Params{
+		"id":             row.Id,
+		"collection":     row.Collection,
+		"record":         row.Record,
+		"site":           row.Site,
+		"local_vector":   row.LocalVector,
+		"remote_vector":  row.RemoteVector,
+		"local_data":     row.LocalData,
+		"remote_data":    row.RemoteData,
+		"local_deleted":  row.LocalDeleted,
+		"remote_deleted": row.RemoteDeleted,
+		"created":        row.Created,
+	}).Execute()
+
+	return err
+}
+
+// Conflicts lists the conflicts, oldest first. Resolved conflicts are only
+// included when requested.
+func Conflicts(dao *daos.Dao, includeResolved bool) ([]*Conflict, error) {
+	query := dao.DB().Select("*").From("sync_conflicts").OrderBy("created", "rowid")
+	if !includeResolved {
+		query.Where(dbx.HashExp{"resolution": ""})
+	}
+
+	rows := []conflictRow{}
+	if err := query.All(&rows); err != nil {
+		return nil, err
+	}
+
+	result := make([]*Conflict, 0, len(rows))
+	for _, row := range rows {
+		c, err := row.conflict()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+
+	return result, nil
+}
+
+// ResolveConflict closes a conflict by keeping either the local version
+// (KeepLocal) or the remote version (KeepRemote) of the record. The kept
+// version is sent to the other sites as a new local change.
+func ResolveConflict(dao *daos.Dao, id string, keep string) (*Conflict, error) {
+	if keep != KeepLocal && keep != KeepRemote {
+		return nil, fmt.Errorf("unknown resolution %q", keep)
+	}
+
+	site, err := LoadSite(dao)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved *Conflict
+
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		row := conflictRow{}
+		err := txDao.DB().Select("*").From("sync_conflicts").Where(dbx.HashExp{"id": id}).One(&row)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrConflictNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		c, err := row.conflict()
+		if err != nil {
+			return err
+		}
+		if c.Resolution != "" {
+			return ErrConflictResolved
+		}
+
+		spec, ok := findSpec(c.Collection)
+		if !ok {
+			return fmt.Errorf("%s is not synced", c.Collection)
+		}
+
+		current, err := loadVersion(txDao, c.Collection, c.Record)
+		if err != nil {
+			return err
+		}
+
+		vector := c.LocalVector.Merge(c.RemoteVector)
+		deleted := c.LocalDeleted
+		if current != nil {
+			vector = vector.Merge(current.Vector)
+			deleted = current.Deleted
+		}
+
+		if keep == KeepRemote {
+			change := Change{
+				Collection: c.Collection,
+				Record:     c.Record,
+				Deleted:    c.RemoteDeleted,
+				Data:       c.RemoteData,
+			}
+			if err := writeChange(txDao, spec, c.Site, change); err != nil {
+				return err
+			}
+			deleted = c.RemoteDeleted
+		}
+
+		if err := saveVersion(txDao, c.Collection, c.Record, vector.Increment(site.Id), deleted); err != nil {
+			return err
+		}
+
+		c.Resolution = keep
+		c.Resolved = types.NowDateTime().String()
+
+		_, err = txDao.DB().Update("sync_conflicts", dbx.Params{
+			"resolution": c.Resolution,
+			"resolved":   c.Resolved,
+		}, dbx.HashExp{"id": c.Id}).Execute()
+		if err != nil {
+			return err
+		}
+
+		resolved = c
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,265 @@
+package sitesync
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// KeyHeader is the request header carrying the key of the site whose
+// change routes are called.
+const KeyHeader = "X-Meds-Sync-Key"
+
+// changesPath is the route the sites exchange their changes through.
+const changesPath = "/api/meds/sync/changes"
+
+// Peer is another site this site exchanged changes with.
+type Peer struct {
+	Site string `db:"site" json:"site"`
+	Name string `db:"name" json:"name"`
+
+	// URL and Key are only known for the peers this site pulls from.
+	URL string `db:"url" json:"url"`
+	Key string `db:"key" json:"-"`
+
+	// ReceivedSeq is the last change sequence number of the peer that was
+	// applied here, SentSeq the last local one the peer applied.
+	ReceivedSeq int64 `db:"received_seq" json:"received_seq"`
+	SentSeq     int64 `db:"sent_seq" json:"sent_seq"`
+
+	LastSync  string `db:"last_sync" json:"last_sync"`
+	LastError string `db:"last_error" json:"last_error"`
+}
+
+// Peers lists the known peers by name.
+func Peers(dao *daos.Dao) ([]*Peer, error) {
+	peers := []*Peer{}
+
+	err := dao.DB().Select("*").From("sync_peers").OrderBy("name", "site").All(&peers)
+
+	return peers, err
+}
+
+// findPeer returns the peer matching exp or nil if there is none.
+func findPeer(dao *daos.Dao, exp dbx.Expression) (*Peer, error) {
+	peer := &Peer{}
+
+	err := dao.DB().Select("*").From("sync_peers").Where(exp).Limit(1).One(peer)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return peer, nil
+}
+
+// savePeer inserts or updates peer.
+func savePeer(dao *daos.Dao, peer *Peer) error {
+	_, err := dao.DB().NewQuery(`
+		INSERT INTO sync_peers (site, name, url, key, received_seq, sent_seq, last_sync, last_error)
+		VALUES ({:site}, {:name}, {:url}, {:key}, {:received_seq}, {:sent_seq}, {:last_sync}, {:last_error})
+		ON CONFLICT(site) DO UPDATE SET
+			name         = excluded.name,
+			url          = excluded.url,
+			key          = excluded.key,
+			received_seq = excluded.received_seq,
+			sent_seq     = excluded.sent_seq,
+			last_sync    = excluded.last_sync,
+			last_error   = excluded.last_error
+	`).Bind(dbx.Params{
+		"site":         peer.Site,
+		"name":         peer.Name,
+		"url":          peer.URL,
+		"key":          peer.Key,
+		"received_seq": peer.ReceivedSeq,
+		"sent_seq":     peer.SentSeq,
+		"last_sync":    peer.LastSync,
+		"last_error":   peer.LastError,
+	}).Execute()
+
+	return err
+}
+
+// Received applies the changes another site sent (or that were read from
+// its bundle) and remembers how far the exchange with that site got.
+//
+// The received sequence number only moves forward when the batch continues
+// the changes received so far and all of them were applied, otherwise the
+// missing ones are sent again by the next exchange.
+func Received(dao *daos.Dao, batch *Batch) (*Report, error) {
+	report, err := Apply(dao, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := findPeer(dao, dbx.HashExp{"site": batch.Site})
+	if err != nil {
+		return nil, err
+	}
+	if peer == nil {
+		peer = &Peer{Site: batch.Site}
+	}
+
+	peer.Name = batch.Name
+	peer.LastSync = types.NowDateTime().String()
+	if len(report.Failed) == 0 && batch.Since <= peer.ReceivedSeq {
+		peer.ReceivedSeq = max(peer.ReceivedSeq, batch.Seq)
+	}
+
+	if err := savePeer(dao, peer); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// Exchange is the outcome of a sync with a peer.
+type Exchange struct {
+	Peer *Peer `json:"peer"`
+
+	// Received describes the peer changes applied here, Sent the local
+	// changes applied by the peer.
+	Received *Report `json:"received"`
+	Sent     *Report `json:"sent"`
+}
+
+// httpClient is used to reach the peers.
+var httpClient = &http.Client{Timeout: 2 * time.Minute}
+
+// SyncWith pulls the new changes of the site serving baseURL and then
+// pushes the local changes to it. key is the peer's site key.
+func SyncWith(dao *daos.Dao, baseURL string, key string) (*Exchange, error) {
+	baseURL = normalizeURL(baseURL)
+	if parsed, err := url.Parse(baseURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid peer address %q", baseURL)
+	}
+
+	known, err := findPeer(dao, dbx.HashExp{"url": baseURL})
+	if err != nil {
+		return nil, err
+	}
+
+	var since int64
+	if known != nil {
+		since = known.ReceivedSeq
+	}
+
+	incoming := &Batch{}
+	err = peerRequest(http.MethodGet, baseURL+changesPath+"?since="+strconv.FormatInt(since, 10), key, nil, incoming)
+	if err != nil {
+		if known != nil {
+			known.LastError = err.Error()
+			savePeer(dao, known)
+		}
+		return nil, err
+	}
+
+	received, err := Received(dao, incoming)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := findPeer(dao, dbx.HashExp{"site": incoming.Site})
+	if err != nil {
+		return nil, err
+	}
+	peer.URL = baseURL
+	peer.Key = key
+	peer.LastError = ""
+
+	exchange := &Exchange{Peer: peer, Received: received}
+
+	outgoing, err := Changes(dao, peer.SentSeq)
+	if err != nil {
+		return nil, err
+	}
+
+	sent := &Report{}
+	if err := peerRequest(http.MethodPost, baseURL+changesPath, key, outgoing, sent); err != nil {
+		peer.LastError = err.Error()
+	} else {
+		exchange.Sent = sent
+		if len(sent.Failed) == 0 {
+			peer.SentSeq = outgoing.Seq
+		}
+	}
+
+	if saveErr := savePeer(dao, peer); saveErr != nil {
+		return nil, saveErr
+	}
+	if peer.LastError != "" {
+		return exchange, errors.New(peer.LastError)
+	}
+
+	return exchange, nil
+}
+
+// findPeerByURL returns the peer reached at baseURL or nil if there is none.
+func findPeerByURL(app core.App, baseURL string) (*Peer, error) {
+	return findPeer(app.Dao(), dbx.HashExp{"url": normalizeURL(baseURL)})
+}
+
+// normalizeURL trims the spaces and the trailing slashes of a peer address.
+func normalizeURL(baseURL string) string {
+	return strings.TrimRight(strings.TrimSpace(baseURL), "/")
+}
+
+// peerRequest sends body (if any) as JSON to a peer change route and
+// decodes the JSON response into result.
+func peerRequest(method string, target string, key string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(KeyHeader, key)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the peer: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		apiErr := struct {
+			Message string `json:"message"`
+		}{}
+		json.NewDecoder(res.Body).Decode(&apiErr)
+		if apiErr.Message == "" {
+			apiErr.Message = res.Status
+		}
+		return fmt.Errorf("the peer rejected the request: %s", apiErr.Message)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid peer response: %w", err)
+	}
+
+	return nil
+}
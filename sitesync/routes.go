@@ -0,0 +1,263 @@
+package sitesync
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"medical-records/accounts"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// registerRoutes exposes the change routes used by the other sites and the
+// admin routes to run the exchanges and review the conflicts.
+func registerRoutes(app core.App, e *core.ServeEvent) {
+	e.Router.GET(changesPath, func(c echo.Context) error {
+		return changesHandler(app, c)
+	}, apis.ActivityLogger(app), requireSiteKey(app))
+
+	e.Router.POST(changesPath, func(c echo.Context) error {
+		return receiveHandler(app, c)
+	}, apis.ActivityLogger(app), requireSiteKey(app))
+
+	admin := e.Router.Group("/api/meds/sync", apis.ActivityLogger(app), accounts.RequireRole(accounts.RoleAdmin))
+
+	admin.GET("/status", func(c echo.Context) error {
+		return statusHandler(app, c)
+	})
+
+	admin.POST("/key", func(c echo.Context) error {
+		key, err := RotateKey(app.Dao())
+		if err != nil {
+			return apis.NewBadRequestError("Failed to change the site key.", err)
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"key": key})
+	})
+
+	admin.POST("/peers", func(c echo.Context) error {
+		return syncPeerHandler(app, c)
+	})
+
+	admin.GET("/bundle", func(c echo.Context) error {
+		return exportBundleHandler(app, c)
+	})
+
+	admin.POST("/bundle", func(c echo.Context) error {
+		return importBundleHandler(app, c)
+	})
+
+	admin.GET("/conflicts", func(c echo.Context) error {
+		conflicts, err := Conflicts(app.Dao(), c.QueryParam("resolved") == "true")
+		if err != nil {
+			return apis.NewBadRequestError("Failed to load the sync conflicts.", err)
+		}
+
+		return c.JSON(http.StatusOK, map[string]any{"items": conflicts})
+	})
+
+	admin.POST("/conflicts/:id/resolve", func(c echo.Context) error {
+		return resolveConflictHandler(app, c)
+	})
+}
+
+// requireSiteKey only allows requests carrying the key of this site.
+func requireSiteKey(app core.App) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			site, err := LoadSite(app.Dao())
+			if err != nil {
+				return apis.NewBadRequestError("Failed to load the site.", err)
+			}
+
+			key := c.Request().Header.Get(KeyHeader)
+			if key == "" || subtle.ConstantTimeCompare([]byte(key), []byte(site.Key)) != 1 {
+				return apis.NewUnauthorizedError("Invalid site key.", nil)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// changesHandler returns the changes recorded after the "since" sequence
+// number.
+func changesHandler(app core.App, c echo.Context) error {
+	since, err := sinceParam(c)
+	if err != nil {
+		return err
+	}
+
+	batch, err := Changes(app.Dao(), since)
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the changes.", err)
+	}
+
+	return c.JSON(http.StatusOK, batch)
+}
+
+// receiveHandler applies the changes pushed by another site.
+func receiveHandler(app core.App, c echo.Context) error {
+	batch := &Batch{}
+	if err := c.Bind(batch); err != nil {
+		return apis.NewBadRequestError("Failed to read the changes.", err)
+	}
+
+	report, err := Received(app.Dao(), batch)
+	if err != nil {
+		return apis.NewBadRequestError("Failed to apply the changes.", err)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// statusHandler describes this site, its peers and the open conflicts.
+func statusHandler(app core.App, c echo.Context) error {
+	site, err := LoadSite(app.Dao())
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the site.", err)
+	}
+
+	peers, err := Peers(app.Dao())
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the peers.", err)
+	}
+
+	conflicts, err := Conflicts(app.Dao(), false)
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the sync conflicts.", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"site":      site,
+		"peers":     peers,
+		"conflicts": len(conflicts),
+	})
+}
+
+// syncPeerHandler exchanges the changes with the site at {"url": ...}
+// using its {"key": ...}.
+func syncPeerHandler(app core.App, c echo.Context) error {
+	body := struct {
+		URL string `json:"url"`
+		Key string `json:"key"`
+	}{}
+	if err := c.Bind(&body); err != nil {
+		return apis.NewBadRequestError("Failed to read the request data.", err)
+	}
+
+	if body.Key == "" {
+		peer, err := findPeerByURL(app, body.URL)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to load the peer.", err)
+		}
+		if peer == nil {
+			return apis.NewBadRequestError("The key of the peer is required.", nil)
+		}
+		body.Key = peer.Key
+	}
+
+	exchange, err := SyncWith(app.Dao(), body.URL, body.Key)
+	if err != nil {
+		return apis.NewApiError(http.StatusBadGateway, fmt.Sprintf("The sync with the peer failed: %v", err), nil)
+	}
+
+	return c.JSON(http.StatusOK, exchange)
+}
+
+// exportBundleHandler downloads the changes recorded after the "since"
+// sequence number (all of them by default) as a bundle file.
+func exportBundleHandler(app core.App, c echo.Context) error {
+	since, err := sinceParam(c)
+	if err != nil {
+		return err
+	}
+
+	site, err := LoadSite(app.Dao())
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the site.", err)
+	}
+
+	name := fmt.Sprintf("meds_sync_%s_%s.jsonl.gz", site.Id, time.Now().Format("20060102_150405"))
+	c.Response().Header().Set("Content-Type", "application/gzip")
+	c.Response().Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	// the headers are sent already, a failure can only cut the download
+	_, err = WriteBundle(app.Dao(), c.Response(), since)
+
+	return err
+}
+
+// importBundleHandler applies the bundle file uploaded as "bundle".
+func importBundleHandler(app core.App, c echo.Context) error {
+	header, err := c.FormFile("bundle")
+	if err != nil {
+		return apis.NewBadRequestError("The bundle file is required.", err)
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return apis.NewBadRequestError("Failed to read the bundle file.", err)
+	}
+	defer file.Close()
+
+	batch, err := ReadBundle(file)
+	if err != nil {
+		return apis.NewBadRequestError(err.Error(), nil)
+	}
+
+	report, err := Received(app.Dao(), batch)
+	if err != nil {
+		return apis.NewBadRequestError("Failed to apply the bundle.", err)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// resolveConflictHandler resolves a conflict with {"keep": "local"|"remote"}.
+func resolveConflictHandler(app core.App, c echo.Context) error {
+	body := struct {
+		Keep string `json:"keep"`
+	}{}
+	if err := c.Bind(&body); err != nil {
+		return apis.NewBadRequestError("Failed to read the request data.", err)
+	}
+
+	if body.Keep != KeepLocal && body.Keep != KeepRemote {
+		return apis.NewBadRequestError(fmt.Sprintf("keep must be %q or %q.", KeepLocal, KeepRemote), nil)
+	}
+
+	conflict, err := ResolveConflict(app.Dao(), c.PathParam("id"), body.Keep)
+	switch {
+	case errors.Is(err, ErrConflictNotFound):
+		return apis.NewNotFoundError("", err)
+	case errors.Is(err, ErrConflictResolved):
+		return apis.NewBadRequestError("The conflict was already resolved.", nil)
+	case err != nil:
+		return apis.NewBadRequestError("Failed to resolve the conflict.", err)
+	}
+
+	return c.JSON(http.StatusOK, conflict)
+}
+
+// sinceParam parses the optional "since" query parameter.
+func sinceParam(c echo.Context) (int64, error) {
+	raw := c.QueryParam("since")
+	if raw == "" {
+		return 0, nil
+	}
+
+	since, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || since < 0 {
+		return 0, apis.NewBadRequestError("The since parameter must be a non-negative number.", nil)
+	}
+
+	return since, nil
+}
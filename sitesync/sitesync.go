@@ -0,0 +1,292 @@
+// Package sitesync exchanges the clinical records between MEDS
+// installations (sites), eg. the laptops of a larger outreach event.
+//
+// Every change of a synced record bumps the site's counter in the record
+// version vector and gives the record a new local change sequence number.
+// Sites exchange the changes made after the last sequence number they saw,
+// either over the LAN or through a bundle file. Changes that were made
+// independently on two sites are kept as conflicts for review, the local
+// version wins until the conflict is resolved.
+//
+// Stock stays per site: inventory.stock and the lots are not exchanged and
+// applied disbursements don't draw from the local lots.
+package sitesync
+
+import (
+	"os"
+
+	"medical-records/hooks"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/list"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// collectionSpec describes how a collection is exchanged.
+type collectionSpec struct {
+	Name string
+
+	// Match lists the fields identifying the same record on another site.
+	// A remote record that is unknown locally but matches a local record
+	// on all of them is aliased to it instead of being created.
+	Match []string
+
+	// Skip lists the fields that are not exchanged.
+	Skip []string
+}
+
+// collections are the synced collections in dependency order.
+// The chief complaint and diagnosis lists are seeded on every site, they
+// are exchanged so that the encounter relations resolve.
+var collections = []collectionSpec{
+	{Name: "chief_complaints", Match: []string{"name"}},
+	{Name: "diagnosis", Match: []string{"name"}},
+	{Name: "inventory", Match: []string{"drug_name", "dose", "unit_size"}, Skip: []string{"stock"}},
+	{Name: "patients"},
+	{Name: "encounters", Skip: []string{"active_editor", "last_edit_activity"}},
+	{Name: "disbursements", Skip: []string{"lot_allocations"}},
+}
+
+// findSpec returns the spec of the synced collection with the given name.
+func findSpec(name string) (collectionSpec, bool) {
+	for _, spec := range collections {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+
+	return collectionSpec{}, false
+}
+
+// collectionNames returns the names of the synced collections.
+func collectionNames() []string {
+	names := make([]string, len(collections))
+	for i, spec := range collections {
+		names[i] = spec.Name
+	}
+
+	return names
+}
+
+// Site identifies this installation to the other sites.
+type Site struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+
+	// Key authenticates the other sites on the change routes.
+	Key string `json:"key"`
+}
+
+// sync_state keys of the Site fields.
+const (
+	stateSiteId   = "site_id"
+	stateSiteName = "site_name"
+	stateKey      = "key"
+)
+
+// LoadSite returns the identity of this installation, creating it on first
+// use. The site name defaults to the hostname.
+func LoadSite(dao *daos.Dao) (*Site, error) {
+	site, err := readSite(dao)
+	if err != nil || (site.Id != "" && site.Name != "" && site.Key != "") {
+		return site, err
+	}
+
+	name, _ := os.Hostname()
+	if name == "" {
+		name = "MEDS"
+	}
+
+	defaults := map[string]string{
+		stateSiteId:   security.RandomStringWithAlphabet(15, "abcdefghijklmnopqrstuvwxyz0123456789"),
+		stateSiteName: name,
+		stateKey:      security.RandomString(32),
+	}
+
+	for key, value := range defaults {
+		_, err := dao.DB().NewQuery(`
+			INSERT INTO sync_state (key, value) VALUES ({:key}, {:value})
+			ON CONFLICT(key) DO NOTHING
+		`).Bind(dbx.Params{"key": key, "value": value}).Execute()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return readSite(dao)
+}
+
+// readSite loads the stored Site fields (if any).
+func readSite(dao *daos.Dao) (*Site, error) {
+	rows := []struct {
+		Key   string `db:"key"`
+		Value string `db:"value"`
+	}{}
+	if err := dao.DB().Select("key", "value").From("sync_state").All(&rows); err != nil {
+		return nil, err
+	}
+
+	site := &Site{}
+	for _, row := range rows {
+		switch row.Key {
+		case stateSiteId:
+			site.Id = row.Value
+		case stateSiteName:
+			site.Name = row.Value
+		case stateKey:
+			site.Key = row.Value
+		}
+	}
+
+	return site, nil
+}
+
+// RotateKey replaces the key the other sites use to reach this site.
+func RotateKey(dao *daos.Dao) (string, error) {
+	key := security.RandomString(32)
+
+	_, err := dao.DB().Update("sync_state", dbx.Params{"value": key}, dbx.HashExp{"key": stateKey}).Execute()
+
+	return key, err
+}
+
+// Register tracks the changes of the synced collections and exposes the
+// sync routes. It must be called before the app is started.
+func Register(app core.App) {
+	track := func(e *core.ModelEvent, deleted bool) error {
+		record, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		return trackChange(e.Dao, record.Collection().Name, record.Id, deleted)
+	}
+
+	names := collectionNames()
+
+	app.OnModelAfterCreate(names...).Add(func(e *core.ModelEvent) error {
+		return track(e, false)
+	})
+
+	app.OnModelAfterUpdate(names...).Add(func(e *core.ModelEvent) error {
+		record, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+
+		// bookkeeping updates (eg. of the stock or the edit lock) are not
+		// changes of the exchanged data
+		spec, _ := findSpec(record.Collection().Name)
+		if !changed(spec, record.OriginalCopy(), record) {
+			return nil
+		}
+
+		return track(e, false)
+	})
+
+	app.OnModelAfterDelete(names...).Add(func(e *core.ModelEvent) error {
+		return track(e, true)
+	})
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		if err := backfill(app.Dao()); err != nil {
+			return err
+		}
+
+		registerRoutes(app, e)
+
+		return nil
+	})
+}
+
+// trackChange records a local change of a synced record.
+func trackChange(dao *daos.Dao, collection string, id string, deleted bool) error {
+	site, err := LoadSite(dao)
+	if err != nil {
+		return err
+	}
+
+	current, err := loadVersion(dao, collection, id)
+	if err != nil {
+		return err
+	}
+
+	vector := Vector{}
+	if current != nil {
+		vector = current.Vector
+	}
+
+	return saveVersion(dao, collection, id, vector.Increment(site.Id), deleted)
+}
+
+// backfill gives a first version to the synced records that have none yet,
+// eg. the seeded lists and the records created before the sync existed.
+func backfill(dao *daos.Dao) error {
+	site, err := LoadSite(dao)
+	if err != nil {
+		return err
+	}
+
+	return dao.RunInTransaction(func(txDao *daos.Dao) error {
+		for _, spec := range collections {
+			ids := []string{}
+			err := txDao.DB().
+				Select(spec.Name+".id").
+				From(spec.Name).
+				LeftJoin("sync_versions", dbx.NewExp(
+					"sync_versions.collection = {:collection} AND sync_versions.record = "+spec.Name+".id",
+					dbx.Params{"collection": spec.Name},
+				)).
+				Where(dbx.NewExp("sync_versions.record IS NULL")).
+				OrderBy(spec.Name + ".created").
+				Column(&ids)
+			if err != nil {
+				return err
+			}
+
+			for _, id := range ids {
+				if err := saveVersion(txDao, spec.Name, id, Vector{site.Id: 1}, false); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// exchangedFields returns the schema fields of collection that are synced.
+func exchangedFields(spec collectionSpec, collection *models.Collection) []string {
+	fields := []string{}
+	for _, field := range collection.Schema.Fields() {
+		if !list.ExistInSlice(field.Name, spec.Skip) {
+			fields = append(fields, field.Name)
+		}
+	}
+
+	return fields
+}
+
+// changed reports whether an exchanged field differs between before and after.
+func changed(spec collectionSpec, before, after *models.Record) bool {
+	for _, field := range exchangedFields(spec, after.Collection()) {
+		if !hooks.SameValue(before.Get(field), after.Get(field)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exportData returns the exchanged fields of record.
+func exportData(spec collectionSpec, record *models.Record) map[string]any {
+	data := map[string]any{"created": record.Created}
+	for _, field := range exchangedFields(spec, record.Collection()) {
+		data[field] = record.Get(field)
+	}
+
+	return data
+}
@@ -0,0 +1,76 @@
+package sitesync
+
+// Vector is the version vector of a record: the number of changes each
+// site made to it.
+type Vector map[string]uint64
+
+// Order is the outcome of comparing two version vectors.
+type Order int
+
+const (
+	// Equal vectors describe the same version.
+	Equal Order = iota
+
+	// Before means the version happened before (is included in) the other.
+	Before
+
+	// After means the version includes the other.
+	After
+
+	// Concurrent versions were changed independently on different sites.
+	Concurrent
+)
+
+// Compare reports how v relates to other.
+func (v Vector) Compare(other Vector) Order {
+	less, greater := false, false
+
+	for site, count := range v {
+		if count > other[site] {
+			greater = true
+		} else if count < other[site] {
+			less = true
+		}
+	}
+	for site, count := range other {
+		if _, ok := v[site]; !ok && count > 0 {
+			less = true
+		}
+	}
+
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	default:
+		return Equal
+	}
+}
+
+// Merge returns a new vector with the highest count of every site of v
+// and other.
+func (v Vector) Merge(other Vector) Vector {
+	merged := make(Vector, len(v)+len(other))
+
+	for site, count := range v {
+		merged[site] = count
+	}
+	for site, count := range other {
+		if count > merged[site] {
+			merged[site] = count
+		}
+	}
+
+	return merged
+}
+
+// Increment returns a copy of v with one more change made by site.
+func (v Vector) Increment(site string) Vector {
+	next := v.Merge(nil)
+	next[site]++
+
+	return next
+}
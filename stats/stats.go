@@ -0,0 +1,259 @@
+// Package stats computes the clinic statistics shown on the launcher
+// dashboard and keeps them up to date from record hooks.
+package stats
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"medical-records/settings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// QueueStatuses lists the queue statuses in workflow order.
+var QueueStatuses = []string{
+	"checked_in",
+	"with_care_team",
+	"ready_pharmacy",
+	"with_pharmacy",
+	"at_checkout",
+	"completed",
+}
+
+// watchedCollections are the collections whose changes affect the stats.
+// The settings hold the clinic timezone, which defines "today".
+var watchedCollections = []string{"patients", "encounters", "queue", "disbursements", "inventory", "settings"}
+
+// debounce groups bursts of record changes into a single refresh.
+const debounce = 500 * time.Millisecond
+
+// Snapshot holds the dashboard statistics at a point in time.
+type Snapshot struct {
+	Patients           int64
+	Encounters         int64
+	CheckInsToday      int64
+	QueueByStatus      map[string]int64
+	DisbursementsToday int64
+	LowStock           int64
+	UpdatedAt          time.Time
+}
+
+// Collect computes a new Snapshot using COUNT queries only.
+// "Today" starts at midnight in the clinic timezone, the queue counts only
+// include today's check-ins.
+func Collect(app core.App) (Snapshot, error) {
+	dao := app.Dao()
+
+	snapshot := Snapshot{
+		QueueByStatus: make(map[string]int64, len(QueueStatuses)),
+		UpdatedAt:     time.Now(),
+	}
+
+	now := time.Now().In(settings.Current(app).Location())
+	midnight, err := types.ParseDateTime(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+	if err != nil {
+		return snapshot, err
+	}
+	today := dbx.NewExp("[[created]] >= {:today}", dbx.Params{"today": midnight.String()})
+
+	if snapshot.Patients, err = count(dao, "patients", nil); err != nil {
+		return snapshot, err
+	}
+
+	if snapshot.Encounters, err = count(dao, "encounters", nil); err != nil {
+		return snapshot, err
+	}
+
+	checkedInToday := dbx.NewExp("[[check_in_time]] >= {:today}", dbx.Params{"today": midnight.String()})
+
+	snapshot.CheckInsToday, err = count(dao, "queue", checkedInToday)
+	if err != nil {
+		return snapshot, err
+	}
+
+	if snapshot.DisbursementsToday, err = count(dao, "disbursements", today); err != nil {
+		return snapshot, err
+	}
+
+	// same threshold as the highlighting on the Inventory page
+	snapshot.LowStock, err = count(dao, "inventory", dbx.NewExp("[[stock]] <= [[fixed_quantity]]"))
+	if err != nil {
+		return snapshot, err
+	}
+
+	rows := []struct {
+		Status string `db:"status"`
+		Total  int64  `db:"total"`
+	}{}
+	err = dao.DB().
+		Select("status", "COUNT(*) AS total").
+		From("queue").
+		Where(checkedInToday).
+		GroupBy("status").
+		All(&rows)
+	if err != nil {
+		return snapshot, err
+	}
+	for _, status := range QueueStatuses {
+		snapshot.QueueByStatus[status] = 0
+	}
+	for _, row := range rows {
+		snapshot.QueueByStatus[row.Status] = row.Total
+	}
+
+	return snapshot, nil
+}
+
+// count returns the number of records in table matching where (if any).
+func count(dao *daos.Dao, table string, where dbx.Expression) (int64, error) {
+	var total int64
+
+	query := dao.DB().Select("COUNT(*)").From(table)
+	if where != nil {
+		query.Where(where)
+	}
+
+	err := query.Row(&total)
+
+	return total, err
+}
+
+// Watcher recomputes the statistics whenever a watched collection changes
+// and at midnight in the clinic timezone, reporting every new snapshot to OnChange.
+type Watcher struct {
+	app      core.App
+	onChange func(Snapshot)
+
+	mu    sync.Mutex
+	timer *time.Timer
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Watch registers the record hooks that keep the statistics up to date.
+// The first snapshot is reported once the app starts serving and the
+// watcher stops when the app terminates.
+func Watch(app core.App, onChange func(Snapshot)) *Watcher {
+	w := &Watcher{app: app, onChange: onChange}
+
+	refresh := func(e *core.ModelEvent) error {
+		w.schedule()
+		return nil
+	}
+
+	for _, name := range watchedCollections {
+		app.OnModelAfterCreate(name).Add(refresh)
+		app.OnModelAfterUpdate(name).Add(refresh)
+		app.OnModelAfterDelete(name).Add(refresh)
+	}
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		w.start()
+		return nil
+	})
+
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		w.Stop()
+		return nil
+	})
+
+	return w
+}
+
+// start reports the initial snapshot and starts the midnight refresh loop.
+func (w *Watcher) start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop != nil {
+		return
+	}
+
+	w.stop = make(chan struct{})
+	w.wg.Add(1)
+
+	go w.loop(w.stop)
+}
+
+// Stop stops the watcher. Record changes made afterwards are ignored.
+func (w *Watcher) Stop() {
+	w.mu.Lock()
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+	if w.timer != nil {
+		if w.timer.Stop() {
+			// the pending refresh will never run
+			w.wg.Done()
+		}
+		w.timer = nil
+	}
+	w.mu.Unlock()
+
+	w.wg.Wait()
+}
+
+func (w *Watcher) loop(stop <-chan struct{}) {
+	defer w.wg.Done()
+
+	w.refresh()
+
+	for {
+		now := time.Now().In(settings.Current(w.app).Location())
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		timer := time.NewTimer(midnight.Sub(now))
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			// the "today" counters start over
+			w.refresh()
+		}
+	}
+}
+
+// schedule refreshes the snapshot after the debounce delay.
+func (w *Watcher) schedule() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop == nil || w.timer != nil {
+		return
+	}
+
+	w.wg.Add(1)
+	w.timer = time.AfterFunc(debounce, func() {
+		defer w.wg.Done()
+
+		w.mu.Lock()
+		running := w.stop != nil
+		w.timer = nil
+		w.mu.Unlock()
+
+		if running {
+			w.refresh()
+		}
+	})
+}
+
+func (w *Watcher) refresh() {
+	if w.app.Dao() == nil {
+		return
+	}
+
+	snapshot, err := Collect(w.app)
+	if err != nil {
+		log.Printf("Failed to collect the dashboard stats: %v", err)
+		return
+	}
+
+	w.onChange(snapshot)
+}
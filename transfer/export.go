@@ -0,0 +1,253 @@
+package transfer
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/list"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Export writes a signed transfer bundle with all the trip records of app
+// to w.
+func Export(app core.App, w io.Writer) (*Manifest, error) {
+	key, err := LoadOrCreateKey(KeysDir(app))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the signing key: %w", err)
+	}
+
+	origin, _ := os.Hostname()
+
+	manifest := &Manifest{
+		Format:       FormatVersion,
+		Id:           security.RandomString(20),
+		CreatedAt:    time.Now().UTC(),
+		Origin:       origin,
+		PublicKey:    EncodePublicKey(key.Public().(ed25519.PublicKey)),
+		RecordCounts: map[string]int{},
+		Files:        map[string]string{},
+	}
+
+	records := map[string][]*models.Record{}
+	refs := map[string][]*models.Record{}
+
+	// load everything at once so that the bundle is consistent, the zip is
+	// written afterwards to not block the writes on a slow download
+	err = app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		err := txDao.DB().
+			Select("COALESCE(MAX([[file]]), '')").
+			From("_migrations").
+			Row(&manifest.SchemaVersion)
+		if err != nil {
+			return err
+		}
+
+		referenced := map[string][]string{}
+
+		for _, name := range collections {
+			items, err := txDao.FindRecordsByExpr(name)
+			if err != nil {
+				return err
+			}
+			records[name] = items
+
+			for _, record := range items {
+				for _, field := range record.Collection().Schema.Fields() {
+					target := relationTarget(txDao, field)
+					if _, ok := references[target]; !ok {
+						continue
+					}
+					referenced[target] = append(referenced[target], record.GetStringSlice(field.Name)...)
+				}
+			}
+		}
+
+		for target, ids := range referenced {
+			items, err := txDao.FindRecordsByIds(target, list.ToUniqueStringSlice(ids))
+			if err != nil {
+				return err
+			}
+			refs[target] = items
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the records: %w", err)
+	}
+
+	zw := zip.NewWriter(w)
+
+	for _, name := range collections {
+		manifest.RecordCounts[name] = len(records[name])
+
+		lines, err := encodeRecords(records[name], skipFields[name])
+		if err != nil {
+			return nil, err
+		}
+		if err := writeEntry(zw, manifest, path.Join(dataDir, name+".jsonl"), lines); err != nil {
+			return nil, err
+		}
+	}
+
+	for target, items := range refs {
+		lines, err := encodeRecords(items, references[target].Skip)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeEntry(zw, manifest, path.Join(refsDir, target+".jsonl"), lines); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeFiles(app, zw, manifest, records); err != nil {
+		return nil, err
+	}
+
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, raw))
+
+	if err := writeRaw(zw, manifestName, raw); err != nil {
+		return nil, err
+	}
+	if err := writeRaw(zw, signatureName, []byte(signature)); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// relationTarget returns the name of the collection a relation field
+// points to, or "" for other fields.
+func relationTarget(dao *daos.Dao, field *schema.SchemaField) string {
+	if field.Type != schema.FieldTypeRelation {
+		return ""
+	}
+
+	options, _ := field.Options.(*schema.RelationOptions)
+	if options == nil {
+		return ""
+	}
+
+	target, err := dao.FindCollectionByNameOrId(options.CollectionId)
+	if err != nil {
+		return ""
+	}
+
+	return target.Name
+}
+
+// encodeRecords returns the JSON lines of records without the skip fields.
+func encodeRecords(records []*models.Record, skip []string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+
+	for _, record := range records {
+		line := bundleRecord{
+			Id:      record.Id,
+			Created: record.Created,
+			Updated: record.Updated,
+			Data:    map[string]any{},
+		}
+
+		for _, field := range record.Collection().Schema.Fields() {
+			if !list.ExistInSlice(field.Name, skip) {
+				line.Data[field.Name] = record.Get(field.Name)
+			}
+		}
+		if record.Collection().IsAuth() {
+			line.Data[schema.FieldNameEmail] = record.Email()
+		}
+
+		if err := encoder.Encode(line); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeFiles copies the uploaded files of records into the bundle.
+func writeFiles(app core.App, zw *zip.Writer, manifest *Manifest, records map[string][]*models.Record) error {
+	for _, name := range collections {
+		for _, record := range records[name] {
+			for _, field := range record.Collection().Schema.Fields() {
+				if field.Type != schema.FieldTypeFile {
+					continue
+				}
+
+				for _, file := range record.GetStringSlice(field.Name) {
+					content, err := readStoredFile(app, record.BaseFilesPath()+"/"+file)
+					if err != nil {
+						return fmt.Errorf("failed to read %s of %s/%s: %w", file, name, record.Id, err)
+					}
+
+					entry := path.Join(filesDir, name, record.Id, file)
+					if err := writeEntry(zw, manifest, entry, content); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// readStoredFile returns the content of an uploaded file.
+func readStoredFile(app core.App, key string) ([]byte, error) {
+	system, err := app.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer system.Close()
+
+	reader, err := system.GetFile(key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// writeEntry adds a checksummed entry to the bundle.
+func writeEntry(zw *zip.Writer, manifest *Manifest, name string, content []byte) error {
+	sum := sha256.Sum256(content)
+	manifest.Files[name] = hex.EncodeToString(sum[:])
+
+	return writeRaw(zw, name, content)
+}
+
+// writeRaw adds an entry to the bundle.
+func writeRaw(zw *zip.Writer, name string, content []byte) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(content)
+
+	return err
+}
@@ -0,0 +1,642 @@
+package transfer
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/list"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/spf13/cast"
+)
+
+// Import errors.
+var (
+	ErrNotABundle       = errors.New("the file is not a MEDS transfer bundle")
+	ErrInvalidSignature = errors.New("the transfer bundle signature is invalid, the file was modified")
+	ErrUntrustedSigner  = errors.New("the transfer bundle was signed by an untrusted installation")
+	ErrAlreadyImported  = errors.New("the transfer bundle was already imported")
+)
+
+// CollectionReport counts what happened to the records of a collection.
+type CollectionReport struct {
+	// Created records were added with a new id.
+	Created int `json:"created"`
+
+	// Matched records were mapped to an existing record (patients with
+	// the same name and date of birth, list entries with the same name).
+	Matched int `json:"matched"`
+
+	// Existing records were imported by an earlier bundle of the same
+	// installation and left untouched.
+	Existing int `json:"existing"`
+
+	// Unresolved list entries have no local counterpart.
+	Unresolved int `json:"unresolved,omitempty"`
+}
+
+// Report describes the outcome of an import.
+type Report struct {
+	Bundle      string                       `json:"bundle"`
+	Origin      string                       `json:"origin"`
+	Signer      string                       `json:"signer"`
+	CreatedAt   time.Time                    `json:"created_at"`
+	ImportedAt  time.Time                    `json:"imported_at"`
+	Collections map[string]*CollectionReport `json:"collections"`
+	References  map[string]*CollectionReport `json:"references"`
+	Files       int                          `json:"files"`
+
+	// Unresolved lists the relations that were left empty.
+	Unresolved []string `json:"unresolved,omitempty"`
+}
+
+// bundleReader reads the verified entries of a bundle.
+type bundleReader struct {
+	manifest *Manifest
+	entries  map[string]*zip.File
+}
+
+// Import adds the records of the transfer bundle read from r (of the given
+// size) to app and returns the import report.
+//
+// The bundle signature and the checksums of all the entries are verified
+// first. A bundle signed by a key that is not in the trusted keys file is
+// only imported when fingerprint confirms the fingerprint of its signer,
+// as compared by an admin with the one of the exporting installation.
+// Each bundle can only be imported once.
+func Import(app core.App, r io.ReaderAt, size int64, fingerprint string) (*Report, error) {
+	bundle, signer, err := openBundle(app, r, size, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Bundle:      bundle.manifest.Id,
+		Origin:      bundle.manifest.Origin,
+		Signer:      Fingerprint(signer),
+		CreatedAt:   bundle.manifest.CreatedAt,
+		ImportedAt:  time.Now().UTC(),
+		Collections: map[string]*CollectionReport{},
+		References:  map[string]*CollectionReport{},
+	}
+
+	importer := &importer{
+		bundle: bundle,
+		signer: report.Signer,
+		report: report,
+		ids:    map[string]map[string]string{},
+	}
+
+	err = app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		importer.dao = txDao
+
+		var previous string
+		err := txDao.DB().
+			Select("id").
+			From("transfer_imports").
+			Where(dbx.HashExp{"id": bundle.manifest.Id}).
+			Row(&previous)
+		if err == nil {
+			return ErrAlreadyImported
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		targets := make([]string, 0, len(references))
+		for target := range references {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+
+		for _, target := range targets {
+			if err := importer.resolveReferences(target); err != nil {
+				return err
+			}
+		}
+
+		for _, name := range collections {
+			if err := importer.importCollection(name); err != nil {
+				return err
+			}
+		}
+
+		raw, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+
+		_, err = txDao.DB().Insert("transfer_imports", dbx.Params{
+			"id":       report.Bundle,
+			"origin":   report.Origin,
+			"signer":   report.Signer,
+			"imported": types.NowDateTime().String(),
+			"report":   string(raw),
+		}).Execute()
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the files are stored once the records exist
+	if err := importer.uploadFiles(app); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// openBundle verifies the signature and the signer of a bundle and returns
+// its reader and the signer key.
+func openBundle(app core.App, r io.ReaderAt, size int64, fingerprint string) (*bundleReader, ed25519.PublicKey, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, ErrNotABundle
+	}
+
+	bundle := &bundleReader{entries: map[string]*zip.File{}}
+	for _, f := range zr.File {
+		bundle.entries[f.Name] = f
+	}
+
+	raw, err := bundle.readRaw(manifestName)
+	if err != nil {
+		return nil, nil, ErrNotABundle
+	}
+	encodedSignature, err := bundle.readRaw(signatureName)
+	if err != nil {
+		return nil, nil, ErrInvalidSignature
+	}
+
+	bundle.manifest = &Manifest{}
+	if err := json.Unmarshal(raw, bundle.manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid transfer bundle manifest: %w", err)
+	}
+	if bundle.manifest.Format < 1 || bundle.manifest.Format > FormatVersion {
+		return nil, nil, fmt.Errorf("unsupported transfer bundle format %d", bundle.manifest.Format)
+	}
+	if bundle.manifest.Id == "" {
+		return nil, nil, ErrNotABundle
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(bundle.manifest.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, nil, ErrInvalidSignature
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSignature)))
+	if err != nil || !ed25519.Verify(publicKey, raw, signature) {
+		return nil, nil, ErrInvalidSignature
+	}
+
+	trusted, err := TrustedKeys(KeysDir(app))
+	if err != nil {
+		return nil, nil, err
+	}
+	// the key comes from the bundle itself, so anyone can sign a bundle
+	// with a valid signature: unlisted signers must be confirmed
+	confirmed := strings.EqualFold(strings.TrimSpace(fingerprint), Fingerprint(publicKey))
+	if !confirmed && !list.ExistInSlice(EncodePublicKey(publicKey), encodeKeys(trusted)) {
+		return nil, nil, fmt.Errorf("%w (%s)", ErrUntrustedSigner, Fingerprint(publicKey))
+	}
+
+	return bundle, ed25519.PublicKey(publicKey), nil
+}
+
+// encodeKeys returns the base64 form of keys.
+func encodeKeys(keys []ed25519.PublicKey) []string {
+	encoded := make([]string, len(keys))
+	for i, key := range keys {
+		encoded[i] = EncodePublicKey(key)
+	}
+
+	return encoded
+}
+
+// readRaw returns the content of a bundle entry without verifying it.
+func (b *bundleReader) readRaw(name string) ([]byte, error) {
+	f, ok := b.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("the transfer bundle has no %s", name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// read returns the content of a bundle entry listed in the manifest after
+// checking its checksum. exists is false for entries the bundle doesn't
+// have (eg. a collection without records).
+func (b *bundleReader) read(name string) (content []byte, exists bool, err error) {
+	checksum, listed := b.manifest.Files[name]
+	if !listed {
+		return nil, false, nil
+	}
+
+	content, err = b.readRaw(name)
+	if err != nil {
+		return nil, true, err
+	}
+
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != checksum {
+		return nil, true, fmt.Errorf("%w (%s)", ErrInvalidSignature, name)
+	}
+
+	return content, true, nil
+}
+
+// readRecords decodes a data or refs file of the bundle.
+func (b *bundleReader) readRecords(name string) ([]bundleRecord, error) {
+	content, exists, err := b.read(name)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	records := []bundleRecord{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		record := bundleRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid record in %s: %w", name, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// pendingFile is an uploaded file to store for an imported record.
+type pendingFile struct {
+	entry string
+	key   string
+}
+
+// importer holds the state of a running import.
+type importer struct {
+	dao    *daos.Dao
+	bundle *bundleReader
+	signer string
+	report *Report
+
+	// ids maps the bundle record ids of every collection to the local ids.
+	ids map[string]map[string]string
+
+	files []pendingFile
+}
+
+// mapId records that the bundle record source of collection is the local
+// record local.
+func (im *importer) mapId(collection string, source string, local string) {
+	if im.ids[collection] == nil {
+		im.ids[collection] = map[string]string{}
+	}
+	im.ids[collection][source] = local
+}
+
+// resolveReferences maps the referenced list records of the bundle to
+// local records, creating the missing ones when allowed.
+func (im *importer) resolveReferences(target string) error {
+	records, err := im.bundle.readRecords(path.Join(refsDir, target+".jsonl"))
+	if err != nil || len(records) == 0 {
+		return err
+	}
+
+	spec := references[target]
+	counts := &CollectionReport{}
+	im.report.References[target] = counts
+
+	collection, err := im.dao.FindCollectionByNameOrId(target)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range records {
+		local, err := findByFields(im.dao, collection, spec.Match, source.Data)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case local != "":
+			counts.Matched++
+		case spec.Create:
+			record := models.NewRecord(collection)
+			for _, field := range collection.Schema.Fields() {
+				if field.Type == schema.FieldTypeRelation || list.ExistInSlice(field.Name, spec.Skip) {
+					continue
+				}
+				if value, ok := source.Data[field.Name]; ok {
+					record.Set(field.Name, value)
+				}
+			}
+
+			if err := im.dao.WithoutHooks().SaveRecord(record); err != nil {
+				return fmt.Errorf("failed to create %s %s: %w", target, source.Id, err)
+			}
+
+			local = record.Id
+			counts.Created++
+		default:
+			counts.Unresolved++
+			continue
+		}
+
+		im.mapId(target, source.Id, local)
+	}
+
+	return nil
+}
+
+// importCollection adds the records of a transferred collection.
+func (im *importer) importCollection(name string) error {
+	records, err := im.bundle.readRecords(path.Join(dataDir, name+".jsonl"))
+	if err != nil {
+		return err
+	}
+
+	counts := &CollectionReport{}
+	im.report.Collections[name] = counts
+
+	collection, err := im.dao.FindCollectionByNameOrId(name)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range records {
+		// records of an earlier bundle are never overwritten
+		local, err := im.previousImport(name, source.Id)
+		if err != nil {
+			return err
+		}
+		if local != "" {
+			im.mapId(name, source.Id, local)
+			counts.Existing++
+			continue
+		}
+
+		if name == "patients" {
+			local, err = findPatient(im.dao, source.Data)
+			if err != nil {
+				return err
+			}
+			if local != "" {
+				im.mapId(name, source.Id, local)
+				counts.Matched++
+				if err := im.saveImported(name, source.Id, local); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		record, err := im.newRecord(collection, source)
+		if err != nil {
+			return err
+		}
+
+		if err := im.dao.WithoutHooks().SaveRecord(record); err != nil {
+			return fmt.Errorf("failed to import %s %s: %w", name, source.Id, err)
+		}
+
+		im.mapId(name, source.Id, record.Id)
+		counts.Created++
+
+		if err := im.saveImported(name, source.Id, record.Id); err != nil {
+			return err
+		}
+
+		for _, field := range collection.Schema.Fields() {
+			if field.Type != schema.FieldTypeFile {
+				continue
+			}
+			for _, file := range record.GetStringSlice(field.Name) {
+				im.files = append(im.files, pendingFile{
+					entry: path.Join(filesDir, name, source.Id, file),
+					key:   record.BaseFilesPath() + "/" + file,
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+// newRecord builds the local copy of a bundle record with a new id and
+// the relations remapped to the local ids.
+func (im *importer) newRecord(collection *models.Collection, source bundleRecord) (*models.Record, error) {
+	record := models.NewRecord(collection)
+	record.Created = source.Created
+	record.Updated = source.Updated
+
+	for _, field := range collection.Schema.Fields() {
+		value, ok := source.Data[field.Name]
+		if !ok || list.ExistInSlice(field.Name, skipFields[collection.Name]) {
+			continue
+		}
+
+		if field.Type == schema.FieldTypeRelation {
+			target := relationTarget(im.dao, field)
+
+			ids := []string{}
+			for _, id := range list.ToUniqueStringSlice(value) {
+				local, err := im.localId(target, id)
+				if err != nil {
+					return nil, err
+				}
+				if local == "" {
+					im.report.Unresolved = append(im.report.Unresolved, fmt.Sprintf(
+						"%s %s: %s %s not found", collection.Name, source.Id, field.Name, id,
+					))
+					continue
+				}
+				ids = append(ids, local)
+			}
+			value = ids
+		}
+
+		record.Set(field.Name, value)
+	}
+
+	return record, nil
+}
+
+// localId returns the local id of a record referenced by the bundle.
+func (im *importer) localId(collection string, source string) (string, error) {
+	if local, ok := im.ids[collection][source]; ok {
+		return local, nil
+	}
+
+	if !list.ExistInSlice(collection, collections) {
+		return "", nil
+	}
+
+	return im.previousImport(collection, source)
+}
+
+// previousImport returns the local record an earlier bundle of the same
+// signer wrote the bundle record source to.
+func (im *importer) previousImport(collection string, source string) (string, error) {
+	var local string
+
+	err := im.dao.DB().
+		Select("local").
+		From("transfer_records").
+		Where(dbx.HashExp{"signer": im.signer, "collection": collection, "source": source}).
+		Row(&local)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return local, err
+}
+
+// saveImported remembers the local record of a bundle record.
+func (im *importer) saveImported(collection string, source string, local string) error {
+	_, err := im.dao.DB().Insert("transfer_records", dbx.Params{
+		"signer":     im.signer,
+		"collection": collection,
+		"source":     source,
+		"local":      local,
+	}).Execute()
+
+	return err
+}
+
+// uploadFiles stores the uploaded files of the imported records.
+func (im *importer) uploadFiles(app core.App) error {
+	if len(im.files) == 0 {
+		return nil
+	}
+
+	system, err := app.NewFilesystem()
+	if err != nil {
+		return err
+	}
+	defer system.Close()
+
+	for _, file := range im.files {
+		content, exists, err := im.bundle.read(file.entry)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			im.report.Unresolved = append(im.report.Unresolved, "missing file "+file.entry)
+			continue
+		}
+
+		if err := system.Upload(content, file.key); err != nil {
+			return fmt.Errorf("failed to store %s: %w", file.entry, err)
+		}
+		im.report.Files++
+	}
+
+	return nil
+}
+
+// findPatient returns the id of the existing patient with the same first
+// name, last name and date of birth as data. Patients without a date of
+// birth are never matched.
+func findPatient(dao *daos.Dao, data map[string]any) (string, error) {
+	first := strings.ToLower(strings.TrimSpace(cast.ToString(data["first_name"])))
+	last := strings.ToLower(strings.TrimSpace(cast.ToString(data["last_name"])))
+	dob, _ := types.ParseDateTime(data["dob"])
+
+	if (first == "" && last == "") || dob.IsZero() {
+		return "", nil
+	}
+
+	patients, err := dao.FindRecordsByExpr("patients", dbx.NewExp(
+		"LOWER(TRIM([[first_name]])) = {:first} AND LOWER(TRIM([[last_name]])) = {:last} AND date([[dob]]) = {:dob}",
+		dbx.Params{"first": first, "last": last, "dob": dob.Time().Format(time.DateOnly)},
+	))
+	if err != nil || len(patients) == 0 {
+		return "", err
+	}
+
+	return patients[0].Id, nil
+}
+
+// findByFields returns the id of the record of collection whose fields
+// all equal the ones in data.
+func findByFields(dao *daos.Dao, collection *models.Collection, fields []string, data map[string]any) (string, error) {
+	exp := dbx.HashExp{}
+	for _, name := range fields {
+		value := data[name]
+		if field := collection.Schema.GetFieldByName(name); field != nil {
+			value = field.PrepareValue(value)
+		}
+		exp[name] = cast.ToString(value)
+	}
+
+	records, err := dao.FindRecordsByExpr(collection.Name, exp)
+	if err != nil || len(records) == 0 {
+		return "", err
+	}
+
+	return records[0].Id, nil
+}
+
+// ImportEntry is a bundle imported earlier.
+type ImportEntry struct {
+	Id       string  `db:"id" json:"id"`
+	Origin   string  `db:"origin" json:"origin"`
+	Signer   string  `db:"signer" json:"signer"`
+	Imported string  `db:"imported" json:"imported"`
+	Report   *Report `db:"-" json:"report"`
+}
+
+// Imports lists the bundles imported so far, newest first.
+func Imports(dao *daos.Dao) ([]*ImportEntry, error) {
+	rows := []struct {
+		ImportEntry
+		RawReport string `db:"report"`
+	}{}
+
+	err := dao.DB().
+		Select("id", "origin", "signer", "imported", "report").
+		From("transfer_imports").
+		OrderBy("imported DESC").
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*ImportEntry, 0, len(rows))
+	for _, row := range rows {
+		entry := row.ImportEntry
+		entry.Report = &Report{}
+		if err := json.Unmarshal([]byte(row.RawReport), entry.Report); err != nil {
+			entry.Report = nil
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,102 @@
+package transfer
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	signingKeyFile  = "signing.key"
+	trustedKeysFile = "trusted_keys"
+)
+
+// LoadOrCreateKey returns the key this installation signs its bundles
+// with, stored in dir. A new one is generated on first use.
+func LoadOrCreateKey(dir string) (ed25519.PrivateKey, error) {
+	keyPath := filepath.Join(dir, signingKeyFile)
+
+	if raw, err := os.ReadFile(keyPath); err == nil {
+		seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, errors.New("invalid bundle signing key")
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(key.Seed()) + "\n"
+	if err := os.WriteFile(keyPath, []byte(encoded), 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// EncodePublicKey returns the base64 form of key used in the manifests and
+// in the trusted keys file.
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// Fingerprint returns a short, human comparable form of a public key.
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	raw := hex.EncodeToString(sum[:8])
+
+	parts := make([]string, 0, len(raw)/4)
+	for i := 0; i < len(raw); i += 4 {
+		parts = append(parts, raw[i:i+4])
+	}
+
+	return strings.ToUpper(strings.Join(parts, "-"))
+}
+
+// TrustedKeys returns the public keys listed in the trusted keys file of
+// dir, one base64 key per line (lines starting with # are comments).
+// Without the file no key is trusted.
+func TrustedKeys(dir string) ([]ed25519.PublicKey, error) {
+	f, err := os.Open(filepath.Join(dir, trustedKeysFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := []ed25519.PublicKey{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// allow a trailing comment with the name of the laptop
+		line, _, _ = strings.Cut(line, " ")
+
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid key in " + trustedKeysFile + ": " + line)
+		}
+		keys = append(keys, ed25519.PublicKey(raw))
+	}
+
+	return keys, scanner.Err()
+}
@@ -0,0 +1,115 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"medical-records/accounts"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Register exposes the transfer routes. It must be called before the app
+// is started.
+func Register(app core.App) {
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		admin := e.Router.Group("/api/meds/transfer", apis.ActivityLogger(app), accounts.RequireRole(accounts.RoleAdmin))
+
+		admin.GET("/export", func(c echo.Context) error {
+			return exportHandler(app, c)
+		})
+
+		admin.POST("/import", func(c echo.Context) error {
+			return importHandler(app, c)
+		})
+
+		admin.GET("/imports", func(c echo.Context) error {
+			imports, err := Imports(app.Dao())
+			if err != nil {
+				return apis.NewBadRequestError("Failed to load the imports.", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]any{"items": imports})
+		})
+
+		admin.GET("/key", func(c echo.Context) error {
+			key, err := LoadOrCreateKey(KeysDir(app))
+			if err != nil {
+				return apis.NewBadRequestError("Failed to load the signing key.", err)
+			}
+
+			public := key.Public().(ed25519.PublicKey)
+
+			return c.JSON(http.StatusOK, map[string]string{
+				"public_key":  EncodePublicKey(public),
+				"fingerprint": Fingerprint(public),
+			})
+		})
+
+		return nil
+	})
+}
+
+// exportHandler downloads a transfer bundle with all the trip records.
+func exportHandler(app core.App, c echo.Context) error {
+	// the bundle is built in memory so that a failure is still reported
+	// as an error instead of a truncated download
+	buf := &bytes.Buffer{}
+	manifest, err := Export(app, buf)
+	if err != nil {
+		return apis.NewBadRequestError("Failed to export the records.", err)
+	}
+
+	name := fmt.Sprintf("meds_transfer_%s.zip", manifest.CreatedAt.Local().Format("20060102_150405"))
+	c.Response().Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+	return c.Blob(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// importHandler imports the transfer bundle uploaded as "bundle". Bundles
+// of untrusted signers also need their signer "fingerprint".
+func importHandler(app core.App, c echo.Context) error {
+	header, err := c.FormFile("bundle")
+	if err != nil {
+		return apis.NewBadRequestError("The bundle file is required.", err)
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return apis.NewBadRequestError("Failed to read the bundle file.", err)
+	}
+	defer file.Close()
+
+	started := time.Now()
+
+	report, err := Import(app, file, header.Size, c.FormValue("fingerprint"))
+	switch {
+	case errors.Is(err, ErrNotABundle),
+		errors.Is(err, ErrInvalidSignature),
+		errors.Is(err, ErrAlreadyImported):
+		return apis.NewBadRequestError(err.Error(), nil)
+	case errors.Is(err, ErrUntrustedSigner):
+		return apis.NewForbiddenError(err.Error(), nil)
+	case err != nil && report == nil:
+		return apis.NewBadRequestError("Failed to import the bundle.", err)
+	case err != nil:
+		// the records are imported, only some files failed to be stored
+		app.Logger().Error("Failed to store the transferred files", "bundle", report.Bundle, "error", err)
+	}
+
+	app.Logger().Info(
+		"Imported transfer bundle",
+		"bundle", report.Bundle,
+		"origin", report.Origin,
+		"signer", report.Signer,
+		"duration", time.Since(started).String(),
+	)
+
+	return c.JSON(http.StatusOK, report)
+}
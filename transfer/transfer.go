@@ -0,0 +1,104 @@
+// Package transfer moves the data of a field trip into another MEDS
+// installation, eg. the central instance of the organisation.
+//
+// A transfer bundle is a zip archive with:
+//
+//	manifest.json                         bundle id, origin, checksums
+//	manifest.sig                          Ed25519 signature of manifest.json
+//	data/<collection>.jsonl               the trip records, one per line
+//	refs/<collection>.jsonl               the list records they reference
+//	files/<collection>/<record>/<name>    the uploaded files of the records
+//
+// The import never changes existing records: every record gets a new id,
+// the relations are remapped to the new ids and the patients are matched
+// to existing patients by name and date of birth.
+package transfer
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// FormatVersion is the version of the bundle layout written by Export.
+const FormatVersion = 1
+
+const (
+	manifestName  = "manifest.json"
+	signatureName = "manifest.sig"
+	dataDir       = "data"
+	refsDir       = "refs"
+	filesDir      = "files"
+)
+
+// collections are the transferred collections in dependency order.
+var collections = []string{
+	"patients",
+	"encounters",
+	"encounter_responses",
+	"disbursements",
+	"queue",
+	"bulk_distributions",
+	"bulk_distribution_items",
+}
+
+// skipFields are bookkeeping fields that only make sense on the
+// installation that wrote them.
+var skipFields = map[string][]string{
+	"encounters":    {"active_editor", "last_edit_activity"},
+	"disbursements": {"lot_allocations"},
+}
+
+// referenceSpec describes how the records of a list collection referenced
+// by the transferred records are found on the importing installation.
+type referenceSpec struct {
+	// Match lists the fields identifying the same record.
+	Match []string
+
+	// Create adds the record when no local record matches, otherwise the
+	// relation is left empty and reported.
+	Create bool
+
+	// Skip lists the fields that are not copied into created records.
+	Skip []string
+}
+
+// references are the list collections referenced by the transferred records.
+var references = map[string]referenceSpec{
+	"care_teams":          {Match: []string{"name"}, Create: true, Skip: []string{"members"}},
+	"chief_complaints":    {Match: []string{"name"}, Create: true},
+	"diagnosis":           {Match: []string{"name"}, Create: true},
+	"inventory":           {Match: []string{"drug_name", "dose", "unit_size"}, Create: true, Skip: []string{"stock"}},
+	"encounter_questions": {Match: []string{"question_text"}},
+	"users":               {Match: []string{"email"}},
+}
+
+// Manifest describes the content of a transfer bundle.
+type Manifest struct {
+	Format        int            `json:"format"`
+	Id            string         `json:"id"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Origin        string         `json:"origin"`
+	SchemaVersion string         `json:"schema_version"`
+	PublicKey     string         `json:"public_key"`
+	RecordCounts  map[string]int `json:"record_counts"`
+
+	// Files maps every other archive entry to its SHA-256 checksum.
+	Files map[string]string `json:"files"`
+}
+
+// bundleRecord is a line of a data or refs file.
+type bundleRecord struct {
+	Id      string         `json:"id"`
+	Created types.DateTime `json:"created"`
+	Updated types.DateTime `json:"updated"`
+	Data    map[string]any `json:"data"`
+}
+
+// KeysDir returns the directory holding the signing key and the trusted
+// keys of app.
+func KeysDir(app core.App) string {
+	return filepath.Join(app.DataDir(), "transfer")
+}
@@ -109,15 +109,14 @@ echo 2. Type 'ipconfig' and press Enter
 echo 3. Look for "IPv4 Address" under your active network adapter
 echo 4. Use this IP address in place of [YOUR-IP] above
 echo.
-echo Default User Accounts:
-echo - Admin Pocketbase Interface user: user@example.com
-echo - Provider accounts: provider@example.com through provider6@example.com
-echo - Pharmacy accounts: pharmacyuser@example.com through pharmacyuser4@example.com
-echo - Admin frontend user: admin@example.com
-echo - All accounts use password: password123
+echo User Accounts:
+echo - On the first start the launcher asks for the Admin Pocketbase Interface user
+echo   and the first admin frontend user, choose your own passwords
+echo - The admin frontend user creates the provider and pharmacy accounts
+echo - Start with --demo to create the demo accounts for trainings, never with real patient data
 echo.
 echo Admin Account Capabilities:
-echo - The admin@example.com account has full access to all functionality
+echo - Admin frontend users have full access to all functionality
 echo - Can access provider and pharmacy views
 echo - Can view all reports and analytics
 echo.
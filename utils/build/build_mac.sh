@@ -104,15 +104,14 @@ To find your IP address:
 3. Look for "inet" under your active network adapter (en0 for WiFi)
 4. Use this IP address in place of [YOUR-IP] above
 
-Default User Accounts:
-- Admin Pocketbase Interface user: user@example.com
-- Provider accounts: provider@example.com through provider6@example.com
-- Pharmacy accounts: pharmacyuser@example.com through pharmacyuser4@example.com
-- Admin frontend user: admin@example.com
-- All accounts use password: password123
+User Accounts:
+- On the first start the launcher asks for the Admin Pocketbase Interface user
+  and the first admin frontend user, choose your own passwords
+- The admin frontend user creates the provider and pharmacy accounts
+- Start with --demo to create the demo accounts for trainings (never with real patient data)
 
 Admin Account Capabilities:
-- The admin@example.com account has full access to all functionality
+- Admin frontend users have full access to all functionality
 - Can access provider and pharmacy views
 - Can view all reports and analytics
 